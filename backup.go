@@ -0,0 +1,278 @@
+package kvdb
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// hot backup
+//
+// Backup write a consistent backup of the running db: badgerdb write its
+// backup stream of the versions at or after since, so it can be incremental;
+// boltdb write a copy of the file and memdb a gob stream of its data, always
+// full. Restore load a backup into a db. BackupDir keep the backups in a
+// directory with a manifest of their checksums, and delete the old ones.
+
+// ErrBackupChecksum the backup file isn't same as its manifest
+var ErrBackupChecksum error = errors.New("kvdb: backup checksum mismatch")
+
+// ErrNoBackup there is no full backup to restore
+var ErrNoBackup error = errors.New("kvdb: no full backup")
+
+// BackupManifest describe a backup
+type BackupManifest struct {
+	File    string // file name in the BackupDir
+	Type    uint8  // KVDB.Type of the db
+	Full    bool
+	Since   uint64 // the badgerdb versions at or after it are in the backup
+	Next    uint64 // since of the next incremental backup, 0 on boltdb and memdb
+	Size    int64
+	SHA256  string // hex
+	Created time.Time
+}
+
+// hashWriter count and hash the bytes written through it
+type hashWriter struct {
+	w io.Writer
+	h hash.Hash
+	n int64
+}
+
+func (w *hashWriter) Write(p []byte) (int, error) {
+	n, err := w.w.Write(p)
+	w.h.Write(p[:n])
+	w.n = w.n + int64(n)
+	return n, err
+}
+
+// Backup write a backup of the db to w, since 0 is full; an incremental
+// badgerdb backup pass the Next of the last backup as since, boltdb and
+// memdb always write a full backup. The writes aren't blocked
+func (d *KVDB) Backup(w io.Writer, since uint64) (BackupManifest, error) {
+	hw := &hashWriter{w: w, h: sha256.New()}
+	var m = BackupManifest{Type: d.Type, Created: time.Now()}
+	var err error
+	if d.Type == 0 {
+		m.Full, m.Since = since == 0, since
+		m.Next, err = d.DH.bg.Backup(hw, since)
+	} else if d.Type == 1 {
+		m.Full = true
+		_, err = d.DH.bt.Backup(hw)
+	} else if d.Type == 2 {
+		m.Full = true
+		err = d.DH.mm.Backup(hw)
+	} else {
+		return m, errType
+	}
+	m.Size, m.SHA256 = hw.n, hex.EncodeToString(hw.h.Sum(nil))
+	return m, err
+}
+
+// Restore load a backup into the opened db target, full is BackupManifest.Full:
+// badgerdb drop all its data before a full backup(the writes are blocked
+// meanwhile) and write the backup to it, the incremental backups are loaded
+// after the full one in order; boltdb replace the db file with it, and it
+// can't be done with open snapshots or iterators; memdb replace all its data
+func Restore(r io.Reader, target *KVDB, full bool) error {
+	var err error
+	if target.Type == 0 {
+		err = target.DH.bg.Load(r, full)
+	} else if target.Type == 1 {
+		err = target.DH.bt.Load(r)
+	} else if target.Type == 2 {
+		err = target.DH.mm.Load(r)
+	} else {
+		return errType
+	}
+	if target.DH.ch != nil {
+		target.DH.ch.InvalidateAll()
+	}
+	return err
+}
+
+// BackupDir a directory of backups, the manifest.json in it list them in
+// order of creation. It's safe for concurrent use
+type BackupDir struct {
+	Dir string
+	// full backups kept with their incremental backups, default 0 keep all
+	Keep int
+
+	mu sync.Mutex
+}
+
+const manifestFile = "manifest.json"
+
+// List the manifests of the backups, oldest first
+func (b *BackupDir) List() ([]BackupManifest, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.list()
+}
+
+func (b *BackupDir) list() ([]BackupManifest, error) {
+	data, err := ioutil.ReadFile(filepath.Join(b.Dir, manifestFile))
+	if os.IsNotExist(err) {
+		return []BackupManifest{}, nil
+	} else if err != nil {
+		return nil, err
+	}
+	var ms []BackupManifest
+	return ms, json.Unmarshal(data, &ms)
+}
+
+// save write the manifest atomically
+func (b *BackupDir) save(ms []BackupManifest) error {
+	data, err := json.MarshalIndent(ms, "", "\t")
+	if err != nil {
+		return err
+	}
+	tmp := filepath.Join(b.Dir, manifestFile+".tmp")
+	if err = ioutil.WriteFile(tmp, data, 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, filepath.Join(b.Dir, manifestFile))
+}
+
+// Backup write a backup of db to the directory: incremental after the last
+// backup unless full, or it isn't badgerdb or there isn't a backup of the same
+// Type; then delete the backups over Keep
+func (b *BackupDir) Backup(db *KVDB, full bool) (BackupManifest, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if err := os.MkdirAll(b.Dir, 0700); err != nil {
+		return BackupManifest{}, err
+	}
+	ms, err := b.list()
+	if err != nil {
+		return BackupManifest{}, err
+	}
+	var since uint64
+	if n := len(ms); !full && n > 0 && ms[n-1].Type == db.Type {
+		since = ms[n-1].Next
+	}
+
+	f, err := ioutil.TempFile(b.Dir, "backup-*.tmp")
+	if err != nil {
+		return BackupManifest{}, err
+	}
+	m, err := db.Backup(f, since)
+	if err == nil {
+		err = f.Sync()
+	}
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		kind := "incr"
+		if m.Full {
+			kind = "full"
+		}
+		m.File = fmt.Sprintf("%s-%s.backup", m.Created.UTC().Format("20060102T150405.000000000"), kind)
+		err = os.Rename(f.Name(), filepath.Join(b.Dir, m.File))
+	}
+	if err != nil {
+		os.Remove(f.Name())
+		return m, err
+	}
+
+	ms = append(ms, m)
+	if err = b.save(ms); err != nil {
+		return m, err
+	}
+	return m, b.retain(ms)
+}
+
+// retain delete the oldest full backups over Keep, with their incremental
+// backups
+func (b *BackupDir) retain(ms []BackupManifest) error {
+	if b.Keep <= 0 {
+		return nil
+	}
+	var fulls []int
+	for i, m := range ms {
+		if m.Full {
+			fulls = append(fulls, i)
+		}
+	}
+	if len(fulls) <= b.Keep {
+		return nil
+	}
+	cut := fulls[len(fulls)-b.Keep]
+	if err := b.save(ms[cut:]); err != nil {
+		return err
+	}
+	for _, m := range ms[:cut] {
+		if err := os.Remove(filepath.Join(b.Dir, m.File)); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	return nil
+}
+
+// Restore verify the checksums of the last full backup and the incremental
+// backups after it, then load them into the opened db target
+func (b *BackupDir) Restore(target *KVDB) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	ms, err := b.list()
+	if err != nil {
+		return err
+	}
+	var chain []BackupManifest
+	for i := len(ms) - 1; i >= 0; i-- {
+		if ms[i].Full {
+			chain = ms[i:]
+			break
+		}
+	}
+	if len(chain) == 0 {
+		return ErrNoBackup
+	}
+	for _, m := range chain {
+		if m.Type != target.Type {
+			return errType
+		} else if err = b.verify(m); err != nil {
+			return err
+		}
+	}
+
+	for _, m := range chain {
+		f, err := os.Open(filepath.Join(b.Dir, m.File))
+		if err != nil {
+			return err
+		}
+		err = Restore(f, target, m.Full)
+		f.Close()
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// verify the backup file has the size and checksum of its manifest
+func (b *BackupDir) verify(m BackupManifest) error {
+	f, err := os.Open(filepath.Join(b.Dir, m.File))
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	h := sha256.New()
+	n, err := io.Copy(h, f)
+	if err != nil {
+		return err
+	} else if n != m.Size || hex.EncodeToString(h.Sum(nil)) != m.SHA256 {
+		return fmt.Errorf("%w: %s", ErrBackupChecksum, m.File)
+	}
+	return nil
+}
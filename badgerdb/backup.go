@@ -0,0 +1,38 @@
+package badgerdb
+
+import "io"
+
+// backup
+//
+// a backup is badger's backup stream: the versions of the keys at or after
+// a version, so a backup after the returned version is incremental. It's
+// written from a read timestamp, the writes aren't blocked. The values are
+// not encrypted in it even if the db is. A full backup replace the db, the
+// data not in it are dropped first.
+
+// maxPendingWrites batches in flight when loading a backup
+const maxPendingWrites = 256
+
+// Backup write the versions at or after since to w, since 0 is a full
+// backup; return the since of the next incremental backup
+func (d *Badger) Backup(w io.Writer, since uint64) (uint64, error) {
+	ts, err := d.DbHandle.Backup(w, since)
+	if err != nil {
+		return 0, err
+	} else if ts < since { // 没有新的版本
+		return since, nil
+	}
+	return ts + 1, nil
+}
+
+// Load write a backup to the db, drop all data first if it's a full backup;
+// the incremental backups must be loaded after the full one in order
+func (d *Badger) Load(r io.Reader, full bool) error {
+	if full {
+		d.releaseVersions() // 序列随备份恢复
+		if err := d.DbHandle.DropAll(); err != nil {
+			return err
+		}
+	}
+	return d.DbHandle.Load(r, maxPendingWrites)
+}
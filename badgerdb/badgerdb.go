@@ -2,9 +2,9 @@ package badgerdb
 
 import (
 	"bytes"
-	"errors"
 	"os"
-	"strings"
+	"sort"
+	"sync"
 	"time"
 
 	"github.com/lysShub/kvdb/com"
@@ -16,20 +16,69 @@ import (
 type Handle = *badger.DB
 
 // Badger badgerdb
-// badgerdb中没有表的概念，使用前缀实现，使用Delimiter区分前缀和字段
+// badgerdb中没有表的概念，使用前缀实现，key的编码见keys.go
 type Badger struct {
-	DbHandle  Handle   //必须，数据库句柄
-	Path      string   //储存路径，默认路径文当前路径db文件夹
-	Password  [16]byte //密码，默认无密码
-	RAM       bool     //内存模式，默认false
-	Delimiter string   //分割符，默认为字符```
+	DbHandle    Handle   //必须，数据库句柄
+	Path        string   //储存路径，默认路径文当前路径db文件夹
+	Password    [16]byte //密码，默认无密码
+	RAM         bool     //内存模式，默认false
+	NumVersions int      //保留的历史版本数，默认1
+	Delimiter   string   //旧格式的分割符，只用于MigrateDb，默认为字符```
+
+	Progress  com.Progress //分批操作的进度回调，可以为nil
+	MergeKeys []string     //使用MergeOperator的计数器key，只支持int64
+
+	GCInterval     time.Duration //后台维护(值日志GC)的间隔，默认0不自动维护
+	GCDiscardRatio float64       //值日志文件中过期数据超过此比例才重写，默认0.5
+	Flatten        bool          //维护时先Flatten LSM树，默认false
+
+	DumpPath     string        //内存模式的转储文件，打开时加载，关闭时写入；默认空不转储
+	DumpInterval time.Duration //内存模式定时转储的间隔，默认0只在关闭时转储
+
+	merges map[string]*badger.MergeOperator
+	seq    *badger.Sequence // 行版本
+	seqMu  sync.Mutex
+	snap   *badger.Txn // 快照的读事务
+	mt     *maintainer
+	dp     *dumper
 }
 
-var errStr error = errors.New("can not include delimiter character")
 var err error
 
+// progressStep report progress every progressStep values in a WriteBatch
+const progressStep = 1 << 16
+
 // OpenDb open db
 func (d *Badger) OpenDb() error {
+	if err := d.open(); err != nil {
+		return err
+	}
+	if err := d.checkFormat(false); err != nil {
+		d.DbHandle.Close()
+		return err
+	}
+	d.startMerges()
+	d.startMaintain()
+	d.startDump()
+	return nil
+}
+
+// MigrateDb open db, rewrite the keys written with the delimiter format
+func (d *Badger) MigrateDb() error {
+	if err := d.open(); err != nil {
+		return err
+	}
+	if err := d.checkFormat(true); err != nil {
+		d.DbHandle.Close()
+		return err
+	}
+	d.startMerges()
+	d.startMaintain()
+	d.startDump()
+	return nil
+}
+
+func (d *Badger) open() error {
 	if d.Path != "" { // 设置路径
 		fi, err := os.Stat(d.Path)
 		if err != nil {
@@ -67,6 +116,9 @@ func (d *Badger) OpenDb() error {
 		opts = badger.DefaultOptions(d.Path)
 	}
 	opts = opts.WithLoggingLevel(badger.ERROR)
+	if d.NumVersions > 1 {
+		opts = opts.WithNumVersionsToKeep(d.NumVersions)
+	}
 
 	if d.Password[:] != nil {
 		opts.EncryptionKey = d.Password[:]
@@ -75,64 +127,168 @@ func (d *Badger) OpenDb() error {
 		d.Delimiter = "```"
 	}
 	opts.ValueLogFileSize = 1 << 29 //512MB
+	opts.VerifyValueChecksum = true // 读取值日志时校验，Check据此发现损坏的值
 
 	db, err := badger.Open(opts)
+	if err != nil {
+		return err
+	}
 	d.DbHandle = db
-	return err
+	if d.dumping() {
+		if err = d.loadDump(); err != nil {
+			db.Close()
+			return err
+		}
+	}
+	return nil
 }
 
-// CloseDb close
-func (d *Badger) Close() error {
-	return d.DbHandle.Close()
+// checkFormat check the key format version, an empty db is marked as the
+// current version; a db without the mark is migrated if migrate is true
+func (d *Badger) checkFormat(migrate bool) error {
+	var version []byte
+	var empty bool
+	err := d.DbHandle.View(func(txn *badger.Txn) error {
+		item, err := txn.Get(formatKey)
+		if err == nil {
+			version, err = item.ValueCopy(nil)
+			return err
+		} else if err != badger.ErrKeyNotFound {
+			return err
+		}
+
+		opt := badger.DefaultIteratorOptions
+		opt.PrefetchValues = false
+		it := txn.NewIterator(opt)
+		defer it.Close()
+		it.Rewind()
+		empty = !it.Valid()
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	if version == nil && empty {
+		return d.DbHandle.Update(func(txn *badger.Txn) error {
+			return txn.Set(formatKey, []byte{formatVersion})
+		})
+	} else if len(version) == 1 && version[0] == formatVersion {
+		return nil
+	} else if version == nil || (len(version) == 1 && version[0] == 0) {
+		// 无标记或迁移被中断
+		if !migrate {
+			return ErrLegacyFormat
+		}
+		return d.migrate()
+	}
+	return ErrFormat
 }
 
-// checkkey
-func (d *Badger) checkkey(ks ...string) bool {
-	for _, k := range ks {
-		if strings.Contains(k, d.Delimiter) {
-			return false
+// migrate rewrite every legacy key to the current format, keep its value,
+// expire time and user meta; it can be rerun after interrupted, the
+// migrated keys are skipped
+func (d *Badger) migrate() error {
+	if err := d.DbHandle.Update(func(txn *badger.Txn) error {
+		return txn.Set(formatKey, []byte{0})
+	}); err != nil {
+		return err
+	}
+
+	wb := d.DbHandle.NewWriteBatch()
+	defer wb.Cancel()
+	err := d.DbHandle.View(func(txn *badger.Txn) error {
+		it := txn.NewIterator(badger.DefaultIteratorOptions)
+		defer it.Close()
+
+		for it.Rewind(); it.Valid(); it.Next() {
+			item := it.Item()
+			k := item.KeyCopy(nil)
+			if k[0] == nsMeta || k[0] == nsKey {
+				// 中断前已迁移；旧格式中以0x00、0x01开头的key不支持
+				continue
+			} else if _, _, _, err := parseCell(k); err == nil || err == errMetaCell {
+				continue
+			}
+
+			nk, err := legacyKey(k, d.Delimiter)
+			if err != nil {
+				return err
+			}
+			v, err := item.ValueCopy(nil)
+			if err != nil {
+				return err
+			}
+			e := badger.NewEntry(nk, v).WithMeta(item.UserMeta())
+			e.ExpiresAt = item.ExpiresAt()
+			if err = wb.SetEntry(e); err != nil {
+				return err
+			}
+			if err = wb.Delete(k); err != nil {
+				return err
+			}
 		}
-		// for _, v := range k {
-		// 	if string(v) == d.Delimiter {
-		// return false
-		// 	}
-		// }
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	if err = wb.Flush(); err != nil {
+		return err
+	}
+
+	return d.DbHandle.Update(func(txn *badger.Txn) error {
+		return txn.Set(formatKey, []byte{formatVersion})
+	})
+}
+
+// CloseDb close
+func (d *Badger) Close() error {
+	d.stopMaintain()
+	d.stopMerges()
+	d.releaseVersions()
+	err := d.stopDump()
+	if cerr := d.DbHandle.Close(); err == nil {
+		err = cerr
 	}
-	return true
+	return err
 }
 
 // key/value
 
 // SetKey
 func (d *Badger) SetKey(key string, value []byte, ttl ...time.Duration) error {
-	if !d.checkkey(key) {
-		return errStr
+	e, err := d.keyEntry(key, value, ttl)
+	if err != nil {
+		return err
+	}
+	if op := d.merges[key]; op != nil { // 与合并互斥
+		op.Lock()
+		defer op.Unlock()
 	}
 	txn := d.DbHandle.NewTransaction(true)
 	defer txn.Discard()
 
-	if len(ttl) == 0 {
-		if err = txn.Set([]byte(key), value); err != nil {
-			return err
-		}
-	} else {
-		if err = txn.SetEntry(
-			badger.NewEntry([]byte(key), value).WithTTL(ttl[0])); err != nil {
-			return err
-		}
+	if err := txn.SetEntry(e); err != nil {
+		return err
 	}
 	return txn.Commit()
 }
 
 // DeleteKey
 func (d *Badger) DeleteKey(key string) error {
-	if !d.checkkey(key) {
-		return errStr
-	}
 	txn := d.DbHandle.NewTransaction(true)
 	defer txn.Discard()
 
-	if err = txn.Delete([]byte(key)); err != nil {
+	var err error
+	if op := d.merges[key]; op != nil {
+		op.Lock()
+		defer op.Unlock()
+		err = txn.SetEntry(deletedEntry(key))
+	} else {
+		err = txn.Delete(keyKey(key))
+	}
+	if err != nil {
 		return err
 	}
 	return txn.Commit()
@@ -140,316 +296,578 @@ func (d *Badger) DeleteKey(key string) error {
 
 // ReadKey
 func (d *Badger) ReadKey(key string) []byte {
-	if !d.checkkey(key) {
-		return nil
-	}
-	txn := d.DbHandle.NewTransaction(false)
-	defer txn.Discard()
-
-	var item *badger.Item
-	if item, err = txn.Get([]byte(key)); err != nil {
-		return nil
-	}
-
-	if err = txn.Commit(); err != nil {
-		return nil
-	}
+	r, _ := d.ReadKeyExpires(key)
+	return r
+}
 
-	var valCopy []byte
-	if valCopy, err = item.ValueCopy(nil); err != nil {
-		return nil
+// ReadKeyExpires read a value and its expire time(unix seconds, 0 is never expire)
+func (d *Badger) ReadKeyExpires(key string) ([]byte, uint64) {
+	if v, ok := d.readMerged(key); ok {
+		return v, 0
 	}
-	return valCopy
+	txn := d.readTxn()
+	defer d.doneTxn(txn)
+	return readValue(txn, keyKey(key))
 }
 
 // table
 
 // SetTable
+// atomic if the table fit in one transaction, otherwise written with a
+// WriteBatch in chunks, a reader may see part of it before return
 func (d *Badger) SetTable(tableName string, t map[string]map[string][]byte, ttl ...time.Duration) error {
-	if !d.checkkey(tableName) {
-		return errStr
+	var n int64
+	for _, kv := range t {
+		n = n + int64(len(kv))
+	}
+	if n < d.DbHandle.MaxBatchCount() {
+		err := d.DbHandle.Update(func(txn *badger.Txn) error {
+			for id, kv := range t {
+				for k, v := range kv {
+					if err := txn.SetEntry(newEntry(cellKey(tableName, id, k), v, ttl)); err != nil {
+						return err
+					}
+				}
+				if _, err := d.touchRow(txn, txn.SetEntry, tableName, id, ttl); err != nil {
+					return err
+				}
+			}
+			return nil
+		})
+		if err != badger.ErrTxnTooBig {
+			return err
+		}
 	}
 
-	txn := d.DbHandle.NewTransaction(true)
-	defer txn.Discard()
-
+	// 超过事务大小限制
+	wb := d.DbHandle.NewWriteBatch()
+	defer wb.Cancel()
+	read := d.DbHandle.NewTransaction(false) // 旧的meta
+	defer read.Discard()
+	var done int
 	for id, kv := range t {
-		if !d.checkkey(id) {
-			return errStr
-		}
-		if !d.checkkey(id) {
-			return errStr
-		}
 		for k, v := range kv {
-			if !d.checkkey(k) {
-				return errStr
+			if err := wb.SetEntry(newEntry(cellKey(tableName, id, k), v, ttl)); err != nil {
+				return err
 			}
-			if len(ttl) == 0 {
-				if err = txn.Set([]byte(tableName+d.Delimiter+id+d.Delimiter+k), v); err != nil {
-					return err
-				}
-			} else {
-				if err = txn.SetEntry(badger.NewEntry([]byte(tableName+d.Delimiter+id+d.Delimiter+k), v).WithTTL(ttl[0])); err != nil {
-					return err
-				}
+			if done++; done%progressStep == 0 && d.Progress != nil {
+				d.Progress("set", tableName, done)
 			}
 		}
+		if _, err := d.touchRow(read, wb.SetEntry, tableName, id, ttl); err != nil {
+			return err
+		}
 	}
-	return txn.Commit()
+	if err := wb.Flush(); err != nil {
+		return err
+	}
+	if d.Progress != nil {
+		d.Progress("set", tableName, done)
+	}
+	return nil
 }
 
 // SetTableRow
 func (d *Badger) SetTableRow(tableName, id string, kv map[string][]byte, ttl ...time.Duration) error {
-	if !d.checkkey(tableName, id) {
-		return errStr
-	}
 	txn := d.DbHandle.NewTransaction(true)
-	it := txn.NewIterator(badger.DefaultIteratorOptions)
 	defer txn.Discard()
 
 	for k, v := range kv {
-		if !d.checkkey(k) {
-			return errStr
-		}
-		if len(ttl) == 0 {
-			if err = txn.Set([]byte(tableName+d.Delimiter+id+d.Delimiter+k), []byte(v)); err != nil {
-				return err
-			}
-		} else {
-			if err = txn.SetEntry(badger.NewEntry([]byte(tableName+d.Delimiter+id+d.Delimiter+k), []byte(v)).WithTTL(ttl[0])); err != nil {
-				return err
-			}
+		if err := txn.SetEntry(newEntry(cellKey(tableName, id, k), v, ttl)); err != nil {
+			return err
 		}
 	}
-	it.Close()
+	if _, err := d.touchRow(txn, txn.SetEntry, tableName, id, ttl); err != nil {
+		return err
+	}
 	return txn.Commit()
 }
 
 // SetTableValue
 func (d *Badger) SetTableValue(tableName, id, field string, value []byte, ttl ...time.Duration) error {
-	if !d.checkkey(tableName, id, field) {
-		return errStr
+	txn := d.DbHandle.NewTransaction(true)
+	defer txn.Discard()
+
+	if err := txn.SetEntry(newEntry(cellKey(tableName, id, field), value, ttl)); err != nil {
+		return err
+	}
+	if _, err := d.touchRow(txn, txn.SetEntry, tableName, id, ttl); err != nil {
+		return err
+	}
+	return txn.Commit()
+}
+
+// ReplaceTableRow set the record to kv, the fields not in kv are deleted
+func (d *Badger) ReplaceTableRow(tableName, id string, kv map[string][]byte, ttl ...time.Duration) error {
+	txn := d.DbHandle.NewTransaction(true)
+	defer txn.Discard()
+
+	if err := d.patchRow(txn, tableName, id, kv, nil, true, ttl); err != nil {
+		return err
 	}
+	return txn.Commit()
+}
+
+// PatchTableRow set the fields in set and delete the fields in unset
+func (d *Badger) PatchTableRow(tableName, id string, set map[string][]byte, unset []string, ttl ...time.Duration) error {
 	txn := d.DbHandle.NewTransaction(true)
-	it := txn.NewIterator(badger.DefaultIteratorOptions)
 	defer txn.Discard()
-	if len(ttl) == 0 {
-		if err = txn.Set([]byte(tableName+d.Delimiter+id+d.Delimiter+field), []byte(value)); err != nil {
+
+	if err := d.patchRow(txn, tableName, id, set, unset, false, ttl); err != nil {
+		return err
+	}
+	return txn.Commit()
+}
+
+// DeleteTableValue delete a field of a record
+func (d *Badger) DeleteTableValue(tableName, id, field string) error {
+	txn := d.DbHandle.NewTransaction(true)
+	defer txn.Discard()
+
+	if err := d.patchRow(txn, tableName, id, nil, []string{field}, false, nil); err != nil {
+		return err
+	}
+	return txn.Commit()
+}
+
+// patchRow delete the fields in unset, or all fields not in set if replace,
+// then set the fields in set; a record without field is deleted with its meta
+func (d *Badger) patchRow(txn *badger.Txn, tableName, id string, set map[string][]byte, unset []string, replace bool, ttl []time.Duration) error {
+	for _, f := range unset {
+		if err := txn.Delete(cellKey(tableName, id, f)); err != nil {
 			return err
 		}
-	} else {
-		if err = txn.SetEntry(badger.NewEntry([]byte(tableName+d.Delimiter+id+d.Delimiter+field), []byte(value)).WithTTL(ttl[0])); err != nil {
+	}
+	if replace {
+		var drop [][]byte
+		opt := badger.DefaultIteratorOptions
+		opt.PrefetchValues = false
+		opt.Prefix = fieldsPrefix(tableName, id)
+		it := txn.NewIterator(opt)
+		for it.Seek(opt.Prefix); it.ValidForPrefix(opt.Prefix); it.Next() {
+			_, _, f, err := parseCell(it.Item().Key())
+			if err != nil {
+				it.Close()
+				return err
+			}
+			if _, ok := set[f]; !ok {
+				drop = append(drop, it.Item().KeyCopy(nil))
+			}
+		}
+		it.Close()
+		for _, k := range drop {
+			if err := txn.Delete(k); err != nil {
+				return err
+			}
+		}
+	}
+	for f, v := range set {
+		if err := txn.SetEntry(newEntry(cellKey(tableName, id, f), v, ttl)); err != nil {
 			return err
 		}
 	}
-	it.Close()
-	return txn.Commit()
+	if len(set) == 0 && !existPrefix(txn, fieldsPrefix(tableName, id)) {
+		return txn.Delete(metaKey(tableName, id))
+	}
+	_, err := d.touchRow(txn, txn.SetEntry, tableName, id, ttl)
+	return err
 }
 
 // DeleteTable
+// atomic if the table fit in one transaction, otherwise the table is
+// dropped by DropPrefix, which block all writes of the db when running
 func (d *Badger) DeleteTable(tableName string) error {
-	if !d.checkkey(tableName) {
-		return errStr
+	prefix := tablePrefix(tableName)
+	err := d.DbHandle.Update(func(txn *badger.Txn) error {
+		if err := txn.Delete(tableKey(tableName)); err != nil {
+			return err
+		}
+		return deletePrefix(txn, prefix)
+	})
+	if err != badger.ErrTxnTooBig {
+		return err
 	}
-	txn := d.DbHandle.NewTransaction(true)
-	it := txn.NewIterator(badger.DefaultIteratorOptions)
-	defer txn.Discard()
-
-	prefix := []byte(tableName + d.Delimiter)
 
-	for it.Seek(prefix); it.ValidForPrefix(prefix); it.Next() {
-		if err = txn.Delete(it.Item().Key()); err != nil {
-			return err
+	// 超过事务大小限制
+	var done int
+	if d.Progress != nil {
+		txn := d.DbHandle.NewTransaction(false)
+		opt := badger.DefaultIteratorOptions
+		opt.PrefetchValues = false
+		opt.Prefix = prefix
+		it := txn.NewIterator(opt)
+		for it.Seek(prefix); it.ValidForPrefix(prefix); it.Next() {
+			done++
 		}
+		it.Close()
+		txn.Discard()
 	}
-	it.Close()
-	return txn.Commit()
+	if err = d.DbHandle.DropPrefix(prefix); err != nil {
+		return err
+	} else if err = d.DbHandle.Update(func(txn *badger.Txn) error {
+		return txn.Delete(tableKey(tableName))
+	}); err != nil {
+		return err
+	}
+	if d.Progress != nil {
+		d.Progress("delete", tableName, done)
+	}
+	return nil
 }
 
 // DeleteTableRow
 func (d *Badger) DeleteTableRow(tableName, id string) error {
-	if !d.checkkey(tableName, id) {
-		return errStr
-	}
 	txn := d.DbHandle.NewTransaction(true)
-	it := txn.NewIterator(badger.DefaultIteratorOptions)
 	defer txn.Discard()
 
-	prefix := []byte(tableName + d.Delimiter + id + d.Delimiter)
-
-	for it.Seek(prefix); it.ValidForPrefix(prefix); it.Next() {
-		if err = txn.Delete(it.Item().Key()); err != nil {
-			return err
-		}
+	if err := deletePrefix(txn, rowPrefix(tableName, id)); err != nil {
+		return err
 	}
-	it.Close()
 	return txn.Commit()
 }
 
 // ReadTable
 func (d *Badger) ReadTable(tableName string) map[string]map[string][]byte {
-	if !d.checkkey(tableName) {
-		return nil
-	}
-	var r map[string]map[string][]byte = make(map[string]map[string][]byte)
-	var sr map[string][]byte = make(map[string][]byte)
-	var tmpID string = ""
+	txn := d.readTxn()
+	defer d.doneTxn(txn)
+	return readTable(txn, tableName)
+}
 
-	txn := d.DbHandle.NewTransaction(false)
-	it := txn.NewIterator(badger.DefaultIteratorOptions)
-	defer txn.Discard()
+// ReadTableExist
+func (d *Badger) ReadTableExist(tableName string) bool {
+	txn := d.readTxn()
+	defer d.doneTxn(txn)
+	return tableExist(txn, tableName)
+}
 
-	var deByte, v []byte = []byte(d.Delimiter), nil
-	prefix := []byte(tableName + d.Delimiter)
+// ReadTableRow
+func (d *Badger) ReadTableRow(tableName, id string) map[string][]byte {
+	r, _ := d.ReadTableRowExpires(tableName, id)
+	return r
+}
 
-	for it.Seek(prefix); it.ValidForPrefix(prefix); it.Next() {
-		rk := bytes.SplitN(it.Item().Key(), deByte, 3)
-		if len(rk) != 3 {
-			return nil
-		}
-		if string(rk[1]) != tmpID {
-			if tmpID != "" {
-				r[tmpID] = sr
-			}
-			sr = make(map[string][]byte)
-			tmpID = string(rk[1])
-		}
-		if v, err = it.Item().ValueCopy(nil); err != nil {
-			return nil
-		}
-		sr[string(rk[2])] = v
-	}
-	r[tmpID] = sr
-	it.Close()
+// ReadTableRowExpires read a record and the earliest expire time of its fields
+func (d *Badger) ReadTableRowExpires(tableName, id string) (map[string][]byte, uint64) {
+	txn := d.readTxn()
+	defer d.doneTxn(txn)
+	return readTableRow(txn, tableName, id)
+}
 
+// ReadTableRowExist
+func (d *Badger) ReadTableRowExist(tableName, id string) bool {
+	txn := d.readTxn()
+	defer d.doneTxn(txn)
+	return existPrefix(txn, fieldsPrefix(tableName, id))
+}
+
+// ReadTableValue
+func (d *Badger) ReadTableValue(tableName, id, field string) []byte {
+	r, _ := d.ReadTableValueExpires(tableName, id, field)
 	return r
 }
 
-// ReadTableExist
-func (d *Badger) ReadTableExist(tableName string) bool {
-	if !d.checkkey(tableName) {
-		return false
+// ReadTableValueExpires read a field's value and its expire time
+func (d *Badger) ReadTableValueExpires(tableName, id, field string) ([]byte, uint64) {
+	txn := d.readTxn()
+	defer d.doneTxn(txn)
+	return readValue(txn, cellKey(tableName, id, field))
+}
+
+// ReadTableLimits
+func (d *Badger) ReadTableLimits(tableName, field, exp string, value int) []string {
+	txn := d.readTxn()
+	defer d.doneTxn(txn)
+	return readTableLimits(txn, tableName, field, exp, value)
+}
+
+// read helpers, shared by all read paths
+
+func newEntry(k, v []byte, ttl []time.Duration) *badger.Entry {
+	e := badger.NewEntry(k, v)
+	if len(ttl) != 0 && ttl[0] > 0 {
+		e = e.WithTTL(ttl[0])
 	}
-	txn := d.DbHandle.NewTransaction(false)
-	it := txn.NewIterator(badger.DefaultIteratorOptions)
-	defer txn.Discard()
+	return e
+}
 
-	prefix := []byte(tableName + d.Delimiter)
+func readValue(txn *badger.Txn, k []byte) ([]byte, uint64) {
+	item, err := txn.Get(k)
+	if err != nil {
+		return nil, 0
+	}
+	v, err := item.ValueCopy(nil)
+	if err != nil {
+		return nil, 0
+	}
+	return v, item.ExpiresAt()
+}
 
-	R := false
-	for it.Seek(prefix); it.ValidForPrefix(prefix); it.Next() {
-		R = true
+// tableExist the table has record or is kept by TruncateTable
+func tableExist(txn *badger.Txn, tableName string) bool {
+	if _, err := txn.Get(tableKey(tableName)); err == nil {
+		return true
 	}
-	it.Close()
+	return existPrefix(txn, tablePrefix(tableName))
+}
+
+func existPrefix(txn *badger.Txn, prefix []byte) bool {
+	opt := badger.DefaultIteratorOptions
+	opt.PrefetchValues = false
+	opt.Prefix = prefix
+	it := txn.NewIterator(opt)
+	defer it.Close()
 
-	return R
+	it.Seek(prefix)
+	return it.ValidForPrefix(prefix)
 }
 
-// ReadTableRow
-func (d *Badger) ReadTableRow(tableName, id string) map[string][]byte {
-	if !d.checkkey(tableName, id) {
-		return nil
+// deletePrefix delete all keys with the prefix in a update transaction
+func deletePrefix(txn *badger.Txn, prefix []byte) error {
+	opt := badger.DefaultIteratorOptions
+	opt.PrefetchValues = false
+	opt.Prefix = prefix
+	it := txn.NewIterator(opt)
+	defer it.Close()
+
+	for it.Seek(prefix); it.ValidForPrefix(prefix); it.Next() {
+		if err := txn.Delete(it.Item().KeyCopy(nil)); err != nil {
+			return err
+		}
 	}
-	var r map[string][]byte = make(map[string][]byte)
-	txn := d.DbHandle.NewTransaction(false)
-	it := txn.NewIterator(badger.DefaultIteratorOptions)
-	defer txn.Discard()
+	return nil
+}
 
-	prefix := []byte(tableName + d.Delimiter + id + d.Delimiter)
+func readTable(txn *badger.Txn, tableName string) map[string]map[string][]byte {
+	var r map[string]map[string][]byte = make(map[string]map[string][]byte)
+
+	opt := badger.DefaultIteratorOptions
+	prefix := tablePrefix(tableName)
+	opt.Prefix = prefix
+	it := txn.NewIterator(opt)
+	defer it.Close()
 
 	for it.Seek(prefix); it.ValidForPrefix(prefix); it.Next() {
-		rk := bytes.SplitN(it.Item().KeyCopy(nil), []byte(d.Delimiter), 3)
-		if len(rk) == 3 {
-			r[string(rk[2])], err = it.Item().ValueCopy(nil)
-			if err != nil {
-				return nil
-			}
-		} else {
+		_, id, field, err := parseCell(it.Item().Key())
+		if err == errMetaCell {
+			continue
+		} else if err != nil {
 			return nil
 		}
-
+		v, err := it.Item().ValueCopy(nil)
+		if err != nil {
+			return nil
+		}
+		if r[id] == nil {
+			r[id] = make(map[string][]byte)
+		}
+		r[id][field] = v
 	}
-	it.Close()
-
 	return r
 }
 
-// ReadTableRowExist
-func (d *Badger) ReadTableRowExist(tableName, id string) bool {
-	if !d.checkkey(tableName, id) {
-		return false
+func readTableRow(txn *badger.Txn, tableName, id string) (map[string][]byte, uint64) {
+	var r map[string][]byte = make(map[string][]byte)
+	var expiresAt uint64
+
+	opt := badger.DefaultIteratorOptions
+	prefix := fieldsPrefix(tableName, id)
+	opt.Prefix = prefix
+	it := txn.NewIterator(opt)
+	defer it.Close()
+
+	for it.Seek(prefix); it.ValidForPrefix(prefix); it.Next() {
+		_, _, field, err := parseCell(it.Item().Key())
+		if err != nil {
+			return nil, 0
+		}
+		v, err := it.Item().ValueCopy(nil)
+		if err != nil {
+			return nil, 0
+		}
+		r[field] = v
+		if e := it.Item().ExpiresAt(); e != 0 && (expiresAt == 0 || e < expiresAt) {
+			expiresAt = e
+		}
 	}
+	return r, expiresAt
+}
 
-	txn := d.DbHandle.NewTransaction(false)
-	it := txn.NewIterator(badger.DefaultIteratorOptions)
-	defer txn.Discard()
+func readTableLimits(txn *badger.Txn, tableName, field, exp string, value int) []string {
+	var r []string
+
+	opt := badger.DefaultIteratorOptions
+	opt.PrefetchValues = false
+	prefix := tablePrefix(tableName)
+	opt.Prefix = prefix
+	it := txn.NewIterator(opt)
+	defer it.Close()
 
-	prefix := []byte(tableName + d.Delimiter + id + d.Delimiter)
-	var r bool = false
 	for it.Seek(prefix); it.ValidForPrefix(prefix); it.Next() {
-		r = true
-		break
+		_, id, f, err := parseCell(it.Item().Key())
+		if err == errMetaCell {
+			continue
+		} else if err != nil {
+			return nil
+		}
+		if f != field {
+			continue
+		}
+		v, err := it.Item().ValueCopy(nil)
+		if err != nil {
+			return nil
+		}
+		fag, err := com.ExpressionCalculate(exp, value, v)
+		if err != nil {
+			return nil
+		}
+		if fag {
+			r = append(r, id)
+		}
 	}
-	it.Close()
 	return r
 }
 
-// ReadTableValue
-func (d *Badger) ReadTableValue(tableName, id, field string) []byte {
-	if !d.checkkey(tableName, id, field) {
-		return nil
-	}
-	var r []byte
-	txn := d.DbHandle.NewTransaction(false)
-	it := txn.NewIterator(badger.DefaultIteratorOptions)
-	defer txn.Discard()
+// introspection
 
-	prefix := []byte(tableName + d.Delimiter + id + d.Delimiter + field)
-	for it.Seek(prefix); it.ValidForPrefix(prefix); it.Next() {
+// ListTables all table names, in order
+func (d *Badger) ListTables() []string {
+	txn := d.readTxn()
+	defer d.doneTxn(txn)
+	return listTables(txn)
+}
+
+// ListRowIDs ids in a table, in order
+func (d *Badger) ListRowIDs(tableName string, opts com.ListOptions) []string {
+	txn := d.readTxn()
+	defer d.doneTxn(txn)
+	return listRowIDs(txn, tableName, opts)
+}
+
+// ListFields all fields in a table and the count of records having it
+func (d *Badger) ListFields(tableName string) map[string]int {
+	txn := d.readTxn()
+	defer d.doneTxn(txn)
+	return listFields(txn, tableName)
+}
+
+// CountRows count of records in a table
+func (d *Badger) CountRows(tableName string) int {
+	txn := d.readTxn()
+	defer d.doneTxn(txn)
+
+	var n int
+	walkRowIDs(txn, tableName, com.ListOptions{}, func(string) bool {
+		n++
+		return true
+	})
+	return n
+}
+
+func listTables(txn *badger.Txn) []string {
+	var r []string = []string{}
+
+	opt := badger.DefaultIteratorOptions
+	opt.PrefetchValues = false
+	opt.Prefix = []byte{nsTable}
+	it := txn.NewIterator(opt)
+	defer it.Close()
 
-		if r, err = it.Item().ValueCopy(nil); err != nil {
+	for it.Seek(opt.Prefix); it.ValidForPrefix(opt.Prefix); {
+		tableName, err := parseTable(it.Item().Key())
+		if err != nil {
 			return nil
 		}
+		r = append(r, tableName)
+		it.Seek(prefixEnd(tablePrefix(tableName))) // 跳过表内其他key
 	}
-	it.Close()
 
+	// 清空后没有记录的表
+	var n = len(r)
+	opt.Prefix = []byte{nsMeta, 't'}
+	mt := txn.NewIterator(opt)
+	defer mt.Close()
+	for mt.Seek(opt.Prefix); mt.ValidForPrefix(opt.Prefix); mt.Next() {
+		_, s, _, err := readSegment(mt.Item().Key()[len(opt.Prefix):])
+		if err != nil {
+			return nil
+		}
+		if i := sort.SearchStrings(r[:n], string(s)); i == n || r[i] != string(s) {
+			r = append(r, string(s))
+		}
+	}
+	if len(r) != n {
+		sort.Strings(r)
+	}
 	return r
 }
 
-// ReadTableLimits
-func (d *Badger) ReadTableLimits(tableName, field, exp string, value int) []string {
-	if !d.checkkey(tableName, field) {
+func listRowIDs(txn *badger.Txn, tableName string, opts com.ListOptions) []string {
+	var r []string = []string{}
+	if err := walkRowIDs(txn, tableName, opts, func(id string) bool {
+		r = append(r, id)
+		return true
+	}); err != nil {
 		return nil
 	}
-	var r []string
-	txn := d.DbHandle.NewTransaction(false)
-	it := txn.NewIterator(badger.DefaultIteratorOptions)
-	defer txn.Discard()
+	return r
+}
 
-	prefix := []byte(tableName + d.Delimiter)
+// walkRowIDs call fn with every id in order, until fn return false
+func walkRowIDs(txn *badger.Txn, tableName string, opts com.ListOptions, fn func(id string) bool) error {
+	var n int
 
-	var deByte, v []byte = []byte(d.Delimiter), nil
-	var rs [][]byte
-	for it.Seek(prefix); it.ValidForPrefix(prefix); it.Next() {
-		rs = bytes.SplitN(it.Item().Key(), deByte, 3)
-		if len(rs) != 3 {
-			return nil
+	iopt := badger.DefaultIteratorOptions
+	iopt.PrefetchValues = false
+	iopt.Prefix = idPrefix(tableName, opts.Prefix)
+	it := txn.NewIterator(iopt)
+	defer it.Close()
+
+	var start []byte = iopt.Prefix
+	if opts.After != "" {
+		if s := prefixEnd(rowPrefix(tableName, opts.After)); bytes.Compare(s, start) > 0 {
+			start = s
 		}
-		if string(rs[2]) == field {
-			if v, err = it.Item().ValueCopy(nil); err != nil {
-				return nil
-			}
-			fag, err := com.ExpressionCalculate(exp, value, v)
-			if err != nil {
-				return nil
-			}
-			if fag {
-				r = append(r, string(rs[1]))
+	}
+	for it.Seek(start); it.ValidForPrefix(iopt.Prefix); {
+		_, id, _, err := parseCell(it.Item().Key())
+		rp := rowPrefix(tableName, id)
+		if err == errMetaCell {
+			// 字段已过期或删除，只剩meta的行
+			if it.Next(); !it.ValidForPrefix(rp) {
+				continue
 			}
+		} else if err != nil {
+			return err
+		}
+		if opts.Limit > 0 && n >= opts.Limit {
+			break
 		}
+		if !fn(id) {
+			break
+		}
+		n++
+		it.Seek(prefixEnd(rp)) // 跳过行内其他字段
 	}
+	return nil
+}
 
-	it.Close()
+func listFields(txn *badger.Txn, tableName string) map[string]int {
+	var r map[string]int = make(map[string]int)
 
+	opt := badger.DefaultIteratorOptions
+	opt.PrefetchValues = false
+	opt.Prefix = tablePrefix(tableName)
+	it := txn.NewIterator(opt)
+	defer it.Close()
+
+	for it.Seek(opt.Prefix); it.ValidForPrefix(opt.Prefix); it.Next() {
+		_, _, field, err := parseCell(it.Item().Key())
+		if err == errMetaCell {
+			continue
+		} else if err != nil {
+			return nil
+		}
+		r[field]++
+	}
 	return r
 }
@@ -0,0 +1,81 @@
+package badgerdb
+
+import (
+	"time"
+
+	"github.com/lysShub/kvdb/com"
+
+	badger "github.com/dgraph-io/badger/v2"
+)
+
+// WriteBatch write the ops in order, committed in chunks when over the
+// transaction size limit; if atomic, in one transaction, and return
+// com.ErrBatchTooBig if they don't fit in it
+func (d *Badger) WriteBatch(ops []com.BatchOp, atomic bool) error {
+	for _, op := range ops {
+		if op.Kind == com.OpSetKey && op.TTL > 0 && d.merges[op.Key] != nil {
+			return errMergeTTL
+		}
+	}
+	w := d.newWriter(atomic, "", "")
+	defer w.discard()
+
+	for _, op := range ops {
+		var err error
+		var ttl = []time.Duration{op.TTL}
+		switch op.Kind {
+		case com.OpSetKey:
+			var e *badger.Entry
+			if e, err = d.keyEntry(op.Key, op.Value, ttl); err == nil {
+				err = w.set(e)
+			}
+		case com.OpDeleteKey:
+			if d.merges[op.Key] != nil {
+				err = w.set(deletedEntry(op.Key))
+			} else {
+				err = w.delete(keyKey(op.Key))
+			}
+		case com.OpSetRow:
+			for f, v := range op.Row {
+				if err = w.set(newEntry(cellKey(op.Table, op.ID, f), v, ttl)); err != nil {
+					break
+				}
+			}
+			if err == nil {
+				_, err = d.touchRow(w.txn, w.set, op.Table, op.ID, ttl)
+			}
+		case com.OpSetValue:
+			if err = w.set(newEntry(cellKey(op.Table, op.ID, op.Field), op.Value, ttl)); err == nil {
+				_, err = d.touchRow(w.txn, w.set, op.Table, op.ID, ttl)
+			}
+		case com.OpDeleteRow:
+			// 先取出key，删除时事务可能会更换
+			for _, k := range prefixKeys(w.txn, rowPrefix(op.Table, op.ID)) {
+				if err = w.delete(k); err != nil {
+					break
+				}
+			}
+		}
+		if err == badger.ErrTxnTooBig {
+			return com.ErrBatchTooBig
+		} else if err != nil {
+			return err
+		}
+	}
+	return w.commit()
+}
+
+// prefixKeys all keys with the prefix
+func prefixKeys(txn *badger.Txn, prefix []byte) [][]byte {
+	opt := badger.DefaultIteratorOptions
+	opt.PrefetchValues = false
+	opt.Prefix = prefix
+	it := txn.NewIterator(opt)
+	defer it.Close()
+
+	var r [][]byte
+	for it.Seek(prefix); it.ValidForPrefix(prefix); it.Next() {
+		r = append(r, it.Item().KeyCopy(nil))
+	}
+	return r
+}
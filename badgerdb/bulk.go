@@ -0,0 +1,84 @@
+package badgerdb
+
+import (
+	"bytes"
+	"errors"
+	"sort"
+
+	"github.com/lysShub/kvdb/com"
+
+	badger "github.com/dgraph-io/badger/v2"
+	"github.com/dgraph-io/badger/v2/pb"
+)
+
+// ErrNotEmpty bulk loading need a empty db
+var ErrNotEmpty error = errors.New("badgerdb: bulk loading need a empty database")
+
+// StreamLoader load sorted records into a empty db by badger's StreamWriter,
+// which build the LSM tree directly without transaction
+type StreamLoader struct {
+	d  *Badger
+	sw *badger.StreamWriter
+}
+
+// NewStreamLoader the db must be empty, it's written only by the loader
+// until Flush; resume skip the check when restarting an interrupted loading,
+// the partly loaded db is cleared
+func (d *Badger) NewStreamLoader(resume bool) (*StreamLoader, error) {
+	var empty = resume
+	err := d.DbHandle.View(func(txn *badger.Txn) error {
+		if resume {
+			return nil
+		}
+		opt := badger.DefaultIteratorOptions
+		opt.PrefetchValues = false
+		it := txn.NewIterator(opt)
+		defer it.Close()
+		empty = true
+		for it.Rewind(); it.Valid(); it.Next() {
+			if !bytes.Equal(it.Item().Key(), formatKey) {
+				empty = false
+				break
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	} else if !empty {
+		return nil, ErrNotEmpty
+	}
+
+	sw := d.DbHandle.NewStreamWriter()
+	if err = sw.Prepare(); err != nil { // 清空db，包括formatKey
+		return nil, err
+	}
+	l := &StreamLoader{d: d, sw: sw}
+	return l, sw.Write(&pb.KVList{Kv: []*pb.KV{{Key: formatKey, Value: []byte{formatVersion}, Version: 1}}})
+}
+
+// Write rows must be in order of (Table, ID) and after the rows written before
+func (l *StreamLoader) Write(rows []com.BulkRow) error {
+	var kvs []*pb.KV
+	var fields []string
+	for _, r := range rows {
+		fields = fields[:0]
+		for f := range r.Row {
+			fields = append(fields, f)
+		}
+		sort.Strings(fields)
+		for _, f := range fields {
+			kvs = append(kvs, &pb.KV{
+				Key:     cellKey(r.Table, r.ID, f),
+				Value:   r.Row[f],
+				Version: 1,
+			})
+		}
+	}
+	return l.sw.Write(&pb.KVList{Kv: kvs})
+}
+
+// Flush finish the loading, the db can be used after it
+func (l *StreamLoader) Flush() error {
+	return l.sw.Flush()
+}
@@ -0,0 +1,102 @@
+package badgerdb
+
+import (
+	"bytes"
+	"encoding/json"
+
+	"github.com/lysShub/kvdb/com"
+
+	badger "github.com/dgraph-io/badger/v2"
+)
+
+// integrity check
+//
+// Check verify the checksums of badger's tables, then read every value(the
+// db is opened with VerifyValueChecksum, a value that can't be read or
+// doesn't match its checksum in the value log is a storage issue) and decode
+// every key by the key encoding(see keys.go): a table key must be
+// table/id/field or a row meta, a trash key must belong to a trash item with
+// its info. The readers skip a key they can't decode, Check report it.
+
+// Check check the db, the problems are in the report; the error is a failure
+// to run the check
+func (d *Badger) Check() (com.CheckReport, error) {
+	var r com.CheckReport
+	if err := d.DbHandle.VerifyChecksum(); err != nil {
+		r.Add(com.IssueStorage, nil, err.Error())
+	}
+
+	txn := d.readTxn()
+	defer d.doneTxn(txn)
+	it := txn.NewIterator(badger.DefaultIteratorOptions)
+	defer it.Close()
+
+	var table, row []byte // 上一个表和记录的前缀
+	var trash string      // 上一个有info的回收站项
+	var v []byte
+	for it.Rewind(); it.Valid(); it.Next() {
+		item := it.Item()
+		k := item.Key()
+		r.Keys++
+		if len(k) == 0 {
+			r.Add(com.IssueKey, k, "empty key")
+			continue
+		}
+		var err error
+		if v, err = item.ValueCopy(v[:0]); err != nil {
+			r.Add(com.IssueStorage, k, "value can't be read: "+err.Error())
+			continue
+		}
+
+		switch k[0] {
+		case nsMeta, nsKey:
+		case nsTable:
+			tableName, id, _, err := parseCell(k)
+			if err == errMetaCell {
+				if len(v) != 16 {
+					r.Add(com.IssueMeta, k, "row meta isn't 16 bytes")
+				}
+			} else if err != nil {
+				r.Add(com.IssueKey, k, "table key isn't table/id/field")
+				continue
+			}
+			if p := tablePrefix(tableName); !bytes.Equal(p, table) {
+				table = p
+				r.Tables++
+			}
+			if p := rowPrefix(tableName, id); !bytes.Equal(p, row) {
+				row = p
+				r.Rows++
+			}
+		case nsTrash:
+			tag, id, rest, err := readSegment(k[1:])
+			if err != nil || tag != tagName {
+				r.Add(com.IssueTrash, k, "trash key without trash id")
+			} else if bytes.Equal(rest, []byte{nsMeta}) {
+				var info com.TrashItem
+				if json.Unmarshal(v, &info) != nil || info.ID != string(id) {
+					r.Add(com.IssueTrash, k, "malformed trash info")
+				}
+				trash = string(id)
+			} else if string(id) != trash {
+				r.Add(com.IssueTrash, k, "trash item without info")
+			} else if !validKey(rest) {
+				r.Add(com.IssueTrash, k, "trashed key can't be decoded")
+			}
+		default:
+			r.Add(com.IssueKey, k, "unknown namespace")
+		}
+	}
+	return r, nil
+}
+
+// validKey k is a key/value or table key
+func validKey(k []byte) bool {
+	if len(k) > 0 && k[0] == nsKey {
+		return true
+	} else if len(k) > 2 && k[0] == nsMeta && k[1] == 't' { // 表标记
+		return true
+	}
+	_, _, _, err := parseCell(k)
+	return err == nil || err == errMetaCell
+}
@@ -0,0 +1,109 @@
+package badgerdb
+
+import (
+	"bytes"
+	"math/rand"
+	"time"
+
+	"github.com/lysShub/kvdb/com"
+
+	badger "github.com/dgraph-io/badger/v2"
+)
+
+// conditional writes
+//
+// the test and the write are in one transaction; badger's transaction is
+// optimistic, a conflict with another commit is retried, so the test is
+// done again with the new values, after a random backoff.
+
+const conflictRetries = 32
+
+// maxConflictBackoff max wait before a retry
+const maxConflictBackoff = 10 * time.Millisecond
+
+// update run fn in a update transaction, retry on conflict
+func (d *Badger) update(fn func(txn *badger.Txn) error) error {
+	var err error
+	var backoff = 10 * time.Microsecond
+	for i := 0; i < conflictRetries; i++ {
+		if err = d.DbHandle.Update(fn); err != badger.ErrConflict {
+			return err
+		}
+		// 随机等待，避免同时重试再次冲突
+		time.Sleep(time.Duration(rand.Int63n(int64(backoff))))
+		if backoff = backoff * 2; backoff > maxConflictBackoff {
+			backoff = maxConflictBackoff
+		}
+	}
+	return err
+}
+
+// CompareAndSwapKey set the key to new if its value is old, a nil old means
+// the key mustn't exist; otherwise return com.ErrConditionFailed. A merge
+// key is compared by its merged value, and set as SetKey
+func (d *Badger) CompareAndSwapKey(key string, old, new []byte, ttl ...time.Duration) error {
+	k := keyKey(key)
+	if op := d.merges[key]; op != nil {
+		op.Lock()
+		defer op.Unlock()
+	}
+	return d.update(func(txn *badger.Txn) error {
+		var v []byte
+		if d.merges[key] != nil {
+			v = mergedAt(txn, k) // 迭代器的读取也检测冲突
+		} else if item, err := txn.Get(k); err == nil {
+			if v, err = item.ValueCopy(nil); err != nil {
+				return err
+			} else if v == nil {
+				v = []byte{}
+			}
+		} else if err != badger.ErrKeyNotFound {
+			return err
+		}
+		if (old == nil) != (v == nil) || !bytes.Equal(v, old) {
+			return com.ErrConditionFailed
+		}
+		e, err := d.keyEntry(key, new, ttl)
+		if err != nil {
+			return err
+		}
+		return txn.SetEntry(e)
+	})
+}
+
+// SetTableRowIf set the fields of a record if the record match cond,
+// otherwise return com.ErrConditionFailed
+func (d *Badger) SetTableRowIf(tableName, id string, cond com.Condition, kv map[string][]byte, ttl ...time.Duration) error {
+	_, err := d.setRowIf(tableName, id, func(row map[string][]byte, m com.RowMeta) error {
+		if !cond.Match(row, m.Version) {
+			return com.ErrConditionFailed
+		}
+		return nil
+	}, kv, ttl)
+	return err
+}
+
+// setRowIf set the fields of a record if test return nil, return the new version
+func (d *Badger) setRowIf(tableName, id string, test func(row map[string][]byte, m com.RowMeta) error, kv map[string][]byte, ttl []time.Duration) (uint64, error) {
+	var version uint64
+	err := d.update(func(txn *badger.Txn) error {
+		// touchRow 读写meta，同一行的并发写入会冲突
+		r, _ := readTableRow(txn, tableName, id)
+		var m com.RowMeta
+		if len(r) != 0 {
+			m = readMeta(txn, tableName, id)
+		}
+		if err := test(r, m); err != nil {
+			return err
+		}
+		for f, v := range kv {
+			if err := txn.SetEntry(newEntry(cellKey(tableName, id, f), v, ttl)); err != nil {
+				return err
+			}
+		}
+		var err error
+		version, err = d.touchRow(txn, txn.SetEntry, tableName, id, ttl)
+		return err
+	})
+	return version, err
+}
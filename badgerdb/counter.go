@@ -0,0 +1,176 @@
+package badgerdb
+
+import (
+	"errors"
+	"time"
+
+	"github.com/lysShub/kvdb/com"
+
+	badger "github.com/dgraph-io/badger/v2"
+)
+
+// counters
+//
+// an increment is a read-modify-write in one transaction, retried on
+// conflict. The MergeKeys are counted by badger's MergeOperator instead: an
+// increment is only a write, the deltas are merged when read and by a
+// periodic compaction, so high contention keys don't conflict; they must
+// be read by ReadKey or ReadKeys, an iteration may see a unmerged delta.
+// Setting or deleting a merge key discard its earlier versions, so the
+// deltas before it aren't merged; a merge key can't expire, as the
+// compaction merge the deltas into a expired value.
+
+// mergeInterval compaction interval of the MergeOperators
+const mergeInterval = 10 * time.Second
+
+var errMergeFloat error = errors.New("badgerdb: a merge key is a int64 counter")
+
+var errMergeTTL error = errors.New("badgerdb: a merge key can't expire")
+
+func (d *Badger) startMerges() {
+	if len(d.MergeKeys) == 0 {
+		return
+	}
+	d.merges = make(map[string]*badger.MergeOperator, len(d.MergeKeys))
+	for _, key := range d.MergeKeys {
+		d.merges[key] = d.DbHandle.GetMergeOperator(keyKey(key), mergeInt64, mergeInterval)
+	}
+}
+
+func (d *Badger) stopMerges() {
+	for _, op := range d.merges {
+		op.Stop()
+	}
+	d.merges = nil
+}
+
+// mergeInt64 badger.MergeFunc, add the deltas
+func mergeInt64(existing, delta []byte) []byte {
+	a, _ := com.DecodeInt64(existing)
+	b, _ := com.DecodeInt64(delta)
+	return com.EncodeInt64(a + b)
+}
+
+// keyEntry entry of setting a key
+func (d *Badger) keyEntry(key string, value []byte, ttl []time.Duration) (*badger.Entry, error) {
+	e := newEntry(keyKey(key), value, ttl)
+	if d.merges[key] != nil {
+		if e.ExpiresAt != 0 {
+			return nil, errMergeTTL
+		}
+		e = e.WithDiscard()
+	}
+	return e, nil
+}
+
+// deletedEntry entry of deleting a merge key, a delete marker can't discard
+// the earlier versions, so it's a expired entry
+func deletedEntry(key string) *badger.Entry {
+	e := badger.NewEntry(keyKey(key), nil).WithDiscard()
+	e.ExpiresAt = 1
+	return e
+}
+
+// incr read-modify-write the value of k by fn in one transaction, the
+// expire time is kept; then call touch if it isn't nil
+func (d *Badger) incr(k []byte, fn func(v []byte) ([]byte, error), touch func(txn *badger.Txn) error) error {
+	return d.update(func(txn *badger.Txn) error {
+		var v []byte
+		var expiresAt uint64
+		item, err := txn.Get(k)
+		if err == nil {
+			if v, err = item.ValueCopy(nil); err != nil {
+				return err
+			}
+			expiresAt = item.ExpiresAt()
+		} else if err != badger.ErrKeyNotFound {
+			return err
+		}
+		if v, err = fn(v); err != nil {
+			return err
+		}
+		e := badger.NewEntry(k, v)
+		e.ExpiresAt = expiresAt
+		if err = txn.SetEntry(e); err != nil || touch == nil {
+			return err
+		}
+		return touch(txn)
+	})
+}
+
+// touchCell touch the record of a counter field
+func (d *Badger) touchCell(tableName, id string) func(txn *badger.Txn) error {
+	return func(txn *badger.Txn) error {
+		_, err := d.touchRow(txn, txn.SetEntry, tableName, id, nil)
+		return err
+	}
+}
+
+func (d *Badger) incrInt64(k []byte, delta int64, touch func(txn *badger.Txn) error) (int64, error) {
+	var r int64
+	err := d.incr(k, func(v []byte) ([]byte, error) {
+		n, err := com.DecodeInt64(v)
+		r = n + delta
+		return com.EncodeInt64(r), err
+	}, touch)
+	return r, err
+}
+
+func (d *Badger) incrFloat64(k []byte, delta float64, touch func(txn *badger.Txn) error) (float64, error) {
+	var r float64
+	err := d.incr(k, func(v []byte) ([]byte, error) {
+		f, err := com.DecodeFloat64(v)
+		r = f + delta
+		return com.EncodeFloat64(r), err
+	}, touch)
+	return r, err
+}
+
+// IncrKey add delta to a int64 counter, return the new value
+func (d *Badger) IncrKey(key string, delta int64) (int64, error) {
+	if op := d.merges[key]; op != nil {
+		if err := op.Add(com.EncodeInt64(delta)); err != nil {
+			return 0, err
+		}
+		v, err := op.Get()
+		if err != nil {
+			return 0, err
+		}
+		return com.DecodeInt64(v)
+	}
+	return d.incrInt64(keyKey(key), delta, nil)
+}
+
+// IncrKeyFloat add delta to a float64 counter, return the new value
+func (d *Badger) IncrKeyFloat(key string, delta float64) (float64, error) {
+	if d.merges[key] != nil {
+		return 0, errMergeFloat
+	}
+	return d.incrFloat64(keyKey(key), delta, nil)
+}
+
+// IncrTableValue add delta to a int64 counter field, return the new value
+func (d *Badger) IncrTableValue(tableName, id, field string, delta int64) (int64, error) {
+	return d.incrInt64(cellKey(tableName, id, field), delta, d.touchCell(tableName, id))
+}
+
+// IncrTableValueFloat add delta to a float64 counter field, return the new value
+func (d *Badger) IncrTableValueFloat(tableName, id, field string, delta float64) (float64, error) {
+	return d.incrFloat64(cellKey(tableName, id, field), delta, d.touchCell(tableName, id))
+}
+
+// readMerged read a merge key, ok is false if key isn't a merge key
+func (d *Badger) readMerged(key string) (v []byte, ok bool) {
+	op := d.merges[key]
+	if op == nil {
+		return nil, false
+	}
+	if d.snap != nil {
+		return mergedAt(d.snap, keyKey(key)), true
+	}
+	op.RLock()
+	defer op.RUnlock()
+	txn := d.DbHandle.NewTransaction(false)
+	defer txn.Discard()
+	return mergedAt(txn, keyKey(key)), true
+}
@@ -0,0 +1,113 @@
+package badgerdb
+
+import (
+	"os"
+	"sync"
+	"time"
+)
+
+// RAM dump
+//
+// a RAM db is lost when it's closed. With DumpPath its backup stream(see
+// backup.go) is written to the file every DumpInterval and on Close, and
+// loaded when it's opened, so a restart is warm; the writes after the last
+// dump are lost if the process crash. A dump is written to a temporary file
+// and renamed, the file is always a complete dump.
+
+// dumper state of the dumps
+type dumper struct {
+	mu   sync.Mutex // one Dump at a time
+	err  error      // of the last background dump
+	stop chan struct{}
+	done chan struct{}
+}
+
+// dumping the db is dumped to DumpPath
+func (d *Badger) dumping() bool {
+	return d.RAM && d.DumpPath != ""
+}
+
+// loadDump load the dump file into the opened RAM db, if it exists
+func (d *Badger) loadDump() error {
+	f, err := os.Open(d.DumpPath)
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	defer f.Close()
+	return d.Load(f, false) // 刚打开，是空的
+}
+
+func (d *Badger) startDump() {
+	d.dp = &dumper{}
+	if !d.dumping() || d.DumpInterval <= 0 {
+		return
+	}
+	d.dp.stop, d.dp.done = make(chan struct{}), make(chan struct{})
+	go func() {
+		defer close(d.dp.done)
+		t := time.NewTicker(d.DumpInterval)
+		defer t.Stop()
+		for {
+			select {
+			case <-d.dp.stop:
+				return
+			case <-t.C:
+				err := d.Dump()
+				d.dp.mu.Lock()
+				d.dp.err = err
+				d.dp.mu.Unlock()
+			}
+		}
+	}()
+}
+
+// stopDump stop the background dumps and dump the db the last time
+func (d *Badger) stopDump() error {
+	if d.dp == nil {
+		return nil
+	}
+	if d.dp.stop != nil {
+		close(d.dp.stop)
+		<-d.dp.done
+	}
+	return d.Dump()
+}
+
+// DumpErr error of the last background dump, nil if it succeeded
+func (d *Badger) DumpErr() error {
+	d.dp.mu.Lock()
+	defer d.dp.mu.Unlock()
+	return d.dp.err
+}
+
+// Dump write the RAM db to DumpPath now; it does nothing if the db isn't
+// RAM or DumpPath is empty
+func (d *Badger) Dump() error {
+	if !d.dumping() {
+		return nil
+	}
+	d.dp.mu.Lock()
+	defer d.dp.mu.Unlock()
+
+	tmp := d.DumpPath + ".tmp"
+	f, err := os.OpenFile(tmp, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0600)
+	if err != nil {
+		return err
+	}
+	_, err = d.DbHandle.Backup(f, 0)
+	if err == nil {
+		err = f.Sync()
+	}
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Rename(tmp, d.DumpPath)
+	}
+	if err != nil {
+		os.Remove(tmp)
+	}
+	return err
+}
@@ -0,0 +1,153 @@
+package badgerdb
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/lysShub/kvdb/com"
+
+	badger "github.com/dgraph-io/badger/v2"
+)
+
+// maintenance
+//
+// badger doesn't reclaim the value log by itself: RunValueLogGC rewrite a
+// value log file if at least the discard ratio of it is stale, Maintain run
+// it until nothing is rewritten. With GCInterval a background goroutine
+// call Maintain, it can be paused; Close stop and wait it.
+
+// defaultDiscardRatio default of GCDiscardRatio
+const defaultDiscardRatio = 0.5
+
+// maintainer state of the maintenance
+type maintainer struct {
+	run    sync.Mutex // one Maintain at a time
+	mu     sync.Mutex
+	stats  com.GCStats
+	paused bool
+
+	stop chan struct{}
+	done chan struct{}
+}
+
+func (d *Badger) startMaintain() {
+	d.mt = &maintainer{}
+	if d.GCInterval <= 0 {
+		return
+	}
+	d.mt.stop, d.mt.done = make(chan struct{}), make(chan struct{})
+	go func() {
+		defer close(d.mt.done)
+		t := time.NewTicker(d.GCInterval)
+		defer t.Stop()
+		for {
+			select {
+			case <-d.mt.stop:
+				return
+			case <-t.C:
+				if !d.gcPaused() {
+					d.maintain(context.Background(), true)
+				}
+			}
+		}
+	}()
+}
+
+func (d *Badger) stopMaintain() {
+	if d.mt != nil && d.mt.stop != nil {
+		close(d.mt.stop)
+		<-d.mt.done
+	}
+}
+
+// PauseGC pause the background maintenance, a running one stop after the
+// current value log file
+func (d *Badger) PauseGC() {
+	d.mt.mu.Lock()
+	d.mt.paused = true
+	d.mt.mu.Unlock()
+}
+
+// ResumeGC resume the background maintenance
+func (d *Badger) ResumeGC() {
+	d.mt.mu.Lock()
+	d.mt.paused = false
+	d.mt.mu.Unlock()
+}
+
+func (d *Badger) gcPaused() bool {
+	d.mt.mu.Lock()
+	defer d.mt.mu.Unlock()
+	return d.mt.paused
+}
+
+// GCStats statistics of the maintenance until now
+func (d *Badger) GCStats() com.GCStats {
+	d.mt.mu.Lock()
+	defer d.mt.mu.Unlock()
+	return d.mt.stats
+}
+
+// Maintain flatten the LSM tree if Flatten, then GC the value log until
+// nothing is rewritten or ctx is done; a in-memory db has nothing to do
+func (d *Badger) Maintain(ctx context.Context) error {
+	return d.maintain(ctx, false)
+}
+
+func (d *Badger) maintain(ctx context.Context, background bool) error {
+	if d.RAM {
+		return nil
+	}
+	d.mt.run.Lock()
+	defer d.mt.run.Unlock()
+
+	ratio := d.GCDiscardRatio
+	if ratio <= 0 || ratio >= 1 {
+		ratio = defaultDiscardRatio
+	}
+	start, before := time.Now(), d.vlogSize()
+	var rewrites int
+	var err error
+	if d.Flatten {
+		err = d.DbHandle.Flatten(1)
+	}
+	for err == nil {
+		if err = ctx.Err(); err != nil {
+			break
+		} else if background && d.gcPaused() {
+			break
+		}
+		if err = d.DbHandle.RunValueLogGC(ratio); err == nil {
+			rewrites++
+		}
+	}
+	if err == badger.ErrNoRewrite {
+		err = nil
+	}
+
+	d.mt.mu.Lock()
+	defer d.mt.mu.Unlock()
+	s := &d.mt.stats
+	s.Runs++
+	s.Rewrites = s.Rewrites + rewrites
+	if after := d.vlogSize(); after < before {
+		s.Reclaimed = s.Reclaimed + before - after
+	}
+	s.LastRun, s.LastTook, s.LastErr = start, time.Since(start), err
+	return err
+}
+
+// vlogSize total size of the value log files
+func (d *Badger) vlogSize() int64 {
+	files, _ := filepath.Glob(filepath.Join(d.Path, "*.vlog"))
+	var n int64
+	for _, f := range files {
+		if fi, err := os.Stat(f); err == nil {
+			n = n + fi.Size()
+		}
+	}
+	return n
+}
@@ -0,0 +1,214 @@
+package badgerdb
+
+import (
+	"bytes"
+	"sort"
+
+	"github.com/lysShub/kvdb/com"
+
+	badger "github.com/dgraph-io/badger/v2"
+)
+
+// history
+//
+// badger keep NumVersions versions of every key(more before the compaction),
+// a version is the commit timestamp(a logical counter, not time) of the
+// transaction that wrote it. A write of a record also write its meta, so the
+// versions of a record are the commits that wrote it.
+//
+// badger read at a timestamp only in the managed mode, where the
+// MergeOperator and Sequence can't be used; so ReadKeyAt and ReadTableRowAt
+// iterate all versions, the value at a ts is the newest version whose ts <=
+// it. A value expired by now is read as deleted, and the versions discarded
+// by compaction aren't seen.
+
+// ReadTs the read timestamp of a snapshot, a ts of the history
+func (d *Badger) ReadTs() uint64 {
+	if d.snap == nil {
+		return 0
+	}
+	return d.snap.ReadTs()
+}
+
+// valueAt the value of k at ts, the deltas are merged until a discarding
+// version if merge; nil if it's deleted or not exist
+func valueAt(txn *badger.Txn, k []byte, ts uint64, merge bool) ([]byte, error) {
+	io := badger.DefaultIteratorOptions
+	io.AllVersions = true
+	it := txn.NewKeyIterator(k, io)
+	defer it.Close()
+
+	var v []byte
+	for it.Rewind(); it.Valid(); it.Next() {
+		item := it.Item()
+		if item.Version() > ts {
+			continue
+		} else if item.IsDeletedOrExpired() {
+			break
+		}
+		val, err := item.ValueCopy(nil)
+		if err != nil {
+			return nil, err
+		} else if !merge {
+			if val == nil {
+				val = []byte{}
+			}
+			return val, nil
+		}
+		v = mergeInt64(v, val)
+		if item.DiscardEarlierVersions() {
+			break
+		}
+	}
+	return v, nil
+}
+
+// ReadKeyAt read a key at the commit ts, nil if it isn't exist
+func (d *Badger) ReadKeyAt(key string, ts uint64) ([]byte, error) {
+	txn := d.readTxn()
+	defer d.doneTxn(txn)
+	return valueAt(txn, keyKey(key), ts, d.merges[key] != nil)
+}
+
+// ReadTableRowAt read a record at the commit ts, nil if it isn't exist
+func (d *Badger) ReadTableRowAt(tableName, id string, ts uint64) (map[string][]byte, error) {
+	txn := d.readTxn()
+	defer d.doneTxn(txn)
+
+	io := badger.DefaultIteratorOptions
+	io.AllVersions = true
+	io.Prefix = fieldsPrefix(tableName, id)
+	it := txn.NewIterator(io)
+	defer it.Close()
+
+	var r map[string][]byte
+	var last []byte
+	var found bool // last 在 ts 的版本已读取
+	for it.Seek(io.Prefix); it.ValidForPrefix(io.Prefix); it.Next() {
+		item := it.Item()
+		if !bytes.Equal(item.Key(), last) {
+			last, found = item.KeyCopy(last), false
+		}
+		if found || item.Version() > ts {
+			continue
+		}
+		found = true
+		if item.IsDeletedOrExpired() {
+			continue
+		}
+		_, _, field, err := parseCell(item.Key())
+		if err != nil {
+			continue
+		}
+		v, err := item.ValueCopy(nil)
+		if err != nil {
+			return nil, err
+		}
+		if r == nil {
+			r = make(map[string][]byte)
+		}
+		r[field] = v
+	}
+	return r, nil
+}
+
+// KeyHistory the kept versions of a key, newest first; a merge key's
+// versions are the deltas
+func (d *Badger) KeyHistory(key string) ([]com.KeyVersion, error) {
+	txn := d.readTxn()
+	defer d.doneTxn(txn)
+
+	io := badger.DefaultIteratorOptions
+	io.AllVersions = true
+	it := txn.NewKeyIterator(keyKey(key), io)
+	defer it.Close()
+
+	var r []com.KeyVersion
+	for it.Rewind(); it.Valid(); it.Next() {
+		item := it.Item()
+		kv := com.KeyVersion{Ts: item.Version(), ExpiresAt: item.ExpiresAt()}
+		if item.IsDeletedOrExpired() {
+			kv.Deleted = true
+		} else if v, err := item.ValueCopy(nil); err != nil {
+			return nil, err
+		} else {
+			kv.Value = v
+		}
+		r = append(r, kv)
+	}
+	return r, nil
+}
+
+// cellVersion a version of a field or the meta
+type cellVersion struct {
+	ts    uint64
+	value []byte // nil is deleted
+}
+
+// RowHistory the record at every kept commit that wrote it, newest first;
+// Row is nil where it's deleted
+func (d *Badger) RowHistory(tableName, id string) ([]com.RowVersion, error) {
+	txn := d.readTxn()
+	defer d.doneTxn(txn)
+
+	io := badger.DefaultIteratorOptions
+	io.AllVersions = true
+	io.Prefix = rowPrefix(tableName, id)
+	it := txn.NewIterator(io)
+	defer it.Close()
+
+	var cells = make(map[string][]cellVersion) // newest first
+	var meta []cellVersion
+	var tss = make(map[uint64]bool)
+	for it.Rewind(); it.Valid(); it.Next() {
+		item := it.Item()
+		_, _, field, err := parseCell(item.Key())
+		isMeta := err == errMetaCell
+		if err != nil && !isMeta {
+			continue
+		}
+		cv := cellVersion{ts: item.Version()}
+		if !item.IsDeletedOrExpired() {
+			if cv.value, err = item.ValueCopy(nil); err != nil {
+				return nil, err
+			} else if cv.value == nil {
+				cv.value = []byte{}
+			}
+		}
+		if isMeta {
+			meta = append(meta, cv)
+		} else {
+			cells[field] = append(cells[field], cv)
+		}
+		tss[cv.ts] = true
+	}
+
+	var r = make([]com.RowVersion, 0, len(tss))
+	for ts := range tss {
+		r = append(r, com.RowVersion{Ts: ts})
+	}
+	sort.Slice(r, func(i, j int) bool { return r[i].Ts > r[j].Ts })
+	for i := range r {
+		if v := versionAt(meta, r[i].Ts); v != nil {
+			r[i].Meta = com.DecodeRowMeta(v)
+		}
+		for field, vs := range cells {
+			if v := versionAt(vs, r[i].Ts); v != nil {
+				if r[i].Row == nil {
+					r[i].Row = make(map[string][]byte)
+				}
+				r[i].Row[field] = v
+			}
+		}
+	}
+	return r, nil
+}
+
+// versionAt the value at ts of the versions(newest first), nil if it isn't exist
+func versionAt(vs []cellVersion, ts uint64) []byte {
+	i := sort.Search(len(vs), func(i int) bool { return vs[i].ts <= ts })
+	if i == len(vs) {
+		return nil
+	}
+	return vs[i].value
+}
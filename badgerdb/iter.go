@@ -0,0 +1,216 @@
+package badgerdb
+
+import (
+	"github.com/lysShub/kvdb/com"
+
+	badger "github.com/dgraph-io/badger/v2"
+)
+
+// IterateTable call fn with every record in a table in order, stop when fn return error
+func (d *Badger) IterateTable(tableName string, fn func(id string, row map[string][]byte) error) error {
+	txn := d.readTxn()
+	defer d.doneTxn(txn)
+	return iterateTable(txn, tableName, fn)
+}
+
+func iterateTable(txn *badger.Txn, tableName string, fn func(id string, row map[string][]byte) error) error {
+	opt := badger.DefaultIteratorOptions
+	opt.Prefix = tablePrefix(tableName)
+	it := txn.NewIterator(opt)
+	defer it.Close()
+
+	var row map[string][]byte
+	var tmpID string
+	for it.Seek(opt.Prefix); it.ValidForPrefix(opt.Prefix); it.Next() {
+		_, id, field, err := parseCell(it.Item().Key())
+		if err == errMetaCell {
+			continue
+		} else if err != nil {
+			return err
+		}
+		if row != nil && id != tmpID {
+			if err = fn(tmpID, row); err != nil {
+				return err
+			}
+			row = nil
+		}
+		if row == nil {
+			row, tmpID = make(map[string][]byte), id
+		}
+		if row[field], err = it.Item().ValueCopy(nil); err != nil {
+			return err
+		}
+	}
+	if row != nil {
+		return fn(tmpID, row)
+	}
+	return nil
+}
+
+// RowIterator pull-style iterator of the records in a table, it holds a
+// read transaction until Close, the records are the snapshot when it's created
+type RowIterator struct {
+	d         *Badger
+	txn       *badger.Txn
+	it        *badger.Iterator
+	tableName string
+	prefix    []byte
+	opts      com.IterOptions
+
+	id   string
+	row  map[string][]byte
+	err  error
+	done bool
+}
+
+// NewRowIterator
+func (d *Badger) NewRowIterator(tableName string, opts com.IterOptions) *RowIterator {
+	io := badger.DefaultIteratorOptions
+	io.Prefix = tablePrefix(tableName)
+	io.Reverse = opts.Reverse
+
+	r := &RowIterator{
+		d:         d,
+		txn:       d.readTxn(),
+		tableName: tableName,
+		prefix:    io.Prefix,
+		opts:      opts,
+	}
+	r.it = r.txn.NewIterator(io)
+	if opts.Reverse && opts.End == "" {
+		r.it.Seek(prefixEnd(r.prefix))
+	} else if opts.Reverse {
+		r.it.Seek(rowPrefix(tableName, opts.End))
+	} else {
+		r.it.Seek(rowPrefix(tableName, opts.Start))
+	}
+	return r
+}
+
+// Seek move to the first record whose id >= id, or <= id in reverse mode;
+// the bounds are kept
+func (r *RowIterator) Seek(id string) {
+	if r.it == nil {
+		return
+	}
+	r.done = false
+	if !r.opts.Reverse {
+		if id < r.opts.Start {
+			id = r.opts.Start
+		}
+		r.it.Seek(rowPrefix(r.tableName, id))
+	} else if r.opts.End != "" && id >= r.opts.End {
+		// rowPrefix 比 End 的所有字段都小
+		r.it.Seek(rowPrefix(r.tableName, r.opts.End))
+	} else {
+		r.it.Seek(prefixEnd(rowPrefix(r.tableName, id)))
+	}
+}
+
+// Next move to the next record, return false at the end or on error
+func (r *RowIterator) Next() bool {
+	if r.it == nil || r.done || r.err != nil {
+		return false
+	}
+	for {
+		if !r.it.ValidForPrefix(r.prefix) {
+			r.done = true
+			return false
+		}
+		_, id, _, err := parseCell(r.it.Item().Key())
+		if err != nil && err != errMetaCell {
+			r.err = err
+			return false
+		}
+		if (!r.opts.Reverse && r.opts.End != "" && id >= r.opts.End) || (r.opts.Reverse && id < r.opts.Start) {
+			r.done = true
+			return false
+		}
+
+		var row map[string][]byte = make(map[string][]byte)
+		rp := rowPrefix(r.tableName, id)
+		for ; r.it.ValidForPrefix(rp); r.it.Next() {
+			item := r.it.Item()
+			_, _, field, err := parseCell(item.Key())
+			if err == errMetaCell {
+				continue
+			} else if err != nil {
+				r.err = err
+				return false
+			}
+			if row[field], err = item.ValueCopy(nil); err != nil {
+				r.err = err
+				return false
+			}
+		}
+		if len(row) != 0 { // 只有meta的行
+			r.id, r.row = id, row
+			return true
+		}
+	}
+}
+
+// ID id of the current record
+func (r *RowIterator) ID() string {
+	return r.id
+}
+
+// Row fields of the current record
+func (r *RowIterator) Row() map[string][]byte {
+	return r.row
+}
+
+// Err the error that stopped the iteration
+func (r *RowIterator) Err() error {
+	return r.err
+}
+
+// Close release the iterator and its transaction
+func (r *RowIterator) Close() {
+	if r.it != nil {
+		r.it.Close()
+		r.d.doneTxn(r.txn)
+		r.it, r.row = nil, nil
+	}
+}
+
+// IterateKeys call fn with every key/value between opts.Start and opts.End in
+// order, stop when fn return error
+func (d *Badger) IterateKeys(opts com.IterOptions, fn func(key string, value []byte) error) error {
+	txn := d.readTxn()
+	defer d.doneTxn(txn)
+
+	io := badger.DefaultIteratorOptions
+	io.Prefix = []byte{nsKey}
+	io.Reverse = opts.Reverse
+	it := txn.NewIterator(io)
+	defer it.Close()
+
+	if !opts.Reverse {
+		it.Seek(keyKey(opts.Start))
+	} else if opts.End != "" {
+		it.Seek(keyKey(opts.End))
+	} else {
+		it.Seek([]byte{nsKey + 1})
+	}
+	for ; it.ValidForPrefix(io.Prefix); it.Next() {
+		item := it.Item()
+		key, err := parseKey(item.Key())
+		if err != nil {
+			return err
+		}
+		if opts.Reverse && opts.End != "" && key >= opts.End {
+			continue // Seek 包含 End
+		} else if (!opts.Reverse && opts.End != "" && key >= opts.End) || (opts.Reverse && key < opts.Start) {
+			return nil
+		}
+		v, err := item.ValueCopy(nil)
+		if err != nil {
+			return err
+		}
+		if err = fn(key, v); err != nil {
+			return err
+		}
+	}
+	return nil
+}
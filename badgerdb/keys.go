@@ -0,0 +1,209 @@
+package badgerdb
+
+import (
+	"bytes"
+	"errors"
+)
+
+// key encoding, version 1
+//
+// every key start with a namespace byte:
+//   nsMeta  | name                           kvdb's own metadata
+//   nsMeta  | 't' | seg(table)               marker of a table, kept by TruncateTable
+//   nsKey   | key                            plain key/value, key is stored as is
+//   nsTable | seg(table) | seg(id) | seg(field)
+//   nsTable | seg(table) | seg(id) | tagMeta 0x00 0x01   row meta, sort before the fields
+//   nsTrash | seg(trash id) | 0x00                     trash item info
+//   nsTrash | seg(trash id) | key                      a deleted key of nsKey or nsTable
+//
+// seg is: tag byte | escaped bytes | 0x00 0x01, 0x00 in the bytes is escaped
+// as 0x00 0xff; so any bytes can be used as name, a segment is never a prefix
+// of another one, and the byte order of names is kept.
+
+const formatVersion byte = 1
+
+// namespace
+const (
+	nsMeta  byte = 0x00
+	nsKey   byte = 0x01
+	nsTable byte = 0x02
+	nsTrash byte = 0x03
+)
+
+// segment tag
+const (
+	tagMeta  byte = 0x01 // row meta
+	tagName  byte = 0x02 // table name and id
+	tagField byte = 0x04 // field
+)
+
+var formatKey = []byte{nsMeta, 'f', 'o', 'r', 'm', 'a', 't'}
+
+var errKey error = errors.New("badgerdb: malformed key")
+
+// errMetaCell the table key is a row meta, not a field
+var errMetaCell error = errors.New("badgerdb: row meta key")
+
+// ErrLegacyFormat the database is written with the delimiter key format, use MigrateDb
+var ErrLegacyFormat error = errors.New("badgerdb: database use the legacy delimiter key format, need migrate")
+
+// ErrFormat the database is written by a newer version
+var ErrFormat error = errors.New("badgerdb: unsupported key format version")
+
+func appendSegment(b []byte, tag byte, s string) []byte {
+	b = append(b, tag)
+	b = appendEscaped(b, s)
+	return append(b, 0x00, 0x01)
+}
+
+func appendEscaped(b []byte, s string) []byte {
+	for i := 0; i < len(s); i++ {
+		if s[i] == 0x00 {
+			b = append(b, 0x00, 0xff)
+		} else {
+			b = append(b, s[i])
+		}
+	}
+	return b
+}
+
+// prefixEnd the smallest key greater than all keys with the prefix, prefix
+// must end with a segment terminator
+func prefixEnd(prefix []byte) []byte {
+	r := make([]byte, len(prefix))
+	copy(r, prefix)
+	r[len(r)-1]++
+	return r
+}
+
+// readSegment read the first segment of k, return the rest
+func readSegment(k []byte) (tag byte, s []byte, rest []byte, err error) {
+	if len(k) < 3 {
+		return 0, nil, nil, errKey
+	}
+	tag, k = k[0], k[1:]
+	s = make([]byte, 0, len(k))
+	for i := 0; i < len(k); i++ {
+		if k[i] != 0x00 {
+			s = append(s, k[i])
+			continue
+		}
+		if i+1 >= len(k) {
+			return 0, nil, nil, errKey
+		}
+		switch k[i+1] {
+		case 0xff:
+			s = append(s, 0x00)
+			i++
+		case 0x01:
+			return tag, s, k[i+2:], nil
+		default:
+			return 0, nil, nil, errKey
+		}
+	}
+	return 0, nil, nil, errKey
+}
+
+// keyKey key of a plain key/value
+func keyKey(key string) []byte {
+	b := make([]byte, 0, len(key)+1)
+	b = append(b, nsKey)
+	return append(b, key...)
+}
+
+// tablePrefix prefix of all keys in a table
+func tablePrefix(tableName string) []byte {
+	b := make([]byte, 0, len(tableName)+8)
+	b = append(b, nsTable)
+	return appendSegment(b, tagName, tableName)
+}
+
+// rowPrefix prefix of all keys in a record
+func rowPrefix(tableName, id string) []byte {
+	return appendSegment(tablePrefix(tableName), tagName, id)
+}
+
+// cellKey key of a field's value
+func cellKey(tableName, id, field string) []byte {
+	return appendSegment(rowPrefix(tableName, id), tagField, field)
+}
+
+// fieldsPrefix prefix of the fields of a record, without the meta
+func fieldsPrefix(tableName, id string) []byte {
+	return append(rowPrefix(tableName, id), tagField)
+}
+
+// tableKey marker of a table, a table with it exist without record
+func tableKey(tableName string) []byte {
+	return appendSegment([]byte{nsMeta, 't'}, tagName, tableName)
+}
+
+// metaKey key of a record's meta
+func metaKey(tableName, id string) []byte {
+	return appendSegment(rowPrefix(tableName, id), tagMeta, "")
+}
+
+// parseCell decode a table key, a row meta key return the table name, id
+// and errMetaCell
+func parseCell(k []byte) (tableName, id, field string, err error) {
+	if len(k) == 0 || k[0] != nsTable {
+		return "", "", "", errKey
+	}
+	var tag byte
+	var s []byte
+	var r [3]string
+	var tags = [3]byte{tagName, tagName, tagField}
+	k = k[1:]
+	for i := 0; i < 3; i++ {
+		if tag, s, k, err = readSegment(k); err != nil {
+			return "", "", "", err
+		} else if i == 2 && tag == tagMeta && len(s) == 0 && len(k) == 0 {
+			return r[0], r[1], "", errMetaCell
+		} else if tag != tags[i] {
+			return "", "", "", errKey
+		}
+		r[i] = string(s)
+	}
+	if len(k) != 0 {
+		return "", "", "", errKey
+	}
+	return r[0], r[1], r[2], nil
+}
+
+// idPrefix prefix of the keys in a table whose id start with the prefix
+func idPrefix(tableName, prefix string) []byte {
+	return appendEscaped(append(tablePrefix(tableName), tagName), prefix)
+}
+
+// parseTable decode the table name of a table key
+func parseTable(k []byte) (string, error) {
+	if len(k) == 0 || k[0] != nsTable {
+		return "", errKey
+	}
+	tag, s, _, err := readSegment(k[1:])
+	if err != nil {
+		return "", err
+	} else if tag != tagName {
+		return "", errKey
+	}
+	return string(s), nil
+}
+
+// parseKey decode a plain key
+func parseKey(k []byte) (string, error) {
+	if len(k) == 0 || k[0] != nsKey {
+		return "", errKey
+	}
+	return string(k[1:]), nil
+}
+
+// legacyKey encode a key of the delimiter format to the current format
+func legacyKey(k []byte, delimiter string) ([]byte, error) {
+	rk := bytes.SplitN(k, []byte(delimiter), 3)
+	if len(rk) == 1 {
+		return keyKey(string(k)), nil
+	} else if len(rk) == 3 {
+		return cellKey(string(rk[0]), string(rk[1]), string(rk[2])), nil
+	}
+	return nil, errKey
+}
@@ -0,0 +1,167 @@
+package badgerdb
+
+import (
+	"bytes"
+	"math/rand"
+	"sort"
+	"testing"
+
+	"github.com/dgraph-io/badger/v2"
+)
+
+func randName(r *rand.Rand) string {
+	// small alphabet with the escape bytes, so prefixes and 0x00 are common
+	var alphabet = []byte{0x00, 0x01, 0x02, 'a', 0xff}
+	b := make([]byte, r.Intn(4))
+	for i := range b {
+		b[i] = alphabet[r.Intn(len(alphabet))]
+	}
+	return string(b)
+}
+
+func TestCellKeyRoundTrip(t *testing.T) {
+	r := rand.New(rand.NewSource(1))
+	for i := 0; i < 10000; i++ {
+		tn, id, f := randName(r), randName(r), randName(r)
+		gtn, gid, gf, err := parseCell(cellKey(tn, id, f))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if gtn != tn || gid != id || gf != f {
+			t.Fatalf("round trip %q %q %q: got %q %q %q", tn, id, f, gtn, gid, gf)
+		}
+	}
+}
+
+func TestCellKeyOrder(t *testing.T) {
+	r := rand.New(rand.NewSource(2))
+	type cell struct{ tn, id, f string }
+	var cs []cell
+	for i := 0; i < 2000; i++ {
+		cs = append(cs, cell{randName(r), randName(r), randName(r)})
+	}
+	// tuple order must equal encoded key order
+	sort.Slice(cs, func(i, j int) bool {
+		if cs[i].tn != cs[j].tn {
+			return cs[i].tn < cs[j].tn
+		} else if cs[i].id != cs[j].id {
+			return cs[i].id < cs[j].id
+		}
+		return cs[i].f < cs[j].f
+	})
+	for i := 1; i < len(cs); i++ {
+		a, b := cellKey(cs[i-1].tn, cs[i-1].id, cs[i-1].f), cellKey(cs[i].tn, cs[i].id, cs[i].f)
+		if bytes.Compare(a, b) > 0 {
+			t.Fatalf("order %q > %q", cs[i-1], cs[i])
+		}
+	}
+}
+
+func TestPrefixIsolation(t *testing.T) {
+	// a table/row prefix never matches the keys of another table/row
+	if bytes.HasPrefix(cellKey("ab", "1", "f"), tablePrefix("a")) {
+		t.Fatal("table a matches table ab")
+	}
+	if bytes.HasPrefix(cellKey("a\x00", "1", "f"), tablePrefix("a")) {
+		t.Fatal(`table a matches table a\x00`)
+	}
+	if bytes.HasPrefix(cellKey("t", "12", "f"), rowPrefix("t", "1")) {
+		t.Fatal("row 1 matches row 12")
+	}
+	// plain keys and tables are in separate namespaces
+	if bytes.Equal(keyKey("users```1```name"), cellKey("users", "1", "name")) {
+		t.Fatal("plain key aliases a table cell")
+	}
+}
+
+// openRaw open the db as Badger does, with the zero password
+func openRaw(path string) (*badger.DB, error) {
+	var password [16]byte
+	return badger.Open(badger.DefaultOptions(path).WithLoggingLevel(badger.ERROR).WithEncryptionKey(password[:]))
+}
+
+func TestMigrate(t *testing.T) {
+	path := t.TempDir()
+	db, err := openRaw(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = db.Update(func(txn *badger.Txn) error {
+		for _, k := range []string{"k1", "k2", "users```1```name", "users```2```name"} {
+			if err := txn.Set([]byte(k), []byte(k)); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err == nil {
+		err = db.Close()
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var d = &Badger{Path: path}
+	if err = d.OpenDb(); err != ErrLegacyFormat {
+		t.Fatal("legacy db is opened", err)
+	}
+
+	// 模拟中断的迁移：已标记迁移中，部分key已重写
+	if db, err = openRaw(path); err != nil {
+		t.Fatal(err)
+	}
+	err = db.Update(func(txn *badger.Txn) error {
+		if err := txn.Set(formatKey, []byte{0}); err != nil {
+			return err
+		}
+		if err := txn.Set(keyKey("k1"), []byte("k1")); err != nil {
+			return err
+		}
+		if err := txn.Delete([]byte("k1")); err != nil {
+			return err
+		}
+		// 重写后未删除旧key
+		return txn.Set(cellKey("users", "1", "name"), []byte("users```1```name"))
+	})
+	if err == nil {
+		err = db.Close()
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	d = &Badger{Path: path}
+	if err = d.OpenDb(); err != ErrLegacyFormat {
+		t.Fatal("interrupted migration is opened", err)
+	}
+	d = &Badger{Path: path}
+	if err = d.MigrateDb(); err != nil {
+		t.Fatal(err)
+	}
+	check := func() {
+		for _, k := range []string{"k1", "k2"} {
+			if v := d.ReadKey(k); string(v) != k {
+				t.Fatal(k, string(v))
+			}
+		}
+		for _, id := range []string{"1", "2"} {
+			if v := d.ReadTableValue("users", id, "name"); string(v) != "users```"+id+"```name" {
+				t.Fatal(id, string(v))
+			}
+		}
+		if v := d.ReadKey("users```1```name"); v != nil {
+			t.Fatal("legacy key is kept")
+		}
+	}
+	check()
+	if err = d.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	d = &Badger{Path: path}
+	if err = d.OpenDb(); err != nil {
+		t.Fatal(err)
+	}
+	defer d.Close()
+	check()
+}
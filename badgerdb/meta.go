@@ -0,0 +1,119 @@
+package badgerdb
+
+import (
+	"time"
+
+	"github.com/lysShub/kvdb/com"
+
+	badger "github.com/dgraph-io/badger/v2"
+)
+
+// row meta
+//
+// every written record has a meta key(see keys.go) holding its version and
+// update time, so rename, copy and delete carry it with the record. The
+// version is taken from a db wide badger.Sequence, so it never go back even
+// if the record is deleted and created again; a write read the meta in its
+// transaction, then the concurrent writes of a record conflict and are
+// serialized. The meta expire with the last field of the record.
+
+var versionKey = []byte{nsMeta, 'v', 'e', 'r', 's', 'i', 'o', 'n'}
+
+// versionBandwidth versions leased from the sequence at a time
+const versionBandwidth = 1000
+
+// nextVersion
+func (d *Badger) nextVersion() (uint64, error) {
+	d.seqMu.Lock()
+	if d.seq == nil {
+		seq, err := d.DbHandle.GetSequence(versionKey, versionBandwidth)
+		if err != nil {
+			d.seqMu.Unlock()
+			return 0, err
+		}
+		d.seq = seq
+	}
+	d.seqMu.Unlock()
+
+	for {
+		v, err := d.seq.Next()
+		if err != nil || v != 0 { // 0 表示没有版本
+			return v, err
+		}
+	}
+}
+
+func (d *Badger) releaseVersions() {
+	d.seqMu.Lock()
+	defer d.seqMu.Unlock()
+	if d.seq != nil {
+		d.seq.Release()
+		d.seq = nil
+	}
+}
+
+// touchRow write a new meta of a written record, the old meta is read by
+// read; the meta expire with the last field of the record, the fields are
+// read by read too, the written ones if it's the writing transaction.
+// Return the new version
+func (d *Badger) touchRow(read *badger.Txn, set func(e *badger.Entry) error, tableName, id string, ttl []time.Duration) (uint64, error) {
+	k := metaKey(tableName, id)
+	if _, err := read.Get(k); err != nil && err != badger.ErrKeyNotFound {
+		return 0, err // 读取以检测冲突
+	}
+	var expiresAt uint64
+	if len(ttl) != 0 && ttl[0] > 0 {
+		expiresAt = uint64(time.Now().Add(ttl[0]).Unix())
+
+		opt := badger.DefaultIteratorOptions
+		opt.PrefetchValues = false
+		opt.Prefix = fieldsPrefix(tableName, id)
+		it := read.NewIterator(opt)
+		for it.Seek(opt.Prefix); it.ValidForPrefix(opt.Prefix); it.Next() {
+			if e := it.Item().ExpiresAt(); e == 0 {
+				expiresAt = 0
+				break
+			} else if e > expiresAt {
+				expiresAt = e
+			}
+		}
+		it.Close()
+	}
+
+	v, err := d.nextVersion()
+	if err != nil {
+		return 0, err
+	}
+	e := badger.NewEntry(k, com.EncodeRowMeta(com.RowMeta{Version: v, UpdatedAt: time.Now()}))
+	e.ExpiresAt = expiresAt
+	return v, set(e)
+}
+
+// readMeta meta of a record, zero if it's not versioned
+func readMeta(txn *badger.Txn, tableName, id string) com.RowMeta {
+	v, _ := readValue(txn, metaKey(tableName, id))
+	return com.DecodeRowMeta(v)
+}
+
+// ReadTableRowMeta read a record and its meta
+func (d *Badger) ReadTableRowMeta(tableName, id string) (map[string][]byte, com.RowMeta) {
+	txn := d.readTxn()
+	defer d.doneTxn(txn)
+	r, _ := readTableRow(txn, tableName, id)
+	if len(r) == 0 {
+		return r, com.RowMeta{}
+	}
+	return r, readMeta(txn, tableName, id)
+}
+
+// UpdateRow set the fields of a record if its version is version(0 is a
+// new or not versioned record), otherwise return com.ErrVersionConflict;
+// return the new version
+func (d *Badger) UpdateRow(tableName, id string, version uint64, kv map[string][]byte, ttl ...time.Duration) (uint64, error) {
+	return d.setRowIf(tableName, id, func(row map[string][]byte, m com.RowMeta) error {
+		if m.Version != version {
+			return com.ErrVersionConflict
+		}
+		return nil
+	}, kv, ttl)
+}
@@ -0,0 +1,73 @@
+package badgerdb
+
+import (
+	"github.com/lysShub/kvdb/com"
+
+	badger "github.com/dgraph-io/badger/v2"
+)
+
+// multi-get, read in one transaction in sorted order of the keys, the
+// results are in order of the input
+
+// ReadKeys read values and their expire time, found report the key is exist
+func (d *Badger) ReadKeys(keys []string) (values [][]byte, expiresAt []uint64, found []bool) {
+	values, expiresAt, found = make([][]byte, len(keys)), make([]uint64, len(keys)), make([]bool, len(keys))
+	txn := d.readTxn()
+	defer d.doneTxn(txn)
+
+	for _, i := range com.SortedOrder(keys) {
+		if d.merges[keys[i]] != nil { // 同一个事务中合并
+			values[i] = mergedAt(txn, keyKey(keys[i]))
+			found[i] = values[i] != nil
+			continue
+		}
+		item, err := txn.Get(keyKey(keys[i]))
+		if err != nil {
+			continue
+		}
+		if values[i], err = item.ValueCopy(nil); err != nil {
+			continue
+		}
+		expiresAt[i], found[i] = item.ExpiresAt(), true
+	}
+	return values, expiresAt, found
+}
+
+// ReadTableRows read records and the earliest expire time of their fields,
+// a missing record is nil
+func (d *Badger) ReadTableRows(tableName string, ids []string) ([]map[string][]byte, []uint64) {
+	var rows, expiresAt = make([]map[string][]byte, len(ids)), make([]uint64, len(ids))
+	txn := d.readTxn()
+	defer d.doneTxn(txn)
+
+	// 一个迭代器，按顺序Seek
+	opt := badger.DefaultIteratorOptions
+	opt.Prefix = tablePrefix(tableName)
+	it := txn.NewIterator(opt)
+	defer it.Close()
+
+	for _, i := range com.SortedOrder(ids) {
+		prefix := fieldsPrefix(tableName, ids[i])
+		var r map[string][]byte
+		for it.Seek(prefix); it.ValidForPrefix(prefix); it.Next() {
+			item := it.Item()
+			_, _, field, err := parseCell(item.Key())
+			if err != nil {
+				r = nil
+				break
+			}
+			if r == nil {
+				r = make(map[string][]byte)
+			}
+			if r[field], err = item.ValueCopy(nil); err != nil {
+				r = nil
+				break
+			}
+			if e := item.ExpiresAt(); e != 0 && (expiresAt[i] == 0 || e < expiresAt[i]) {
+				expiresAt[i] = e
+			}
+		}
+		rows[i] = r
+	}
+	return rows, expiresAt
+}
@@ -0,0 +1,125 @@
+package badgerdb
+
+import (
+	"bytes"
+	"context"
+
+	badger "github.com/dgraph-io/badger/v2"
+	"github.com/dgraph-io/badger/v2/pb"
+)
+
+// ScanBatch rows per batch of ParallelScan
+const ScanBatch = 256
+
+// ParallelScan read a table by badger's Stream with workers goroutines, the
+// key ranges are split by the SSTables. Every worker collect rows to its
+// own batch, fn is called concurrently with a worker's batch(ScanBatch rows
+// at most), the batches aren't in order; stop when fn return error.
+//
+// every worker read from its own transaction, so the scan isn't a
+// snapshot of the whole table while it's written
+func (d *Badger) ParallelScan(tableName string, workers int, fn func(worker int, ids []string, rows []map[string][]byte) error) error {
+	if workers <= 0 {
+		workers = 1
+	}
+	s := &scanner{
+		fn:      fn,
+		workers: make([]scanWorker, workers),
+	}
+	st := d.DbHandle.NewStream()
+	st.Prefix = tablePrefix(tableName)
+	st.NumGo = workers
+	st.LogPrefix = "kvdb.ParallelScan"
+	st.KeyToList = s.keyToList
+	st.Send = func(*pb.KVList) error { return nil }
+	if err := st.Orchestrate(context.Background()); err != nil {
+		return err
+	}
+
+	for i := range s.workers {
+		if err := s.flush(i); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+type scanner struct {
+	fn      func(worker int, ids []string, rows []map[string][]byte) error
+	workers []scanWorker
+}
+
+// scanWorker state of a Stream goroutine, only used by itself
+type scanWorker struct {
+	next []byte // key where the iterator stopped, the start of the next row
+	ids  []string
+	rows []map[string][]byte
+}
+
+// keyToList read the whole row of key, the iterator is moved after the row;
+// nothing is sent to the Stream
+func (s *scanner) keyToList(key []byte, itr *badger.Iterator) (*pb.KVList, error) {
+	w := &s.workers[itr.ThreadId]
+	tableName, id, _, err := parseCell(key)
+	if err != nil && err != errMetaCell {
+		return nil, err
+	}
+	rp := rowPrefix(tableName, id)
+
+	// 新的key范围可能从行的中间开始，这一行由行首所在的范围读取；用范围
+	// 的迭代器判断，读取时间点与读取行时相同
+	var skip bool
+	if !bytes.Equal(key, w.next) {
+		itr.Seek(rp)
+		skip = itr.Valid() && bytes.Compare(itr.Item().Key(), key) < 0
+		itr.Seek(key)
+	}
+
+	var row map[string][]byte = make(map[string][]byte)
+	var last []byte
+	for ; itr.Valid(); itr.Next() {
+		item := itr.Item()
+		if !bytes.HasPrefix(item.Key(), rp) {
+			break
+		} else if bytes.Equal(item.Key(), last) {
+			continue // 旧版本
+		}
+		last = item.KeyCopy(last)
+		if skip || item.IsDeletedOrExpired() {
+			continue
+		}
+		_, _, field, err := parseCell(item.Key())
+		if err == errMetaCell {
+			continue
+		} else if err != nil {
+			return nil, err
+		}
+		if row[field], err = item.ValueCopy(nil); err != nil {
+			return nil, err
+		}
+	}
+	if itr.Valid() {
+		w.next = itr.Item().KeyCopy(w.next)
+	} else {
+		w.next = nil
+	}
+
+	if skip || len(row) == 0 {
+		return nil, nil
+	}
+	w.ids, w.rows = append(w.ids, id), append(w.rows, row)
+	if len(w.ids) >= ScanBatch {
+		return nil, s.flush(itr.ThreadId)
+	}
+	return nil, nil
+}
+
+func (s *scanner) flush(worker int) error {
+	w := &s.workers[worker]
+	if len(w.ids) == 0 {
+		return nil
+	}
+	err := s.fn(worker, w.ids, w.rows)
+	w.ids, w.rows = nil, nil
+	return err
+}
@@ -0,0 +1,59 @@
+package badgerdb
+
+import (
+	"math"
+
+	badger "github.com/dgraph-io/badger/v2"
+)
+
+// snapshot
+//
+// a snapshot is a Badger sharing the db handle, whose read methods use one
+// read transaction, so they all see the db at its read timestamp. Only the
+// read methods can be called on it(not ParallelScan, a badger Stream can't
+// be pinned to a timestamp in the unmanaged mode). It's safe for concurrent
+// use; the versions it can see aren't discarded by compaction until Release
+
+// Snapshot a read-only view of the db at now, Release it when done
+func (d *Badger) Snapshot() *Badger {
+	return &Badger{
+		DbHandle:  d.DbHandle,
+		Path:      d.Path,
+		RAM:       d.RAM,
+		MergeKeys: d.MergeKeys,
+		merges:    d.merges,
+		snap:      d.DbHandle.NewTransaction(false),
+	}
+}
+
+// Release discard the transaction of a snapshot, its iterators must be closed
+func (d *Badger) Release() {
+	if d.snap != nil {
+		d.snap.Discard()
+	}
+}
+
+// readTxn the read transaction of a read method, the pinned one of a snapshot
+func (d *Badger) readTxn() *badger.Txn {
+	if d.snap != nil {
+		return d.snap
+	}
+	return d.DbHandle.NewTransaction(false)
+}
+
+// doneTxn discard a transaction returned by readTxn
+func (d *Badger) doneTxn(txn *badger.Txn) {
+	if txn != d.snap {
+		txn.Discard()
+	}
+}
+
+// mergedAt merge the versions of a merge key visible to txn, like the
+// badger.MergeOperator does
+func mergedAt(txn *badger.Txn, k []byte) []byte {
+	v, err := valueAt(txn, k, math.MaxUint64, true)
+	if err != nil {
+		return nil
+	}
+	return v
+}
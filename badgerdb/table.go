@@ -0,0 +1,218 @@
+package badgerdb
+
+import (
+	"github.com/lysShub/kvdb/com"
+
+	badger "github.com/dgraph-io/badger/v2"
+)
+
+// table management
+//
+// an operation is done in one transaction if it fit in badger's
+// transaction size limit, so it's atomic; otherwise it's redone in chunks,
+// every chunk is committed by itself and reported to Progress; a reader
+// may see the partial result of a chunked operation.
+
+// writer update transaction, commit and renew itself when the transaction
+// is too big, unless atomic
+type writer struct {
+	d         *Badger
+	txn       *badger.Txn
+	atomic    bool
+	op        string
+	tableName string
+	done      int // committed
+	n         int // in txn
+}
+
+func (d *Badger) newWriter(atomic bool, op, tableName string) *writer {
+	return &writer{
+		d:         d,
+		txn:       d.DbHandle.NewTransaction(true),
+		atomic:    atomic,
+		op:        op,
+		tableName: tableName,
+	}
+}
+
+func (w *writer) set(e *badger.Entry) error {
+	err := w.txn.SetEntry(e)
+	if err == badger.ErrTxnTooBig && !w.atomic {
+		if err = w.flush(); err != nil {
+			return err
+		}
+		err = w.txn.SetEntry(e)
+	}
+	if err == nil {
+		w.n++
+	}
+	return err
+}
+
+func (w *writer) delete(k []byte) error {
+	err := w.txn.Delete(k)
+	if err == badger.ErrTxnTooBig && !w.atomic {
+		if err = w.flush(); err != nil {
+			return err
+		}
+		err = w.txn.Delete(k)
+	}
+	if err == nil {
+		w.n++
+	}
+	return err
+}
+
+// flush commit the chunk and start a new transaction
+func (w *writer) flush() error {
+	if err := w.commit(); err != nil {
+		return err
+	}
+	w.txn = w.d.DbHandle.NewTransaction(true)
+	return nil
+}
+
+func (w *writer) commit() error {
+	if err := w.txn.Commit(); err != nil {
+		return err
+	}
+	w.done, w.n = w.done+w.n, 0
+	if w.d.Progress != nil && w.op != "" {
+		w.d.Progress(w.op, w.tableName, w.done)
+	}
+	return nil
+}
+
+func (w *writer) discard() {
+	w.txn.Discard()
+}
+
+// chunked run fn in one transaction, rerun it in chunks if the transaction
+// is too big; fn read from read and write to w
+func (d *Badger) chunked(op, tableName string, fn func(read *badger.Txn, w *writer) error) error {
+	w := d.newWriter(true, op, tableName)
+	err := fn(w.txn, w)
+	if err == nil {
+		err = w.commit()
+	}
+	w.discard()
+	if err != badger.ErrTxnTooBig {
+		return err
+	}
+
+	// 分批
+	read := d.DbHandle.NewTransaction(false)
+	defer read.Discard()
+	w = d.newWriter(false, op, tableName)
+	defer w.discard()
+	if err = fn(read, w); err != nil {
+		return err
+	}
+	return w.commit()
+}
+
+// copyTable copy the table src to dst if cp, and delete src if del
+func copyTable(read *badger.Txn, w *writer, src, dst string, cp, del bool) error {
+	opt := badger.DefaultIteratorOptions
+	opt.Prefix = tablePrefix(src)
+	it := read.NewIterator(opt)
+	defer it.Close()
+
+	for it.Seek(opt.Prefix); it.ValidForPrefix(opt.Prefix); it.Next() {
+		item := it.Item()
+		k := item.KeyCopy(nil)
+		if cp {
+			_, id, field, err := parseCell(k)
+			nk := cellKey(dst, id, field)
+			if err == errMetaCell {
+				nk = metaKey(dst, id)
+			} else if err != nil {
+				return err
+			}
+			v, err := item.ValueCopy(nil)
+			if err != nil {
+				return err
+			}
+			e := badger.NewEntry(nk, v).WithMeta(item.UserMeta())
+			e.ExpiresAt = item.ExpiresAt()
+			if err = w.set(e); err != nil {
+				return err
+			}
+		}
+		if del {
+			if err := w.delete(k); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// copyMarker copy the table marker of src to dst if cp, and delete it if del
+func copyMarker(read *badger.Txn, w *writer, src, dst string, cp, del bool) error {
+	if _, err := read.Get(tableKey(src)); err == badger.ErrKeyNotFound {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	if cp {
+		if err := w.set(badger.NewEntry(tableKey(dst), nil)); err != nil {
+			return err
+		}
+	}
+	if del {
+		return w.delete(tableKey(src))
+	}
+	return nil
+}
+
+// RenameTable
+func (d *Badger) RenameTable(oldName, newName string) error {
+	return d.chunked("rename", oldName, func(read *badger.Txn, w *writer) error {
+		if !tableExist(read, oldName) {
+			return com.ErrTableNotExist
+		} else if tableExist(read, newName) {
+			return com.ErrTableExist
+		} else if err := copyMarker(read, w, oldName, newName, true, true); err != nil {
+			return err
+		}
+		return copyTable(read, w, oldName, newName, true, true)
+	})
+}
+
+// CopyTable
+func (d *Badger) CopyTable(src, dst string) error {
+	return d.chunked("copy", src, func(read *badger.Txn, w *writer) error {
+		if !tableExist(read, src) {
+			return com.ErrTableNotExist
+		} else if tableExist(read, dst) {
+			return com.ErrTableExist
+		} else if err := copyMarker(read, w, src, dst, true, false); err != nil {
+			return err
+		}
+		return copyTable(read, w, src, dst, true, false)
+	})
+}
+
+// TruncateTable delete all records in a table, the table is kept by its
+// marker; a missing table is ignored
+func (d *Badger) TruncateTable(tableName string) error {
+	return d.chunked("truncate", tableName, func(read *badger.Txn, w *writer) error {
+		if !tableExist(read, tableName) {
+			return nil
+		} else if err := copyTable(read, w, tableName, "", false, true); err != nil {
+			return err
+		}
+		return w.set(badger.NewEntry(tableKey(tableName), nil))
+	})
+}
+
+// CreateTable create a empty table if it isn't exist
+func (d *Badger) CreateTable(tableName string) error {
+	return d.update(func(txn *badger.Txn) error {
+		if tableExist(txn, tableName) {
+			return nil
+		}
+		return txn.Set(tableKey(tableName), nil)
+	})
+}
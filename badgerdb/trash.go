@@ -0,0 +1,255 @@
+package badgerdb
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"time"
+
+	"github.com/lysShub/kvdb/com"
+
+	badger "github.com/dgraph-io/badger/v2"
+)
+
+// trash
+//
+// a soft deleted key, record or table is moved to the trash namespace(see
+// keys.go) under its trash id, with the expire time kept; the item's info
+// sort before its keys. Moving is done like RenameTable: in one transaction,
+// or in chunks if it's too big. A merge key is trashed with its merged
+// value, and deleted as DeleteKey.
+
+// trashPrefix prefix of all keys of a trash item
+func trashPrefix(trashID string) []byte {
+	return appendSegment([]byte{nsTrash}, tagName, trashID)
+}
+
+// trashInfoKey key of a trash item's info
+func trashInfoKey(trashID string) []byte {
+	return append(trashPrefix(trashID), nsMeta)
+}
+
+// trashKey key of k moved to the trash prefix
+func trashKey(prefix, k []byte) []byte {
+	r := make([]byte, 0, len(prefix)+len(k))
+	return append(append(r, prefix...), k...)
+}
+
+// errNoData nothing to trash
+var errNoData error = errors.New("badgerdb: nothing to trash")
+
+// sourcePrefix the keys moved by the trash item, and if they are a prefix
+func sourcePrefix(item com.TrashItem) ([]byte, bool) {
+	switch item.Kind {
+	case com.TrashKey:
+		return keyKey(item.Key), false
+	case com.TrashRow:
+		return rowPrefix(item.Table, item.RowID), true
+	default:
+		return tablePrefix(item.Table), true
+	}
+}
+
+// moveKey move an item of it to nk with its expire time
+func moveKey(w *writer, item *badger.Item, nk []byte) error {
+	v, err := item.ValueCopy(nil)
+	if err != nil {
+		return err
+	}
+	e := badger.NewEntry(nk, v).WithMeta(item.UserMeta())
+	e.ExpiresAt = item.ExpiresAt()
+	if err = w.set(e); err != nil {
+		return err
+	}
+	return w.delete(item.KeyCopy(nil))
+}
+
+// Trash move the key, record or table of item to the trash; a missing key
+// or record isn't trashed, a missing table is com.ErrTableNotExist
+func (d *Badger) Trash(item com.TrashItem) error {
+	info, err := json.Marshal(item)
+	if err != nil {
+		return err
+	}
+	src, isPrefix := sourcePrefix(item)
+	dst := trashPrefix(item.ID)
+	op := d.merges[item.Key]
+	if item.Kind == com.TrashKey && op != nil {
+		op.Lock()
+		defer op.Unlock()
+	}
+
+	err = d.chunked("trash", item.Table, func(read *badger.Txn, w *writer) error {
+		// 先写info，分批时移动了一部分也能恢复
+		if err := w.set(badger.NewEntry(trashInfoKey(item.ID), info)); err != nil {
+			return err
+		}
+		if !isPrefix && op != nil {
+			v := mergedAt(read, src)
+			if v == nil {
+				return errNoData
+			}
+			if err := w.set(badger.NewEntry(trashKey(dst, src), v)); err != nil {
+				return err
+			}
+			return w.set(deletedEntry(item.Key))
+		} else if !isPrefix {
+			it, err := read.Get(src)
+			if err == badger.ErrKeyNotFound {
+				return errNoData
+			} else if err != nil {
+				return err
+			}
+			return moveKey(w, it, trashKey(dst, src))
+		}
+
+		var n int
+		if item.Kind == com.TrashTable {
+			if it, err := read.Get(tableKey(item.Table)); err == nil {
+				if err = moveKey(w, it, trashKey(dst, tableKey(item.Table))); err != nil {
+					return err
+				}
+				n++
+			} else if err != badger.ErrKeyNotFound {
+				return err
+			}
+		}
+
+		opt := badger.DefaultIteratorOptions
+		opt.Prefix = src
+		it := read.NewIterator(opt)
+		defer it.Close()
+		for it.Seek(src); it.ValidForPrefix(src); it.Next() {
+			if err := moveKey(w, it.Item(), trashKey(dst, it.Item().Key())); err != nil {
+				return err
+			}
+			n++
+		}
+		if n == 0 {
+			return errNoData
+		}
+		return nil
+	})
+	if err == errNoData {
+		if item.Kind == com.TrashTable {
+			return com.ErrTableNotExist
+		}
+		return nil
+	}
+	return err
+}
+
+// readTrashItem
+func readTrashItem(txn *badger.Txn, trashID string) (com.TrashItem, error) {
+	var item com.TrashItem
+	v, _ := readValue(txn, trashInfoKey(trashID))
+	if v == nil {
+		return item, com.ErrTrashNotExist
+	}
+	return item, json.Unmarshal(v, &item)
+}
+
+// ListTrash the trash items in order of id
+func (d *Badger) ListTrash() ([]com.TrashItem, error) {
+	txn := d.readTxn()
+	defer d.doneTxn(txn)
+
+	opt := badger.DefaultIteratorOptions
+	opt.PrefetchValues = false
+	opt.Prefix = []byte{nsTrash}
+	it := txn.NewIterator(opt)
+	defer it.Close()
+
+	var r = []com.TrashItem{}
+	for it.Seek(opt.Prefix); it.ValidForPrefix(opt.Prefix); {
+		_, id, _, err := readSegment(it.Item().Key()[1:])
+		if err != nil {
+			return nil, err
+		}
+		item, err := readTrashItem(txn, string(id))
+		if err == nil {
+			r = append(r, item)
+		} else if err != com.ErrTrashNotExist { // 正在清除
+			return nil, err
+		}
+		it.Seek(prefixEnd(trashPrefix(string(id))))
+	}
+	return r, nil
+}
+
+// Restore move the trash item back, it's com.ErrRestoreExist if the key,
+// record or table is written again; return the restored item
+func (d *Badger) Restore(trashID string) (com.TrashItem, error) {
+	var item com.TrashItem
+	prefix := trashPrefix(trashID)
+	err := d.chunked("restore", "", func(read *badger.Txn, w *writer) error {
+		var err error
+		if item, err = readTrashItem(read, trashID); err != nil {
+			return err
+		}
+		if src, isPrefix := sourcePrefix(item); item.Kind == com.TrashTable {
+			if tableExist(read, item.Table) {
+				return com.ErrRestoreExist
+			}
+		} else if isPrefix {
+			if existPrefix(read, src) {
+				return com.ErrRestoreExist
+			}
+		} else if _, err = read.Get(src); err != badger.ErrKeyNotFound {
+			return com.ErrRestoreExist
+		}
+
+		opt := badger.DefaultIteratorOptions
+		opt.Prefix = prefix
+		it := read.NewIterator(opt)
+		defer it.Close()
+		for it.Seek(prefix); it.ValidForPrefix(prefix); it.Next() {
+			k := it.Item().Key()[len(prefix):]
+			if bytes.Equal(k, []byte{nsMeta}) {
+				continue
+			} else if err := moveKey(w, it.Item(), append([]byte{}, k...)); err != nil {
+				return err
+			}
+		}
+		// 最后删除info，分批时未移动完的仍在回收站中
+		return w.delete(trashInfoKey(trashID))
+	})
+	return item, err
+}
+
+// PurgeTrash delete the trash items deleted before t, return the count
+func (d *Badger) PurgeTrash(t time.Time) (int, error) {
+	items, err := d.ListTrash()
+	if err != nil {
+		return 0, err
+	}
+	var n int
+	for _, item := range items {
+		if !item.DeletedAt.Before(t) {
+			continue
+		}
+		err := d.chunked("purge", "", func(read *badger.Txn, w *writer) error {
+			return deleteKeys(read, w, trashPrefix(item.ID))
+		})
+		if err != nil {
+			return n, err
+		}
+		n++
+	}
+	return n, nil
+}
+
+// deleteKeys delete all keys with the prefix
+func deleteKeys(read *badger.Txn, w *writer, prefix []byte) error {
+	opt := badger.DefaultIteratorOptions
+	opt.PrefetchValues = false
+	opt.Prefix = prefix
+	it := read.NewIterator(opt)
+	defer it.Close()
+	for it.Seek(prefix); it.ValidForPrefix(prefix); it.Next() {
+		if err := w.delete(it.Item().KeyCopy(nil)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
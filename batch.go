@@ -0,0 +1,115 @@
+package kvdb
+
+import (
+	"time"
+
+	"github.com/lysShub/kvdb/com"
+)
+
+// ErrBatchTooBig the atomic batch is over the backend's transaction limit
+var ErrBatchTooBig = com.ErrBatchTooBig
+
+// Batch collect writes and commit them together, rather than one commit per call
+//
+//	b := db.NewBatch()
+//	b.SetKey("k", v)
+//	b.SetTableRow("table", "id", row)
+//	err := b.Commit()
+//
+// the writes are done in order when Commit. By default they are committed
+// in chunks(badgerdb: over the transaction size limit, boltdb: ChunkRows
+// writes per transaction; memdb: all under one lock), a failed Commit may
+// leave the previous chunks written; an Atomic batch is committed in one transaction, or
+// ErrBatchTooBig and nothing is written.
+//
+// the values and rows are referenced until Commit, don't modify them. A
+// Batch isn't safe for concurrent use.
+type Batch struct {
+	Atomic bool
+
+	d   *KVDB
+	ops []com.BatchOp
+}
+
+// NewBatch create a empty batch
+func (d *KVDB) NewBatch() *Batch {
+	return &Batch{d: d}
+}
+
+func batchTTL(ttl []time.Duration) time.Duration {
+	if len(ttl) != 0 {
+		return ttl[0]
+	}
+	return 0
+}
+
+// SetKey create/update a value
+func (b *Batch) SetKey(key string, value []byte, ttl ...time.Duration) {
+	b.ops = append(b.ops, com.BatchOp{Kind: com.OpSetKey, Key: key, Value: value, TTL: batchTTL(ttl)})
+}
+
+// DeleteKey delete a value
+func (b *Batch) DeleteKey(key string) {
+	b.ops = append(b.ops, com.BatchOp{Kind: com.OpDeleteKey, Key: key})
+}
+
+// SetTableRow create/update a record in a table
+func (b *Batch) SetTableRow(tableName, id string, p map[string][]byte, ttl ...time.Duration) {
+	b.ops = append(b.ops, com.BatchOp{Kind: com.OpSetRow, Table: tableName, ID: id, Row: p, TTL: batchTTL(ttl)})
+}
+
+// SetTableValue create/update some one field's value in a table's some one record
+func (b *Batch) SetTableValue(tableName, id, field string, value []byte, ttl ...time.Duration) {
+	b.ops = append(b.ops, com.BatchOp{Kind: com.OpSetValue, Table: tableName, ID: id, Field: field, Value: value, TTL: batchTTL(ttl)})
+}
+
+// DeleteTableRow delete some one record in a table, a missing record is ignored
+func (b *Batch) DeleteTableRow(tableName, id string) {
+	b.ops = append(b.ops, com.BatchOp{Kind: com.OpDeleteRow, Table: tableName, ID: id})
+}
+
+// Len count of the writes
+func (b *Batch) Len() int {
+	return len(b.ops)
+}
+
+// Reset drop the writes
+func (b *Batch) Reset() {
+	b.ops = b.ops[:0]
+}
+
+// Commit write all, the batch is reset and can be reused after it
+func (b *Batch) Commit() error {
+	if len(b.ops) == 0 {
+		return nil
+	}
+	defer b.Reset()
+	if b.d.DH.ch != nil {
+		defer b.invalidate()
+	}
+	if b.d.Type == 0 {
+		return b.d.DH.bg.WriteBatch(b.ops, b.Atomic)
+	} else if b.d.Type == 1 {
+		return b.d.DH.bt.WriteBatch(b.ops, b.Atomic)
+	} else if b.d.Type == 2 {
+		return b.d.DH.mm.WriteBatch(b.ops, b.Atomic)
+	}
+	return errType
+}
+
+func (b *Batch) invalidate() {
+	ch := b.d.DH.ch
+	for _, op := range b.ops {
+		switch op.Kind {
+		case com.OpSetKey, com.OpDeleteKey:
+			ch.InvalidateKey(op.Key)
+		case com.OpSetRow:
+			ch.InvalidateRow(op.Table, op.ID, fieldNames(op.Row)...)
+		case com.OpSetValue:
+			ch.InvalidateRow(op.Table, op.ID, op.Field)
+		case com.OpDeleteRow:
+			// the deleted fields are unknown
+			ch.InvalidateTable(op.Table)
+		}
+	}
+}
@@ -0,0 +1,82 @@
+package kvdb
+
+import "time"
+
+// binary key/name operations
+//
+// names are stored as raw bytes on both backends, the []byte variants are
+// same as the string api and round-trip any bytes, e.g. hashes and UUIDs.
+//
+// ordering: keys, table names, ids and fields are ordered by unsigned
+// bytewise comparison (bytes.Compare) on both backends, a name sort before
+// all names that it is a prefix of.
+//
+// limits: boltdb can't store an empty key/name, and the length is limited
+// to 32768 bytes; a boltdb table can't use the Root name, and a table or a
+// field can't be named "\x00kvdb"(the trash and the row meta). badgerdb
+// limits a whole encoded key to 65000 bytes.
+
+// SetKeyBytes create/update a value
+func (d *KVDB) SetKeyBytes(key []byte, value []byte, ttl ...time.Duration) error {
+	return d.SetKey(string(key), value, ttl...)
+}
+
+// DeleteKeyBytes delete a value
+func (d *KVDB) DeleteKeyBytes(key []byte) error {
+	return d.DeleteKey(string(key))
+}
+
+// ReadKeyBytes read a value
+func (d *KVDB) ReadKeyBytes(key []byte) []byte {
+	return d.ReadKey(string(key))
+}
+
+// SetTableBytes create/update a table, the map keys hold the raw id and field bytes
+func (d *KVDB) SetTableBytes(tableName []byte, p map[string]map[string][]byte, ttl ...time.Duration) error {
+	return d.SetTable(string(tableName), p, ttl...)
+}
+
+// SetTableRowBytes create/update a record in a table
+func (d *KVDB) SetTableRowBytes(tableName, id []byte, p map[string][]byte, ttl ...time.Duration) error {
+	return d.SetTableRow(string(tableName), string(id), p, ttl...)
+}
+
+// SetTableValueBytes create/update some one field's value in a table's some one record
+func (d *KVDB) SetTableValueBytes(tableName, id, field []byte, value []byte, ttl ...time.Duration) error {
+	return d.SetTableValue(string(tableName), string(id), string(field), value, ttl...)
+}
+
+// DeleteTableBytes deleta a teble
+func (d *KVDB) DeleteTableBytes(tableName []byte) error {
+	return d.DeleteTable(string(tableName))
+}
+
+// DeleteTableRowBytes delete some one record in a table
+func (d *KVDB) DeleteTableRowBytes(tableName, id []byte) error {
+	return d.DeleteTableRow(string(tableName), string(id))
+}
+
+// ReadTableBytes read all date in a table, the map keys hold the raw id and field bytes
+func (d *KVDB) ReadTableBytes(tableName []byte) map[string]map[string][]byte {
+	return d.ReadTable(string(tableName))
+}
+
+// ReadTableExistBytes judge the table is exist
+func (d *KVDB) ReadTableExistBytes(tableName []byte) bool {
+	return d.ReadTableExist(string(tableName))
+}
+
+// ReadTableRowBytes read a record in a table
+func (d *KVDB) ReadTableRowBytes(tableName, id []byte) map[string][]byte {
+	return d.ReadTableRow(string(tableName), string(id))
+}
+
+// ReadTableRowExistBytes judge a record is exist in a table
+func (d *KVDB) ReadTableRowExistBytes(tableName, id []byte) bool {
+	return d.ReadTableRowExist(string(tableName), string(id))
+}
+
+// ReadTableValueBytes read a field's value of some one record in a table
+func (d *KVDB) ReadTableValueBytes(tableName, id, field []byte) []byte {
+	return d.ReadTableValue(string(tableName), string(id), string(field))
+}
@@ -0,0 +1,65 @@
+package boltdb
+
+import (
+	"errors"
+	"io"
+	"os"
+	"time"
+
+	"github.com/boltdb/bolt"
+)
+
+// backup
+//
+// a backup is a copy of the db file written in a read transaction, so it's
+// consistent and the writes aren't blocked. Load write it to a new file and
+// replace the db file with it like Compact.
+
+// ErrLoadBusy Load can't run with open read transactions
+var ErrLoadBusy error = errors.New("boltdb: can't load a backup with open snapshots or iterators")
+
+// Backup write a copy of the db to w, return the bytes written; boltdb has
+// no versions, a backup is always full
+func (d *Bolt) Backup(w io.Writer) (int64, error) {
+	var n int64
+	err := d.view(func(tx *bolt.Tx) error {
+		var err error
+		n, err = tx.WriteTo(w)
+		return err
+	})
+	return n, err
+}
+
+// Load replace the db with a backup written by Backup
+func (d *Bolt) Load(r io.Reader) error {
+	src := d.Path + ".restore"
+	f, err := os.OpenFile(src, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0600)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(f, r)
+	if err == nil {
+		err = f.Sync()
+	}
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil { // 确认是bolt文件
+		var db *bolt.DB
+		if db, err = bolt.Open(src, 0600, &bolt.Options{Timeout: 1 * time.Second, ReadOnly: true}); err == nil {
+			err = db.Close()
+		}
+	}
+	if err != nil {
+		os.Remove(src)
+		return err
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.DbHandle.Stats().OpenTxN > 0 {
+		os.Remove(src)
+		return ErrLoadBusy
+	}
+	return d.replace(src)
+}
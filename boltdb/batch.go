@@ -0,0 +1,87 @@
+package boltdb
+
+import (
+	"github.com/lysShub/kvdb/com"
+
+	"github.com/boltdb/bolt"
+)
+
+// WriteBatch write the ops in order, ChunkRows ops per transaction; if
+// atomic, in one transaction. Deleting a missing record isn't an error
+func (d *Bolt) WriteBatch(ops []com.BatchOp, atomic bool) error {
+	var n = d.chunkRows()
+	if atomic {
+		n = len(ops)
+	}
+	for i := 0; i < len(ops); i = i + n {
+		end := i + n
+		if end > len(ops) {
+			end = len(ops)
+		}
+		err := d.update(func(tx *bolt.Tx) error {
+			for _, op := range ops[i:end] {
+				if err := d.writeOp(tx, op); err != nil {
+					return err
+				}
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (d *Bolt) writeOp(tx *bolt.Tx, op com.BatchOp) error {
+	switch op.Kind {
+	case com.OpSetKey:
+		b, err := tx.CreateBucketIfNotExists(d.Root)
+		if err != nil {
+			return err
+		}
+		return b.Put([]byte(op.Key), op.Value)
+	case com.OpDeleteKey:
+		if b := tx.Bucket(d.Root); b != nil {
+			return b.Delete([]byte(op.Key))
+		}
+		return nil
+	}
+
+	if err := d.checkTable(op.Table); err != nil {
+		return err
+	}
+	switch op.Kind {
+	case com.OpSetRow, com.OpSetValue:
+		b, err := tx.CreateBucketIfNotExists([]byte(op.Table))
+		if err != nil {
+			return err
+		}
+		sb, err := b.CreateBucketIfNotExists([]byte(op.ID))
+		if err != nil {
+			return err
+		}
+		if op.Kind == com.OpSetValue {
+			err = sb.Put([]byte(op.Field), op.Value)
+		}
+		for f, v := range op.Row {
+			if err = sb.Put([]byte(f), v); err != nil {
+				return err
+			}
+		}
+		if err != nil {
+			return err
+		}
+		_, err = touchRow(b, sb)
+		return err
+	case com.OpDeleteRow:
+		b := tx.Bucket([]byte(op.Table))
+		if b == nil {
+			return nil
+		}
+		if err := b.DeleteBucket([]byte(op.ID)); err != nil && err != bolt.ErrBucketNotFound {
+			return err
+		}
+	}
+	return nil
+}
@@ -1,9 +1,12 @@
 package boltdb
 
 import (
+	"bytes"
+	"errors"
 	"os"
 	"path/filepath"
-	"time"
+	"sort"
+	"sync"
 
 	"github.com/lysShub/kvdb/com"
 
@@ -17,11 +20,45 @@ type Bolt struct {
 	DbHandle Handle //句柄
 	Path     string //路径
 	Root     []byte //key/value的bucket名，默认_root
+
+	Progress    com.Progress //表操作完成后的进度回调，可以为nil
+	ChunkRows   int          //分批操作每个事务的行数，默认10000
+	MmapSize    int          //初始mmap大小，默认256MB；文件超过它时写事务要等待所有读事务结束
+	FillPercent float64      //Compact写入的页填充比例，默认0.9
+
+	snap   *bolt.Tx // 快照的读事务
+	snapMu *sync.Mutex
+	mu     *sync.RWMutex // 事务持有R，Compact替换文件时持有W
 }
 
 var err error
 var b *bolt.Bucket
 
+// defaultMmapSize a write that remap the file wait for the read transactions
+// (snapshots, iterators), reserve the address space to remap rarely
+const defaultMmapSize = 256 << 20
+
+var errRoot error = errors.New("boltdb: table name is same as the key/value bucket name Root")
+
+var errReserved error = errors.New("boltdb: table name \"\\x00kvdb\" is reserved")
+
+// checkTable table is top level bucket, can't be the key/value bucket or kvdbBucket
+func (d *Bolt) checkTable(tableName string) error {
+	if tableName == string(d.Root) {
+		return errRoot
+	} else if tableName == string(kvdbBucket) {
+		return errReserved
+	}
+	return nil
+}
+
+func (d *Bolt) chunkRows() int {
+	if d.ChunkRows <= 0 {
+		return 10000
+	}
+	return d.ChunkRows
+}
+
 // OpenDb open
 func (d *Bolt) OpenDb() error {
 	if d.Path != "" {
@@ -54,11 +91,16 @@ func (d *Bolt) OpenDb() error {
 		d.Root = []byte("_root")
 	}
 
-	db, err := bolt.Open(d.Path, 0600, &bolt.Options{Timeout: 1 * time.Second})
+	if d.MmapSize <= 0 {
+		d.MmapSize = defaultMmapSize
+	}
+
+	db, err := d.openFile(d.Path)
 	if err != nil {
 		return err
 	}
 	d.DbHandle = db
+	d.mu = &sync.RWMutex{}
 	return nil
 }
 
@@ -71,7 +113,7 @@ func (d *Bolt) Close() error {
 
 // SetKey set or updata key/value
 func (d *Bolt) SetKey(key string, value []byte) error {
-	err = d.DbHandle.Update(func(tx *bolt.Tx) error {
+	err = d.update(func(tx *bolt.Tx) error {
 		if b, err = tx.CreateBucketIfNotExists(d.Root); err != nil {
 			return err
 		}
@@ -82,7 +124,7 @@ func (d *Bolt) SetKey(key string, value []byte) error {
 
 // DeleteKey delete key
 func (d *Bolt) DeleteKey(key string) error {
-	err = d.DbHandle.Update(func(tx *bolt.Tx) error {
+	err = d.update(func(tx *bolt.Tx) error {
 		b := tx.Bucket(d.Root)
 		if b == nil {
 			return nil
@@ -95,11 +137,11 @@ func (d *Bolt) DeleteKey(key string) error {
 // ReadKey
 func (d *Bolt) ReadKey(key string) []byte {
 	var r []byte
-	err = d.DbHandle.View(func(tx *bolt.Tx) error {
+	err = d.view(func(tx *bolt.Tx) error {
 		if b = tx.Bucket(d.Root); b == nil {
 			return nil
 		}
-		_, r = b.Cursor().Seek([]byte(key))
+		r = copyBytes(b.Get([]byte(key)))
 		return nil
 	})
 	return r
@@ -108,35 +150,66 @@ func (d *Bolt) ReadKey(key string) []byte {
 // table
 
 // SetTable
+// the records are written in order of id, ChunkRows records per
+// transaction; so it's atomic only if the table isn't more than ChunkRows
 func (d *Bolt) SetTable(tableName string, p map[string]map[string][]byte) error {
+	if err := d.checkTable(tableName); err != nil {
+		return err
+	}
 
-	err = d.DbHandle.Update(func(tx *bolt.Tx) error {
-		b, err = tx.CreateBucketIfNotExists([]byte(tableName))
-		if err != nil {
-			return err
+	var ids []string = make([]string, 0, len(p))
+	for id := range p {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids) // 顺序写入，页分裂更少
+
+	var done int
+	for len(ids) != 0 {
+		var chunk []string = ids
+		if len(chunk) > d.chunkRows() {
+			chunk = ids[:d.chunkRows()]
 		}
+		ids = ids[len(chunk):]
 
-		var sb *bolt.Bucket
-		for id, fv := range p {
-			if sb, err = b.CreateBucketIfNotExists([]byte(id)); err != nil { //sb: secondary bucket
+		err := d.update(func(tx *bolt.Tx) error {
+			b, err := tx.CreateBucketIfNotExists([]byte(tableName))
+			if err != nil {
 				return err
 			}
-			for f, v := range fv {
-				err := sb.Put([]byte(f), v)
-				if err != nil {
+
+			var sb *bolt.Bucket
+			for _, id := range chunk {
+				if sb, err = b.CreateBucketIfNotExists([]byte(id)); err != nil { //sb: secondary bucket
+					return err
+				}
+				for f, v := range p[id] {
+					if err = sb.Put([]byte(f), v); err != nil {
+						return err
+					}
+					done++
+				}
+				if _, err = touchRow(b, sb); err != nil {
 					return err
 				}
 			}
+			return nil
+		})
+		if err != nil {
+			return err
 		}
-
-		return nil
-	})
-	return err
+		if d.Progress != nil {
+			d.Progress("set", tableName, done)
+		}
+	}
+	return nil
 }
 
 // SetTableRow
 func (d *Bolt) SetTableRow(tableName, id string, fv map[string][]byte) error {
-	err = d.DbHandle.Update(func(tx *bolt.Tx) error {
+	if err := d.checkTable(tableName); err != nil {
+		return err
+	}
+	err = d.update(func(tx *bolt.Tx) error {
 		b, err = tx.CreateBucketIfNotExists([]byte(tableName))
 		if err != nil {
 			return err
@@ -152,15 +225,19 @@ func (d *Bolt) SetTableRow(tableName, id string, fv map[string][]byte) error {
 				return err
 			}
 		}
-		return nil
+		_, err = touchRow(b, sb)
+		return err
 	})
 	return err
 }
 
 // SetTableValue
 func (d *Bolt) SetTableValue(tableName, id, field string, value []byte) error {
+	if err := d.checkTable(tableName); err != nil {
+		return err
+	}
 
-	err = d.DbHandle.Update(func(tx *bolt.Tx) error {
+	err = d.update(func(tx *bolt.Tx) error {
 		b, err = tx.CreateBucketIfNotExists([]byte(tableName))
 		if err != nil {
 			return err
@@ -171,22 +248,157 @@ func (d *Bolt) SetTableValue(tableName, id, field string, value []byte) error {
 			return err
 		}
 
-		return sb.Put([]byte(field), value)
+		if err = sb.Put([]byte(field), value); err != nil {
+			return err
+		}
+		_, err = touchRow(b, sb)
+		return err
 	})
 	return err
 }
 
-// DeleteTable
-func (d *Bolt) DeleteTable(tableName string) error {
-	err = d.DbHandle.Update(func(tx *bolt.Tx) error {
-		return tx.DeleteBucket([]byte(tableName))
+// ReplaceTableRow set the record to fv, the fields not in fv are deleted
+func (d *Bolt) ReplaceTableRow(tableName, id string, fv map[string][]byte) error {
+	if err := d.checkTable(tableName); err != nil {
+		return err
+	}
+	return d.update(func(tx *bolt.Tx) error {
+		return patchRow(tx, tableName, id, fv, nil, true)
+	})
+}
+
+// PatchTableRow set the fields in set and delete the fields in unset
+func (d *Bolt) PatchTableRow(tableName, id string, set map[string][]byte, unset []string) error {
+	if err := d.checkTable(tableName); err != nil {
+		return err
+	}
+	return d.update(func(tx *bolt.Tx) error {
+		return patchRow(tx, tableName, id, set, unset, false)
 	})
+}
+
+// DeleteTableValue delete a field of a record
+func (d *Bolt) DeleteTableValue(tableName, id, field string) error {
+	if err := d.checkTable(tableName); err != nil {
+		return err
+	}
+	return d.update(func(tx *bolt.Tx) error {
+		return patchRow(tx, tableName, id, nil, []string{field}, false)
+	})
+}
+
+// patchRow delete the fields in unset, or all fields not in set if replace,
+// then set the fields in set; a record without field is deleted, same as badgerdb
+func patchRow(tx *bolt.Tx, tableName, id string, set map[string][]byte, unset []string, replace bool) error {
+	var b, sb *bolt.Bucket
+	var err error
+	if len(set) == 0 {
+		if b = tx.Bucket([]byte(tableName)); b == nil {
+			return nil
+		} else if sb = b.Bucket([]byte(id)); sb == nil {
+			return nil
+		}
+	} else {
+		if b, err = tx.CreateBucketIfNotExists([]byte(tableName)); err != nil {
+			return err
+		} else if sb, err = b.CreateBucketIfNotExists([]byte(id)); err != nil {
+			return err
+		}
+	}
+
+	for _, f := range unset {
+		if err = sb.Delete([]byte(f)); err != nil {
+			return err
+		}
+	}
+	if replace {
+		var drop [][]byte
+		c := sb.Cursor()
+		for k, v := c.First(); k != nil; k, v = c.Next() {
+			if _, ok := set[string(k)]; !ok && v != nil {
+				drop = append(drop, copyBytes(k))
+			}
+		}
+		for _, k := range drop {
+			if err = sb.Delete(k); err != nil {
+				return err
+			}
+		}
+	}
+	for f, v := range set {
+		if err = sb.Put([]byte(f), v); err != nil {
+			return err
+		}
+	}
+
+	if !hasFields(sb) {
+		return b.DeleteBucket([]byte(id))
+	}
+	_, err = touchRow(b, sb)
 	return err
 }
 
+// DeleteTable
+// the records are deleted ChunkRows per transaction, then the table bucket;
+// so it's atomic only if the table isn't more than ChunkRows records
+func (d *Bolt) DeleteTable(tableName string) error {
+	if err := d.checkTable(tableName); err != nil {
+		return err
+	}
+	return d.deleteRows("delete", tableName, true)
+}
+
+// deleteRows delete all records of a table in chunks, and the table bucket if drop
+func (d *Bolt) deleteRows(op, tableName string, drop bool) error {
+	var done int
+	for deleted := false; !deleted; {
+		err := d.update(func(tx *bolt.Tx) error {
+			b := tx.Bucket([]byte(tableName))
+			if b == nil {
+				if drop {
+					return bolt.ErrBucketNotFound
+				}
+				deleted = true
+				return nil
+			}
+			var ids [][]byte
+			walkRowIDs(b, com.ListOptions{Limit: d.chunkRows() + 1}, func(id []byte) bool {
+				ids = append(ids, copyBytes(id))
+				return true
+			})
+			if len(ids) <= d.chunkRows() {
+				deleted = true
+				done = done + len(ids)
+				if drop {
+					return tx.DeleteBucket([]byte(tableName))
+				}
+			} else {
+				ids = ids[:d.chunkRows()]
+				done = done + len(ids)
+			}
+			for _, id := range ids {
+				if err := b.DeleteBucket(id); err != nil {
+					return err
+				}
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		if d.Progress != nil {
+			d.Progress(op, tableName, done)
+		}
+	}
+	return nil
+}
+
 // DeleteTableRow
 func (d *Bolt) DeleteTableRow(tableName, id string) error {
-	err = d.DbHandle.Update(func(tx *bolt.Tx) error {
+	if err := d.checkTable(tableName); err != nil {
+		return err
+	}
+	err = d.update(func(tx *bolt.Tx) error {
 		b := tx.Bucket([]byte(tableName))
 		if b == nil { // bucket not exist
 			return nil
@@ -198,62 +410,26 @@ func (d *Bolt) DeleteTableRow(tableName, id string) error {
 
 // ReadTable
 func (d *Bolt) ReadTable(tableName string) map[string]map[string][]byte {
+	if d.checkTable(tableName) != nil {
+		return nil
+	}
 	var r map[string]map[string][]byte = make(map[string]map[string][]byte)
-
-	_ = d.DbHandle.View(func(tx *bolt.Tx) error {
-		b := tx.Bucket([]byte(tableName))
-		if b == nil {
+	_ = d.view(func(tx *bolt.Tx) error {
+		return iterateTable(tx.Bucket([]byte(tableName)), func(id string, row map[string][]byte) error {
+			r[id] = row
 			return nil
-		}
-		var f func(buk *bolt.Bucket) error
-		var tmp map[string][]byte = make(map[string][]byte)
-		var beforeID string
-		f = func(buk *bolt.Bucket) error {
-			err := d.DbHandle.View(func(tx *bolt.Tx) error {
-				var c *bolt.Cursor
-				if buk == nil {
-					c = tx.Cursor()
-				} else {
-					c = buk.Cursor()
-				}
-				for k, v := c.First(); k != nil; k, v = c.Next() {
-					if k != nil {
-						if v == nil {
-							// id
-							if beforeID != "" {
-								r[beforeID] = tmp
-								tmp = make(map[string][]byte)
-							}
-							beforeID = string(k)
-							var buk2 *bolt.Bucket
-							if buk == nil {
-								buk2 = tx.Bucket(k)
-							} else {
-								buk2 = buk.Bucket(k)
-							}
-							f(buk2)
-						} else {
-							// field
-							tmp[string(k)] = v
-						}
-					}
-				}
-				r[beforeID] = tmp
-				return nil
-			})
-			return err
-		}
-		f(b)
-
-		return nil
+		})
 	})
 	return r
 }
 
 // ReadTableExist
 func (d *Bolt) ReadTableExist(tableName string) bool {
+	if d.checkTable(tableName) != nil {
+		return false
+	}
 	var r bool
-	_ = d.DbHandle.View(func(tx *bolt.Tx) error {
+	_ = d.view(func(tx *bolt.Tx) error {
 		b := tx.Bucket([]byte(tableName))
 		if b != nil {
 			r = true
@@ -267,8 +443,11 @@ func (d *Bolt) ReadTableExist(tableName string) bool {
 
 // ReadTableRow
 func (d *Bolt) ReadTableRow(tableName, id string) map[string][]byte {
+	if d.checkTable(tableName) != nil {
+		return nil
+	}
 	var r map[string][]byte = make(map[string][]byte)
-	_ = d.DbHandle.View(func(tx *bolt.Tx) error {
+	_ = d.view(func(tx *bolt.Tx) error {
 		b := tx.Bucket([]byte(tableName))
 		if b == nil {
 			r = nil
@@ -279,10 +458,7 @@ func (d *Bolt) ReadTableRow(tableName, id string) map[string][]byte {
 			r = nil
 			return nil
 		}
-		c := sb.Cursor()
-		for k, v := c.First(); k != nil; k, v = c.Next() {
-			r[string(k)] = v
-		}
+		r = readRow(sb)
 		return nil
 	})
 	return r
@@ -290,8 +466,11 @@ func (d *Bolt) ReadTableRow(tableName, id string) map[string][]byte {
 
 // ReadTableRowExist
 func (d *Bolt) ReadTableRowExist(tableName, id string) bool {
+	if d.checkTable(tableName) != nil {
+		return false
+	}
 	var r bool = false
-	_ = d.DbHandle.View(func(tx *bolt.Tx) error {
+	_ = d.view(func(tx *bolt.Tx) error {
 		b := tx.Bucket([]byte(tableName))
 		if b == nil {
 			r = false
@@ -302,11 +481,7 @@ func (d *Bolt) ReadTableRowExist(tableName, id string) bool {
 			r = false
 			return nil
 		}
-		c := sb.Cursor()
-		for k, _ := c.First(); k != nil; k, _ = c.Next() {
-			r = true
-			break
-		}
+		r = hasFields(sb)
 		return nil
 	})
 	return r
@@ -315,8 +490,11 @@ func (d *Bolt) ReadTableRowExist(tableName, id string) bool {
 
 // ReadTableValue
 func (d *Bolt) ReadTableValue(tableName, id, field string) []byte {
+	if d.checkTable(tableName) != nil {
+		return nil
+	}
 	var r []byte
-	_ = d.DbHandle.View(func(tx *bolt.Tx) error {
+	_ = d.view(func(tx *bolt.Tx) error {
 		b := tx.Bucket([]byte(tableName))
 		if b == nil {
 			return nil
@@ -325,98 +503,151 @@ func (d *Bolt) ReadTableValue(tableName, id, field string) []byte {
 		if sb = b.Bucket([]byte(id)); sb == nil {
 			return nil
 		}
-		r = sb.Get([]byte(field))
+		r = copyBytes(sb.Get([]byte(field)))
 		return nil
 	})
 	return r
 }
 
 // ReadTableLimits
-func (d *Bolt) ReadTableLimits1(tableName, field, exp string, value int) []string {
+func (d *Bolt) ReadTableLimits(tableName, field, exp string, value int) []string {
+	if d.checkTable(tableName) != nil {
+		return nil
+	}
 	var r []string
-	_ = d.DbHandle.View(func(tx *bolt.Tx) error {
+	_ = d.view(func(tx *bolt.Tx) error {
 		b := tx.Bucket([]byte(tableName))
-		if b == nil {
-			r = nil
-			return nil
-		}
-
-		var c, sc *bolt.Cursor = b.Cursor(), nil
-		for k, v := c.First(); k != nil; k, v = c.Next() {
-			if k != nil && v == nil {
-				id := k
-
-				sc = tx.Bucket(k).Cursor()
-				for k, v := sc.First(); k != nil; k, v = sc.Next() {
-					if string(k) == field {
-						fag, err := com.ExpressionCalculate(exp, value, v)
-						if err != nil {
-							return nil
-						}
-						if fag {
-							r = append(r, string(id))
-						}
-
-					}
-				}
+		walkRowIDs(b, com.ListOptions{}, func(id []byte) bool {
+			v := b.Bucket(id).Get([]byte(field))
+			if v == nil {
+				return true
 			}
-		}
+			fag, err := com.ExpressionCalculate(exp, value, v)
+			if err != nil {
+				r = nil
+				return false
+			}
+			if fag {
+				r = append(r, string(id))
+			}
+			return true
+		})
 		return nil
 	})
+	return r
+}
 
+// ReadTableLimits1 same as ReadTableLimits
+func (d *Bolt) ReadTableLimits1(tableName, field, exp string, value int) []string {
+	return d.ReadTableLimits(tableName, field, exp, value)
+}
+
+// copyBytes copy a value out of the transaction, bolt's memory is only
+// valid in a transaction
+func copyBytes(v []byte) []byte {
+	if v == nil {
+		return nil
+	}
+	r := make([]byte, len(v))
+	copy(r, v)
 	return r
 }
 
-func (d *Bolt) ReadTableLimits(tableName, field, exp string, value int) []string {
-	var r []string
-	_ = d.DbHandle.View(func(tx *bolt.Tx) error {
-		b := tx.Bucket([]byte(tableName))
-		if b == nil {
+// introspection
+
+// ListTables all table names(top level buckets except Root and \x00kvdb), in order
+func (d *Bolt) ListTables() []string {
+	var r []string = []string{}
+	_ = d.view(func(tx *bolt.Tx) error {
+		return tx.ForEach(func(name []byte, _ *bolt.Bucket) error {
+			if !d.isReserved(name) {
+				r = append(r, string(name))
+			}
 			return nil
-		}
+		})
+	})
+	return r
+}
 
-		var f func(buk *bolt.Bucket) error
-		var beforeID string
-		f = func(buk *bolt.Bucket) error {
-			err := d.DbHandle.View(func(tx *bolt.Tx) error {
+// ListRowIDs ids in a table, in order
+func (d *Bolt) ListRowIDs(tableName string, opts com.ListOptions) []string {
+	var r []string = []string{}
+	if d.checkTable(tableName) != nil {
+		return r
+	}
+	_ = d.view(func(tx *bolt.Tx) error {
+		walkRowIDs(tx.Bucket([]byte(tableName)), opts, func(id []byte) bool {
+			r = append(r, string(id))
+			return true
+		})
+		return nil
+	})
+	return r
+}
 
-				var c *bolt.Cursor
-				if buk == nil {
-					c = tx.Cursor()
-				} else {
-					c = buk.Cursor()
-				}
-				for k, v := c.First(); k != nil; k, v = c.Next() {
-					if v == nil {
-						// id
-						beforeID = string(k)
-						var buk2 *bolt.Bucket
-						if buk == nil {
-							buk2 = tx.Bucket(k)
-						} else {
-							buk2 = buk.Bucket(k)
-						}
-						f(buk2)
-					} else {
-						// field
-						if string(k) == field {
-							fag, err := com.ExpressionCalculate(exp, value, v)
-							if err != nil {
-								return nil
-							}
-							if fag {
-								r = append(r, string(beforeID))
-							}
-						}
-					}
+// ListFields all fields in a table and the count of records having it
+func (d *Bolt) ListFields(tableName string) map[string]int {
+	var r map[string]int = make(map[string]int)
+	if d.checkTable(tableName) != nil {
+		return r
+	}
+	_ = d.view(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte(tableName))
+		walkRowIDs(b, com.ListOptions{}, func(id []byte) bool {
+			c := b.Bucket(id).Cursor()
+			for k, v := c.First(); k != nil; k, v = c.Next() {
+				if v != nil {
+					r[string(k)]++
 				}
-				return nil
-			})
-			return err
-		}
-		f(b)
+			}
+			return true
+		})
 		return nil
 	})
-
 	return r
 }
+
+// CountRows count of records in a table
+func (d *Bolt) CountRows(tableName string) int {
+	var n int
+	if d.checkTable(tableName) != nil {
+		return 0
+	}
+	_ = d.view(func(tx *bolt.Tx) error {
+		walkRowIDs(tx.Bucket([]byte(tableName)), com.ListOptions{}, func([]byte) bool {
+			n++
+			return true
+		})
+		return nil
+	})
+	return n
+}
+
+// walkRowIDs call fn with every id(nested bucket) of the table bucket in
+// order, until fn return false; the id is only valid in the transaction
+func walkRowIDs(b *bolt.Bucket, opts com.ListOptions, fn func(id []byte) bool) {
+	if b == nil {
+		return
+	}
+	var prefix = []byte(opts.Prefix)
+	var n int
+	c := b.Cursor()
+	k, v := c.Seek(prefix)
+	if opts.After != "" && opts.After >= opts.Prefix {
+		if k, v = c.Seek([]byte(opts.After)); k != nil && string(k) == opts.After {
+			k, v = c.Next()
+		}
+	}
+	for ; k != nil && bytes.HasPrefix(k, prefix); k, v = c.Next() {
+		if v != nil { // 不是行
+			continue
+		}
+		if opts.Limit > 0 && n >= opts.Limit {
+			return
+		}
+		n++
+		if !fn(k) {
+			return
+		}
+	}
+}
@@ -0,0 +1,47 @@
+package boltdb
+
+import (
+	"github.com/lysShub/kvdb/com"
+
+	"github.com/boltdb/bolt"
+)
+
+// BulkLoad write records in one transaction, the buckets are filled up to
+// fillPercent(bolt.DefaultFillPercent if 0); when the rows are sorted and
+// after the existing data, use 1.0 to make the pages full
+func (d *Bolt) BulkLoad(rows []com.BulkRow, fillPercent float64) error {
+	if fillPercent <= 0 {
+		fillPercent = bolt.DefaultFillPercent
+	}
+	for _, r := range rows {
+		if err := d.checkTable(r.Table); err != nil {
+			return err
+		}
+	}
+
+	return d.update(func(tx *bolt.Tx) error {
+		var b *bolt.Bucket
+		var tableName string
+		for _, r := range rows {
+			if b == nil || r.Table != tableName {
+				var err error
+				if b, err = tx.CreateBucketIfNotExists([]byte(r.Table)); err != nil {
+					return err
+				}
+				b.FillPercent, tableName = fillPercent, r.Table
+			}
+
+			sb, err := b.CreateBucketIfNotExists([]byte(r.ID))
+			if err != nil {
+				return err
+			}
+			sb.FillPercent = fillPercent
+			for f, v := range r.Row {
+				if err = sb.Put([]byte(f), v); err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+	})
+}
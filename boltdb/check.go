@@ -0,0 +1,103 @@
+package boltdb
+
+import (
+	"bytes"
+	"encoding/json"
+
+	"github.com/lysShub/kvdb/com"
+
+	"github.com/boltdb/bolt"
+)
+
+// integrity check
+//
+// Check run bolt's consistency check of the pages, then verify the layout:
+// the key/value bucket hold values, a table bucket hold record buckets, a
+// record bucket hold fields and the meta bucket, the trash items have info
+// and data. The readers skip what they don't expect, Check report it.
+
+// Check check the db, the problems are in the report; the error is a failure
+// to run the check
+func (d *Bolt) Check() (com.CheckReport, error) {
+	var r com.CheckReport
+	err := d.view(func(tx *bolt.Tx) error {
+		for err := range tx.Check() {
+			r.Add(com.IssueStorage, nil, err.Error())
+		}
+		return tx.ForEach(func(name []byte, b *bolt.Bucket) error {
+			if bytes.Equal(name, d.Root) {
+				return b.ForEach(func(k, v []byte) error {
+					if v == nil {
+						r.Add(com.IssueKey, issuePath(name, k), "bucket in the key/value bucket")
+					} else {
+						r.Keys++
+					}
+					return nil
+				})
+			} else if bytes.Equal(name, kvdbBucket) {
+				checkTrash(&r, b)
+				return nil
+			}
+			r.Tables++
+			checkTable(&r, name, b)
+			return nil
+		})
+	})
+	return r, err
+}
+
+// issuePath bucket path of an issue
+func issuePath(names ...[]byte) []byte {
+	return bytes.Join(names, []byte("/"))
+}
+
+// checkTable check the records of a table bucket
+func checkTable(r *com.CheckReport, name []byte, b *bolt.Bucket) {
+	b.ForEach(func(id, v []byte) error {
+		if v != nil {
+			r.Add(com.IssueKey, issuePath(name, id), "value in a table bucket")
+			return nil
+		}
+		r.Rows++
+		sb := b.Bucket(id)
+		return sb.ForEach(func(k, v []byte) error {
+			if v != nil {
+				r.Keys++
+			} else if !bytes.Equal(k, metaBucket) {
+				r.Add(com.IssueKey, issuePath(name, id, k), "bucket in a record bucket")
+			} else if len(sb.Bucket(k).Get(metaKey)) != 16 {
+				r.Add(com.IssueMeta, issuePath(name, id, k), "row meta isn't 16 bytes")
+			}
+			return nil
+		})
+	})
+}
+
+// checkTrash check the trash items have info and data
+func checkTrash(r *com.CheckReport, kb *bolt.Bucket) {
+	kb.ForEach(func(k, v []byte) error {
+		if !bytes.Equal(k, trashBucket) || v != nil {
+			r.Add(com.IssueTrash, issuePath(kvdbBucket, k), "unknown key in the reserved bucket")
+		}
+		return nil
+	})
+	tb := kb.Bucket(trashBucket)
+	if tb == nil {
+		return
+	}
+	tb.ForEach(func(id, v []byte) error {
+		p := issuePath(kvdbBucket, trashBucket, id)
+		if v != nil {
+			r.Add(com.IssueTrash, p, "trash item isn't a bucket")
+			return nil
+		}
+		ib := tb.Bucket(id)
+		var info com.TrashItem
+		if json.Unmarshal(ib.Get(infoKey), &info) != nil || info.ID != string(id) {
+			r.Add(com.IssueTrash, p, "malformed trash info")
+		} else if info.Kind == com.TrashKey && ib.Get(dataKey) == nil || info.Kind != com.TrashKey && ib.Bucket(dataKey) == nil {
+			r.Add(com.IssueTrash, p, "trash data is missing")
+		}
+		return nil
+	})
+}
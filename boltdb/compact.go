@@ -0,0 +1,342 @@
+package boltdb
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"hash/fnv"
+	"os"
+	"time"
+
+	"github.com/lysShub/kvdb/com"
+
+	"github.com/boltdb/bolt"
+)
+
+// compaction
+//
+// a bolt file never shrink, the freed pages are only reused. Compact copy
+// all buckets into a new file with FillPercent full pages in a read
+// transaction and verify it, the writes go on meanwhile; then it hold mu.W,
+// every transaction hold mu.R, and rename the file over the db file and
+// reopen it if nothing is written since the copy, otherwise copy again, the
+// last try is under mu.W. It's refused while a read transaction(snapshot,
+// iterator) is open. If the file can't be reopened, the closed handle is
+// kept, everything return bolt.ErrDatabaseNotOpen until OpenDb.
+
+// ErrCompactBusy Compact can't run with open read transactions
+var ErrCompactBusy error = errors.New("boltdb: can't compact with open snapshots or iterators")
+
+var errVerify error = errors.New("boltdb: the compacted file isn't same as the db")
+
+// compactTxSize bytes copied per transaction
+const compactTxSize = 64 << 20
+
+// compactTries copies of Compact, the last one is under mu.W
+const compactTries = 3
+
+// defaultFillPercent default of FillPercent
+const defaultFillPercent = 0.9
+
+// update run fn in a write transaction
+func (d *Bolt) update(fn func(tx *bolt.Tx) error) error {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+	return d.DbHandle.Update(fn)
+}
+
+// view run fn in a read transaction, the pinned one of a snapshot
+func (d *Bolt) view(fn func(tx *bolt.Tx) error) error {
+	if d.snap == nil {
+		d.mu.RLock()
+		defer d.mu.RUnlock()
+		return d.DbHandle.View(fn)
+	}
+	d.snapMu.Lock()
+	defer d.snapMu.Unlock()
+	return fn(d.snap)
+}
+
+func (d *Bolt) openFile(path string) (*bolt.DB, error) {
+	return bolt.Open(path, 0600, &bolt.Options{Timeout: 1 * time.Second, InitialMmapSize: d.MmapSize})
+}
+
+// Compact copy the db to the new file dst(default Path + ".compact", must
+// be in the same file system), then replace the db file with it
+func (d *Bolt) Compact(dst string) (com.CompactStats, error) {
+	var stats com.CompactStats
+	if dst == "" {
+		dst = d.Path + ".compact"
+	}
+	if _, err := os.Stat(dst); err == nil {
+		return stats, os.ErrExist
+	}
+	if d.DbHandle.Stats().OpenTxN > 0 {
+		return stats, ErrCompactBusy
+	}
+	stats.SizeBefore = fileSize(d.Path)
+
+	for i := 1; ; i++ {
+		if i == compactTries { // 写入频繁，复制时不允许写入
+			d.mu.Lock()
+			_, keys, err := d.copyTo(dst)
+			if err != nil {
+				d.mu.Unlock()
+				return stats, err
+			}
+			stats.Keys = keys
+			break
+		}
+
+		d.mu.RLock()
+		txid, keys, err := d.copyTo(dst)
+		d.mu.RUnlock()
+		if err != nil {
+			return stats, err
+		}
+		d.mu.Lock()
+		if id, err := d.txID(); err != nil {
+			d.mu.Unlock()
+			os.Remove(dst)
+			return stats, err
+		} else if id == txid {
+			stats.Keys = keys
+			break
+		}
+		d.mu.Unlock()
+		os.Remove(dst) // 复制后有写入
+	}
+	defer d.mu.Unlock()
+
+	if d.DbHandle.Stats().OpenTxN > 0 {
+		os.Remove(dst)
+		return stats, ErrCompactBusy
+	}
+	if err := d.replace(dst); err != nil {
+		return stats, err
+	}
+	stats.SizeAfter = fileSize(d.Path)
+	if d.Progress != nil {
+		d.Progress("compact", "", stats.Keys)
+	}
+	return stats, nil
+}
+
+// copyTo copy the db to the new file dst in a read transaction and verify
+// it, return the id of the transaction and the count of key/values; dst is
+// removed if it fails. The caller hold mu
+func (d *Bolt) copyTo(dst string) (txid, keys int, err error) {
+	// 不设InitialMmapSize，否则文件按AllocSize增长
+	ndb, err := bolt.Open(dst, 0600, &bolt.Options{Timeout: 1 * time.Second})
+	if err != nil {
+		return 0, 0, err
+	}
+	c := &copier{db: ndb, fill: d.FillPercent}
+	if c.fill <= 0 || c.fill > 1 {
+		c.fill = defaultFillPercent
+	}
+	err = d.DbHandle.View(func(tx *bolt.Tx) error {
+		txid = tx.ID()
+		err := tx.ForEach(func(name []byte, b *bolt.Bucket) error {
+			return c.walk([][]byte{name}, b)
+		})
+		if err == nil {
+			err = c.commit()
+		} else if c.tx != nil {
+			c.tx.Rollback()
+		}
+		if err == nil {
+			err = verify(tx, ndb)
+		}
+		return err
+	})
+	if cerr := ndb.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		os.Remove(dst)
+		return 0, 0, err
+	}
+	return txid, c.keys, nil
+}
+
+// txID id of the last committed transaction
+func (d *Bolt) txID() (int, error) {
+	tx, err := d.DbHandle.Begin(false)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+	return tx.ID(), nil
+}
+
+// replace close the db, rename the file src over it and reopen it; src is
+// removed if it fails. If it can't be reopened, the closed handle is kept.
+// The caller hold mu.W
+func (d *Bolt) replace(src string) error {
+	if err := d.DbHandle.Close(); err != nil {
+		os.Remove(src)
+		return err
+	}
+	rerr := os.Rename(src, d.Path)
+	db, err := d.openFile(d.Path)
+	if err != nil {
+		return err // 关闭的句柄返回bolt.ErrDatabaseNotOpen
+	}
+	d.DbHandle = db
+	if rerr != nil {
+		os.Remove(src)
+		return rerr
+	}
+	return nil
+}
+
+func fileSize(path string) int64 {
+	fi, err := os.Stat(path)
+	if err != nil {
+		return 0
+	}
+	return fi.Size()
+}
+
+// copier write the key/values to a db in order, commit every compactTxSize bytes
+type copier struct {
+	db   *bolt.DB
+	fill float64
+	keys int
+
+	tx   *bolt.Tx
+	size int
+	path [][]byte // path of b
+	b    *bolt.Bucket
+}
+
+// walk copy the bucket b at path and its nested buckets
+func (c *copier) walk(path [][]byte, b *bolt.Bucket) error {
+	nb, err := c.bucket(path)
+	if err != nil {
+		return err
+	} else if err = nb.SetSequence(b.Sequence()); err != nil {
+		return err
+	}
+	return b.ForEach(func(k, v []byte) error {
+		if v == nil {
+			sub := append(append(make([][]byte, 0, len(path)+1), path...), k)
+			return c.walk(sub, b.Bucket(k))
+		}
+		if c.size > compactTxSize {
+			if err := c.commit(); err != nil {
+				return err
+			}
+		}
+		nb, err := c.bucket(path)
+		if err != nil {
+			return err
+		}
+		c.size, c.keys = c.size+len(k)+len(v), c.keys+1
+		return nb.Put(k, v)
+	})
+}
+
+// bucket the bucket at path in the current transaction, created if not exist
+func (c *copier) bucket(path [][]byte) (*bolt.Bucket, error) {
+	if c.tx != nil && c.b != nil && samePath(c.path, path) {
+		return c.b, nil
+	}
+	if c.tx == nil {
+		tx, err := c.db.Begin(true)
+		if err != nil {
+			return nil, err
+		}
+		c.tx = tx
+	}
+
+	b, err := c.tx.CreateBucketIfNotExists(path[0])
+	for _, name := range path[1:] {
+		if err != nil {
+			return nil, err
+		}
+		b.FillPercent = c.fill
+		b, err = b.CreateBucketIfNotExists(name)
+	}
+	if err != nil {
+		return nil, err
+	}
+	b.FillPercent = c.fill
+	c.path, c.b = path, b
+	return b, nil
+}
+
+func (c *copier) commit() error {
+	if c.tx == nil {
+		return nil
+	}
+	err := c.tx.Commit()
+	c.tx, c.b, c.size = nil, nil, 0
+	return err
+}
+
+func samePath(a, b [][]byte) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if !bytes.Equal(a[i], b[i]) {
+			return false
+		}
+	}
+	return true
+}
+
+// verify the db read by tx and the db b have the same buckets, sequences
+// and key/values
+func verify(tx *bolt.Tx, b *bolt.DB) error {
+	ha, err := digest(tx)
+	if err != nil {
+		return err
+	}
+	var hb []byte
+	if err = b.View(func(tx *bolt.Tx) error {
+		hb, err = digest(tx)
+		return err
+	}); err != nil {
+		return err
+	} else if !bytes.Equal(ha, hb) {
+		return errVerify
+	}
+	return nil
+}
+
+// digest hash of all buckets, sequences and key/values read by tx
+func digest(tx *bolt.Tx) ([]byte, error) {
+	h := fnv.New128a()
+	var buf [binary.MaxVarintLen64]byte
+	write := func(tag byte, p []byte) {
+		h.Write([]byte{tag})
+		h.Write(buf[:binary.PutUvarint(buf[:], uint64(len(p)))])
+		h.Write(p)
+	}
+
+	var walk func(b *bolt.Bucket) error
+	walk = func(b *bolt.Bucket) error {
+		h.Write(buf[:binary.PutUvarint(buf[:], b.Sequence())])
+		return b.ForEach(func(k, v []byte) error {
+			if v != nil {
+				write('k', k)
+				write('v', v)
+				return nil
+			}
+			write('b', k)
+			err := walk(b.Bucket(k))
+			write('e', nil)
+			return err
+		})
+	}
+	err := tx.ForEach(func(name []byte, b *bolt.Bucket) error {
+		write('b', name)
+		err := walk(b)
+		write('e', nil)
+		return err
+	})
+	return h.Sum(nil), err
+}
@@ -0,0 +1,76 @@
+package boltdb
+
+import (
+	"bytes"
+
+	"github.com/lysShub/kvdb/com"
+
+	"github.com/boltdb/bolt"
+)
+
+// conditional writes, the test and the write are in one transaction
+
+// CompareAndSwapKey set the key to new if its value is old, a nil old means
+// the key mustn't exist; otherwise return com.ErrConditionFailed
+func (d *Bolt) CompareAndSwapKey(key string, old, new []byte) error {
+	return d.update(func(tx *bolt.Tx) error {
+		b, err := tx.CreateBucketIfNotExists(d.Root)
+		if err != nil {
+			return err
+		}
+		v := b.Get([]byte(key))
+		if (old == nil) != (v == nil) || (v != nil && !bytes.Equal(v, old)) {
+			return com.ErrConditionFailed
+		}
+		return b.Put([]byte(key), new)
+	})
+}
+
+// SetTableRowIf set the fields of a record if the record match cond,
+// otherwise return com.ErrConditionFailed
+func (d *Bolt) SetTableRowIf(tableName, id string, cond com.Condition, fv map[string][]byte) error {
+	_, err := d.setRowIf(tableName, id, func(row map[string][]byte, m com.RowMeta) error {
+		if !cond.Match(row, m.Version) {
+			return com.ErrConditionFailed
+		}
+		return nil
+	}, fv)
+	return err
+}
+
+// setRowIf set the fields of a record if test return nil, return the new version
+func (d *Bolt) setRowIf(tableName, id string, test func(row map[string][]byte, m com.RowMeta) error, fv map[string][]byte) (uint64, error) {
+	if err := d.checkTable(tableName); err != nil {
+		return 0, err
+	}
+	var version uint64
+	err := d.update(func(tx *bolt.Tx) error {
+		var r map[string][]byte
+		var m com.RowMeta
+		if b := tx.Bucket([]byte(tableName)); b != nil {
+			if sb := b.Bucket([]byte(id)); sb != nil {
+				r, m = readRow(sb), readMeta(sb)
+			}
+		}
+		if err := test(r, m); err != nil {
+			return err
+		}
+
+		b, err := tx.CreateBucketIfNotExists([]byte(tableName))
+		if err != nil {
+			return err
+		}
+		sb, err := b.CreateBucketIfNotExists([]byte(id))
+		if err != nil {
+			return err
+		}
+		for f, v := range fv {
+			if err = sb.Put([]byte(f), v); err != nil {
+				return err
+			}
+		}
+		version, err = touchRow(b, sb)
+		return err
+	})
+	return version, err
+}
@@ -0,0 +1,102 @@
+package boltdb
+
+import (
+	"github.com/lysShub/kvdb/com"
+
+	"github.com/boltdb/bolt"
+)
+
+// counters, an increment is a read-modify-write in one transaction
+
+// incr read-modify-write the value of a key in the bucket by fn, then call
+// touch if it isn't nil
+func (d *Bolt) incr(bucket func(tx *bolt.Tx) (*bolt.Bucket, error), key string, fn func(v []byte) ([]byte, error), touch func(tx *bolt.Tx) error) error {
+	return d.update(func(tx *bolt.Tx) error {
+		b, err := bucket(tx)
+		if err != nil {
+			return err
+		}
+		v, err := fn(b.Get([]byte(key)))
+		if err != nil {
+			return err
+		}
+		if err = b.Put([]byte(key), v); err != nil || touch == nil {
+			return err
+		}
+		return touch(tx)
+	})
+}
+
+// touchCell touch the record of a counter field
+func touchCell(tableName, id string) func(tx *bolt.Tx) error {
+	return func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte(tableName))
+		_, err := touchRow(b, b.Bucket([]byte(id)))
+		return err
+	}
+}
+
+func (d *Bolt) rootBucket(tx *bolt.Tx) (*bolt.Bucket, error) {
+	return tx.CreateBucketIfNotExists(d.Root)
+}
+
+// rowBucket the record's bucket, created if not exist
+func rowBucket(tableName, id string) func(tx *bolt.Tx) (*bolt.Bucket, error) {
+	return func(tx *bolt.Tx) (*bolt.Bucket, error) {
+		b, err := tx.CreateBucketIfNotExists([]byte(tableName))
+		if err != nil {
+			return nil, err
+		}
+		return b.CreateBucketIfNotExists([]byte(id))
+	}
+}
+
+func incrInt64(r *int64, delta int64) func(v []byte) ([]byte, error) {
+	return func(v []byte) ([]byte, error) {
+		n, err := com.DecodeInt64(v)
+		*r = n + delta
+		return com.EncodeInt64(*r), err
+	}
+}
+
+func incrFloat64(r *float64, delta float64) func(v []byte) ([]byte, error) {
+	return func(v []byte) ([]byte, error) {
+		f, err := com.DecodeFloat64(v)
+		*r = f + delta
+		return com.EncodeFloat64(*r), err
+	}
+}
+
+// IncrKey add delta to a int64 counter, return the new value
+func (d *Bolt) IncrKey(key string, delta int64) (int64, error) {
+	var r int64
+	err := d.incr(d.rootBucket, key, incrInt64(&r, delta), nil)
+	return r, err
+}
+
+// IncrKeyFloat add delta to a float64 counter, return the new value
+func (d *Bolt) IncrKeyFloat(key string, delta float64) (float64, error) {
+	var r float64
+	err := d.incr(d.rootBucket, key, incrFloat64(&r, delta), nil)
+	return r, err
+}
+
+// IncrTableValue add delta to a int64 counter field, return the new value
+func (d *Bolt) IncrTableValue(tableName, id, field string, delta int64) (int64, error) {
+	if err := d.checkTable(tableName); err != nil {
+		return 0, err
+	}
+	var r int64
+	err := d.incr(rowBucket(tableName, id), field, incrInt64(&r, delta), touchCell(tableName, id))
+	return r, err
+}
+
+// IncrTableValueFloat add delta to a float64 counter field, return the new value
+func (d *Bolt) IncrTableValueFloat(tableName, id, field string, delta float64) (float64, error) {
+	if err := d.checkTable(tableName); err != nil {
+		return 0, err
+	}
+	var r float64
+	err := d.incr(rowBucket(tableName, id), field, incrFloat64(&r, delta), touchCell(tableName, id))
+	return r, err
+}
@@ -0,0 +1,214 @@
+package boltdb
+
+import (
+	"sync"
+
+	"github.com/lysShub/kvdb/com"
+
+	"github.com/boltdb/bolt"
+)
+
+// IterateTable call fn with every record in a table in order, stop when fn return error
+func (d *Bolt) IterateTable(tableName string, fn func(id string, row map[string][]byte) error) error {
+	if d.checkTable(tableName) != nil {
+		return nil
+	}
+	return d.view(func(tx *bolt.Tx) error {
+		return iterateTable(tx.Bucket([]byte(tableName)), fn)
+	})
+}
+
+func iterateTable(b *bolt.Bucket, fn func(id string, row map[string][]byte) error) error {
+	var err error
+	walkRowIDs(b, com.ListOptions{}, func(id []byte) bool {
+		err = fn(string(id), readRow(b.Bucket(id)))
+		return err == nil
+	})
+	return err
+}
+
+// RowIterator pull-style iterator of the records in a table over the
+// table bucket's cursor. It holds a read transaction until Close, the
+// records are the snapshot when it's created; close it soon, a long read
+// transaction stop bolt from growing its file
+type RowIterator struct {
+	mu   *sync.Mutex // lock of a snapshot's transaction, nil if tx is owned
+	tx   *bolt.Tx
+	b    *bolt.Bucket
+	c    *bolt.Cursor
+	opts com.IterOptions
+	k, v []byte // cursor position, not returned yet
+
+	id  string
+	row map[string][]byte
+	err error
+}
+
+// NewRowIterator
+func (d *Bolt) NewRowIterator(tableName string, opts com.IterOptions) *RowIterator {
+	r := &RowIterator{opts: opts}
+	if d.checkTable(tableName) != nil {
+		return r
+	}
+	if d.snap != nil {
+		r.mu, r.tx = d.snapMu, d.snap
+	} else {
+		d.mu.RLock()
+		r.tx, r.err = d.DbHandle.Begin(false)
+		d.mu.RUnlock()
+		if r.err != nil {
+			return r
+		}
+	}
+	r.lock()
+	defer r.unlock()
+	if r.b = r.tx.Bucket([]byte(tableName)); r.b == nil {
+		return r
+	}
+	r.c = r.b.Cursor()
+	if opts.Reverse && opts.End == "" {
+		r.k, r.v = r.c.Last()
+	} else if opts.Reverse {
+		r.seek(opts.End)
+	} else {
+		r.seek(opts.Start)
+	}
+	return r
+}
+
+func (r *RowIterator) lock() {
+	if r.mu != nil {
+		r.mu.Lock()
+	}
+}
+
+func (r *RowIterator) unlock() {
+	if r.mu != nil {
+		r.mu.Unlock()
+	}
+}
+
+// Seek move to the first record whose id >= id, or <= id in reverse mode;
+// the bounds are kept
+func (r *RowIterator) Seek(id string) {
+	r.lock()
+	defer r.unlock()
+	r.seek(id)
+}
+
+func (r *RowIterator) seek(id string) {
+	if r.c == nil {
+		return
+	}
+	if !r.opts.Reverse {
+		if id < r.opts.Start {
+			id = r.opts.Start
+		}
+		r.k, r.v = r.c.Seek([]byte(id))
+		return
+	}
+
+	exclude := r.opts.End != "" && id >= r.opts.End
+	if exclude {
+		id = r.opts.End
+	}
+	if r.k, r.v = r.c.Seek([]byte(id)); r.k == nil {
+		r.k, r.v = r.c.Last()
+	} else if exclude || string(r.k) != id {
+		r.k, r.v = r.c.Prev()
+	}
+}
+
+// Next move to the next record, return false at the end or on error
+func (r *RowIterator) Next() bool {
+	if r.c == nil || r.err != nil {
+		return false
+	}
+	r.lock()
+	defer r.unlock()
+	for r.k != nil && r.v != nil { // 不是行
+		r.step()
+	}
+	if r.k == nil {
+		return false
+	}
+	id := string(r.k)
+	if (!r.opts.Reverse && r.opts.End != "" && id >= r.opts.End) || (r.opts.Reverse && id < r.opts.Start) {
+		r.k = nil
+		return false
+	}
+	r.id, r.row = id, readRow(r.b.Bucket(r.k))
+	r.step()
+	return true
+}
+
+func (r *RowIterator) step() {
+	r.k, r.v = step(r.c, r.opts.Reverse)
+}
+
+// ID id of the current record
+func (r *RowIterator) ID() string {
+	return r.id
+}
+
+// Row fields of the current record
+func (r *RowIterator) Row() map[string][]byte {
+	return r.row
+}
+
+// Err the error that stopped the iteration
+func (r *RowIterator) Err() error {
+	return r.err
+}
+
+// Close release the iterator and its transaction
+func (r *RowIterator) Close() {
+	if r.tx != nil {
+		if r.mu == nil {
+			r.tx.Rollback()
+		}
+		r.tx, r.b, r.c, r.k, r.row = nil, nil, nil, nil, nil
+	}
+}
+
+// IterateKeys call fn with every key/value between opts.Start and opts.End in
+// order, stop when fn return error
+func (d *Bolt) IterateKeys(opts com.IterOptions, fn func(key string, value []byte) error) error {
+	return d.view(func(tx *bolt.Tx) error {
+		b := tx.Bucket(d.Root)
+		if b == nil {
+			return nil
+		}
+		c := b.Cursor()
+		var k, v []byte
+		if !opts.Reverse {
+			k, v = c.Seek([]byte(opts.Start))
+		} else if opts.End == "" {
+			k, v = c.Last()
+		} else if k, v = c.Seek([]byte(opts.End)); k == nil {
+			k, v = c.Last()
+		} else {
+			k, v = c.Prev()
+		}
+		for ; k != nil; k, v = step(c, opts.Reverse) {
+			if v == nil {
+				continue
+			}
+			key := string(k)
+			if (!opts.Reverse && opts.End != "" && key >= opts.End) || (opts.Reverse && key < opts.Start) {
+				return nil
+			}
+			if err := fn(key, copyBytes(v)); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+func step(c *bolt.Cursor, reverse bool) ([]byte, []byte) {
+	if reverse {
+		return c.Prev()
+	}
+	return c.Next()
+}
@@ -0,0 +1,96 @@
+package boltdb
+
+import (
+	"time"
+
+	"github.com/lysShub/kvdb/com"
+
+	"github.com/boltdb/bolt"
+)
+
+// row meta
+//
+// every written record has a nested meta bucket in its bucket holding its
+// version and update time, so rename, copy and delete carry it with the
+// record, and the field readers skip it as a nested bucket. The version is
+// taken from the sequence of the reserved bucket "\x00kvdb", which is db
+// wide, so it never go back even if the record or its table is deleted and
+// created again. The tables written before keep their own sequence, the db
+// wide one start after it.
+
+// metaBucket name of the meta bucket, it can't be used as a field name
+var metaBucket = []byte("\x00kvdb")
+
+var metaKey = []byte("meta")
+
+// touchRow write a new meta of a written record, b is the table bucket and
+// sb the record bucket; return the new version
+func touchRow(b, sb *bolt.Bucket) (uint64, error) {
+	kb, err := b.Tx().CreateBucketIfNotExists(kvdbBucket)
+	if err != nil {
+		return 0, err
+	}
+	if s := b.Sequence(); kb.Sequence() < s { // 旧版本的表序列
+		if err = kb.SetSequence(s); err != nil {
+			return 0, err
+		}
+	}
+	v, err := kb.NextSequence()
+	if err != nil {
+		return 0, err
+	}
+	mb, err := sb.CreateBucketIfNotExists(metaBucket)
+	if err != nil {
+		return 0, err
+	}
+	return v, mb.Put(metaKey, com.EncodeRowMeta(com.RowMeta{Version: v, UpdatedAt: time.Now()}))
+}
+
+// readMeta meta of a record, zero if it's not versioned
+func readMeta(sb *bolt.Bucket) com.RowMeta {
+	if mb := sb.Bucket(metaBucket); mb != nil {
+		return com.DecodeRowMeta(mb.Get(metaKey))
+	}
+	return com.RowMeta{}
+}
+
+// hasFields the record bucket has any field
+func hasFields(sb *bolt.Bucket) bool {
+	c := sb.Cursor()
+	for k, v := c.First(); k != nil; k, v = c.Next() {
+		if v != nil {
+			return true
+		}
+	}
+	return false
+}
+
+// ReadTableRowMeta read a record and its meta
+func (d *Bolt) ReadTableRowMeta(tableName, id string) (map[string][]byte, com.RowMeta) {
+	if d.checkTable(tableName) != nil {
+		return nil, com.RowMeta{}
+	}
+	var r map[string][]byte
+	var m com.RowMeta
+	_ = d.view(func(tx *bolt.Tx) error {
+		if b := tx.Bucket([]byte(tableName)); b != nil {
+			if sb := b.Bucket([]byte(id)); sb != nil {
+				r, m = readRow(sb), readMeta(sb)
+			}
+		}
+		return nil
+	})
+	return r, m
+}
+
+// UpdateRow set the fields of a record if its version is version(0 is a
+// new or not versioned record), otherwise return com.ErrVersionConflict;
+// return the new version
+func (d *Bolt) UpdateRow(tableName, id string, version uint64, fv map[string][]byte) (uint64, error) {
+	return d.setRowIf(tableName, id, func(row map[string][]byte, m com.RowMeta) error {
+		if m.Version != version {
+			return com.ErrVersionConflict
+		}
+		return nil
+	}, fv)
+}
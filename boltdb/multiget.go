@@ -0,0 +1,53 @@
+package boltdb
+
+import (
+	"github.com/lysShub/kvdb/com"
+
+	"github.com/boltdb/bolt"
+)
+
+// multi-get, read in one transaction in sorted order of the keys, the
+// results are in order of the input
+
+// ReadKeys read values, found report the key is exist
+func (d *Bolt) ReadKeys(keys []string) (values [][]byte, found []bool) {
+	values, found = make([][]byte, len(keys)), make([]bool, len(keys))
+	_ = d.view(func(tx *bolt.Tx) error {
+		b := tx.Bucket(d.Root)
+		if b == nil {
+			return nil
+		}
+		c := b.Cursor()
+		for _, i := range com.SortedOrder(keys) {
+			if k, v := c.Seek([]byte(keys[i])); k != nil && v != nil && string(k) == keys[i] {
+				values[i], found[i] = copyBytes(v), true
+			}
+		}
+		return nil
+	})
+	return values, found
+}
+
+// ReadTableRows read records, a missing record is nil
+func (d *Bolt) ReadTableRows(tableName string, ids []string) []map[string][]byte {
+	var rows = make([]map[string][]byte, len(ids))
+	if d.checkTable(tableName) != nil {
+		return rows
+	}
+	_ = d.view(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte(tableName))
+		if b == nil {
+			return nil
+		}
+		c := b.Cursor()
+		for _, i := range com.SortedOrder(ids) {
+			if k, v := c.Seek([]byte(ids[i])); k != nil && v == nil && string(k) == ids[i] {
+				if r := readRow(b.Bucket(k)); len(r) != 0 {
+					rows[i] = r
+				}
+			}
+		}
+		return nil
+	})
+	return rows
+}
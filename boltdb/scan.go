@@ -0,0 +1,109 @@
+package boltdb
+
+import (
+	"sync"
+
+	"github.com/lysShub/kvdb/com"
+
+	"github.com/boltdb/bolt"
+)
+
+// ScanBatch rows per batch of ParallelScan
+const ScanBatch = 256
+
+// ParallelScan read a table with workers goroutines, the ids are split to
+// workers ranges of about the same count of records, every range is read by
+// its own read transaction. fn is called concurrently with a worker's
+// batch(ScanBatch rows at most) in order of the range; stop when fn return error.
+//
+// the ranges are read by different transactions, so the scan isn't a
+// snapshot of the whole table while it's written
+func (d *Bolt) ParallelScan(tableName string, workers int, fn func(worker int, ids []string, rows []map[string][]byte) error) error {
+	if d.checkTable(tableName) != nil {
+		return nil
+	}
+	if workers <= 0 {
+		workers = 1
+	}
+
+	// 按行数分区
+	var bounds []string
+	err := d.view(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte(tableName))
+		var n, i int
+		walkRowIDs(b, com.ListOptions{}, func(id []byte) bool {
+			n++
+			return true
+		})
+		per := (n + workers - 1) / workers
+		walkRowIDs(b, com.ListOptions{}, func(id []byte) bool {
+			if i > 0 && i%per == 0 {
+				bounds = append(bounds, string(id))
+			}
+			i++
+			return true
+		})
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	var wg sync.WaitGroup
+	var errs = make([]error, len(bounds)+1)
+	var stop = make(chan struct{})
+	var once sync.Once
+	for i := 0; i <= len(bounds); i++ {
+		var opts com.IterOptions
+		if i > 0 {
+			opts.Start = bounds[i-1]
+		}
+		if i < len(bounds) {
+			opts.End = bounds[i]
+		}
+		wg.Add(1)
+		go func(worker int) {
+			defer wg.Done()
+			if errs[worker] = d.scanRange(tableName, worker, opts, stop, fn); errs[worker] != nil {
+				once.Do(func() { close(stop) })
+			}
+		}(i)
+	}
+	wg.Wait()
+	for _, err := range errs {
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (d *Bolt) scanRange(tableName string, worker int, opts com.IterOptions, stop chan struct{}, fn func(worker int, ids []string, rows []map[string][]byte) error) error {
+	it := d.NewRowIterator(tableName, opts)
+	defer it.Close()
+
+	var ids []string
+	var rows []map[string][]byte
+	for it.Next() {
+		ids, rows = append(ids, it.ID()), append(rows, it.Row())
+		if len(ids) < ScanBatch {
+			continue
+		}
+		select {
+		case <-stop:
+			return nil
+		default:
+		}
+		if err := fn(worker, ids, rows); err != nil {
+			return err
+		}
+		ids, rows = nil, nil
+	}
+	if err := it.Err(); err != nil {
+		return err
+	}
+	if len(ids) != 0 {
+		return fn(worker, ids, rows)
+	}
+	return nil
+}
@@ -0,0 +1,42 @@
+package boltdb
+
+import "sync"
+
+// snapshot
+//
+// a snapshot is a Bolt sharing the db handle, whose read methods use one
+// read transaction. Only the read methods can be called on it(not
+// ParallelScan, it reads in a transaction per worker); the calls are
+// serialized, a bolt transaction isn't safe for concurrent use. The pages
+// it can see aren't reused until Release, and a write that grow the db file
+// over MmapSize wait for it, release it soon
+
+// Snapshot a read-only view of the db at now, Release it when done
+func (d *Bolt) Snapshot() (*Bolt, error) {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+	tx, err := d.DbHandle.Begin(false)
+	if err != nil {
+		return nil, err
+	}
+	return &Bolt{
+		DbHandle:  d.DbHandle,
+		Path:      d.Path,
+		Root:      d.Root,
+		ChunkRows: d.ChunkRows,
+		MmapSize:  d.MmapSize,
+		mu:        d.mu,
+		snap:      tx,
+		snapMu:    &sync.Mutex{},
+	}, nil
+}
+
+// Release rollback the transaction of a snapshot
+func (d *Bolt) Release() error {
+	if d.snap == nil {
+		return nil
+	}
+	d.snapMu.Lock()
+	defer d.snapMu.Unlock()
+	return d.snap.Rollback()
+}
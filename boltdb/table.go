@@ -0,0 +1,124 @@
+package boltdb
+
+import (
+	"github.com/lysShub/kvdb/com"
+
+	"github.com/boltdb/bolt"
+)
+
+// table management, rename and copy are done in one transaction
+
+// copyBucket copy all values and nested buckets of src to dst, return the
+// count of copied values
+func copyBucket(dst, src *bolt.Bucket) (int, error) {
+	var n int
+	if err := dst.SetSequence(src.Sequence()); err != nil {
+		return 0, err
+	}
+	err := src.ForEach(func(k, v []byte) error {
+		if v != nil {
+			n++
+			return dst.Put(k, v)
+		}
+		sb, err := dst.CreateBucket(k)
+		if err != nil {
+			return err
+		}
+		m, err := copyBucket(sb, src.Bucket(k))
+		n = n + m
+		return err
+	})
+	return n, err
+}
+
+// RenameTable
+func (d *Bolt) RenameTable(oldName, newName string) error {
+	if err := d.checkTable(oldName); err != nil {
+		return err
+	} else if err := d.checkTable(newName); err != nil {
+		return err
+	}
+
+	var n int
+	err := d.update(func(tx *bolt.Tx) error {
+		src := tx.Bucket([]byte(oldName))
+		if src == nil {
+			return com.ErrTableNotExist
+		} else if tx.Bucket([]byte(newName)) != nil {
+			return com.ErrTableExist
+		}
+		dst, err := tx.CreateBucket([]byte(newName))
+		if err != nil {
+			return err
+		}
+		if n, err = copyBucket(dst, src); err != nil {
+			return err
+		}
+		return tx.DeleteBucket([]byte(oldName))
+	})
+	if err == nil && d.Progress != nil {
+		d.Progress("rename", oldName, n)
+	}
+	return err
+}
+
+// CopyTable
+func (d *Bolt) CopyTable(src, dst string) error {
+	if err := d.checkTable(src); err != nil {
+		return err
+	} else if err := d.checkTable(dst); err != nil {
+		return err
+	}
+
+	var n int
+	err := d.update(func(tx *bolt.Tx) error {
+		sb := tx.Bucket([]byte(src))
+		if sb == nil {
+			return com.ErrTableNotExist
+		} else if tx.Bucket([]byte(dst)) != nil {
+			return com.ErrTableExist
+		}
+		db, err := tx.CreateBucket([]byte(dst))
+		if err != nil {
+			return err
+		}
+		n, err = copyBucket(db, sb)
+		return err
+	})
+	if err == nil && d.Progress != nil {
+		d.Progress("copy", src, n)
+	}
+	return err
+}
+
+// TruncateTable delete all records in a table, the table bucket and its
+// sequence are kept; in chunks same as DeleteTable
+func (d *Bolt) TruncateTable(tableName string) error {
+	if err := d.checkTable(tableName); err != nil {
+		return err
+	}
+	return d.deleteRows("truncate", tableName, false)
+}
+
+// CreateTable create a empty table if it isn't exist
+func (d *Bolt) CreateTable(tableName string) error {
+	if err := d.checkTable(tableName); err != nil {
+		return err
+	}
+	return d.update(func(tx *bolt.Tx) error {
+		_, err := tx.CreateBucketIfNotExists([]byte(tableName))
+		return err
+	})
+}
+
+// readRow read the fields of a record bucket
+func readRow(sb *bolt.Bucket) map[string][]byte {
+	var r map[string][]byte = make(map[string][]byte)
+	c := sb.Cursor()
+	for k, v := c.First(); k != nil; k, v = c.Next() {
+		if v != nil {
+			r[string(k)] = copyBytes(v)
+		}
+	}
+	return r
+}
@@ -0,0 +1,220 @@
+package boltdb
+
+import (
+	"bytes"
+	"encoding/json"
+	"time"
+
+	"github.com/lysShub/kvdb/com"
+
+	"github.com/boltdb/bolt"
+)
+
+// trash
+//
+// a soft deleted key, record or table is moved to a bucket of the trash,
+// which is a nested bucket of the reserved top level bucket "\x00kvdb":
+//   \x00kvdb / trash / trash id / info   the item info
+//                               / data   the value of a key, the record bucket or the table bucket
+// it's moved in one transaction, as RenameTable
+
+// kvdbBucket the reserved top level bucket of kvdb's own data, it can't be a table
+var kvdbBucket = metaBucket
+
+var (
+	trashBucket = []byte("trash")
+	infoKey     = []byte("info")
+	dataKey     = []byte("data")
+)
+
+// trashRoot the trash bucket, created if create
+func trashRoot(tx *bolt.Tx, create bool) (*bolt.Bucket, error) {
+	if !create {
+		if kb := tx.Bucket(kvdbBucket); kb != nil {
+			return kb.Bucket(trashBucket), nil
+		}
+		return nil, nil
+	}
+	kb, err := tx.CreateBucketIfNotExists(kvdbBucket)
+	if err != nil {
+		return nil, err
+	}
+	return kb.CreateBucketIfNotExists(trashBucket)
+}
+
+// Trash move the key, record or table of item to the trash; a missing key
+// or record isn't trashed, a missing table is com.ErrTableNotExist
+func (d *Bolt) Trash(item com.TrashItem) error {
+	if err := d.checkTable(item.Table); item.Kind != com.TrashKey && err != nil {
+		return err
+	}
+	info, err := json.Marshal(item)
+	if err != nil {
+		return err
+	}
+
+	return d.update(func(tx *bolt.Tx) error {
+		var v []byte
+		var src, parent *bolt.Bucket // 被删除的桶和它的父桶
+		var name []byte
+		switch item.Kind {
+		case com.TrashKey:
+			if parent = tx.Bucket(d.Root); parent == nil {
+				return nil
+			}
+			if name = []byte(item.Key); parent.Get(name) == nil {
+				return nil
+			}
+			v = parent.Get(name)
+		case com.TrashRow:
+			if parent = tx.Bucket([]byte(item.Table)); parent == nil {
+				return nil
+			}
+			if name = []byte(item.RowID); parent.Bucket(name) == nil {
+				return nil
+			}
+			src = parent.Bucket(name)
+		default:
+			if src = tx.Bucket([]byte(item.Table)); src == nil {
+				return com.ErrTableNotExist
+			}
+		}
+
+		tb, err := trashRoot(tx, true)
+		if err != nil {
+			return err
+		}
+		ib, err := tb.CreateBucket([]byte(item.ID))
+		if err != nil {
+			return err
+		}
+		if err = ib.Put(infoKey, info); err != nil {
+			return err
+		}
+		if src == nil {
+			if err = ib.Put(dataKey, v); err != nil {
+				return err
+			}
+			return parent.Delete(name)
+		}
+		db, err := ib.CreateBucket(dataKey)
+		if err != nil {
+			return err
+		} else if _, err = copyBucket(db, src); err != nil {
+			return err
+		}
+		if parent == nil {
+			return tx.DeleteBucket([]byte(item.Table))
+		}
+		return parent.DeleteBucket(name)
+	})
+}
+
+// ListTrash the trash items in order of id
+func (d *Bolt) ListTrash() ([]com.TrashItem, error) {
+	var r = []com.TrashItem{}
+	err := d.view(func(tx *bolt.Tx) error {
+		tb, _ := trashRoot(tx, false)
+		if tb == nil {
+			return nil
+		}
+		return tb.ForEach(func(id, _ []byte) error {
+			var item com.TrashItem
+			if err := json.Unmarshal(tb.Bucket(id).Get(infoKey), &item); err != nil {
+				return err
+			}
+			r = append(r, item)
+			return nil
+		})
+	})
+	return r, err
+}
+
+// Restore move the trash item back, it's com.ErrRestoreExist if the key,
+// record or table is written again; return the restored item
+func (d *Bolt) Restore(trashID string) (com.TrashItem, error) {
+	var item com.TrashItem
+	err := d.update(func(tx *bolt.Tx) error {
+		tb, _ := trashRoot(tx, false)
+		if tb == nil || tb.Bucket([]byte(trashID)) == nil {
+			return com.ErrTrashNotExist
+		}
+		ib := tb.Bucket([]byte(trashID))
+		if err := json.Unmarshal(ib.Get(infoKey), &item); err != nil {
+			return err
+		}
+
+		var dst *bolt.Bucket
+		var err error
+		switch item.Kind {
+		case com.TrashKey:
+			b, err := tx.CreateBucketIfNotExists(d.Root)
+			if err != nil {
+				return err
+			} else if b.Get([]byte(item.Key)) != nil {
+				return com.ErrRestoreExist
+			} else if err = b.Put([]byte(item.Key), ib.Get(dataKey)); err != nil {
+				return err
+			}
+			return tb.DeleteBucket([]byte(trashID))
+		case com.TrashRow:
+			b, err := tx.CreateBucketIfNotExists([]byte(item.Table))
+			if err != nil {
+				return err
+			} else if b.Bucket([]byte(item.RowID)) != nil {
+				return com.ErrRestoreExist
+			}
+			dst, err = b.CreateBucket([]byte(item.RowID))
+		default:
+			if tx.Bucket([]byte(item.Table)) != nil {
+				return com.ErrRestoreExist
+			}
+			dst, err = tx.CreateBucket([]byte(item.Table))
+		}
+		if err != nil {
+			return err
+		} else if _, err = copyBucket(dst, ib.Bucket(dataKey)); err != nil {
+			return err
+		}
+		return tb.DeleteBucket([]byte(trashID))
+	})
+	return item, err
+}
+
+// PurgeTrash delete the trash items deleted before t, return the count
+func (d *Bolt) PurgeTrash(t time.Time) (int, error) {
+	var n int
+	err := d.update(func(tx *bolt.Tx) error {
+		tb, _ := trashRoot(tx, false)
+		if tb == nil {
+			return nil
+		}
+		var ids [][]byte
+		err := tb.ForEach(func(id, _ []byte) error {
+			var item com.TrashItem
+			if err := json.Unmarshal(tb.Bucket(id).Get(infoKey), &item); err != nil {
+				return err
+			}
+			if item.DeletedAt.Before(t) {
+				ids = append(ids, copyBytes(id))
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		for _, id := range ids {
+			if err := tb.DeleteBucket(id); err != nil {
+				return err
+			}
+		}
+		n = len(ids)
+		return nil
+	})
+	return n, err
+}
+
+// isReserved the top level bucket isn't a table
+func (d *Bolt) isReserved(name []byte) bool {
+	return bytes.Equal(name, d.Root) || bytes.Equal(name, kvdbBucket)
+}
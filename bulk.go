@@ -0,0 +1,551 @@
+package kvdb
+
+import (
+	"bufio"
+	"container/heap"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/lysShub/kvdb/badgerdb"
+	"github.com/lysShub/kvdb/com"
+)
+
+// ErrNotSorted the rows added to a Sorted BulkLoader are out of order
+var ErrNotSorted error = errors.New("kvdb: bulk loader rows are not sorted by table and id")
+
+// BulkLoader load a large amount of records into a fresh database, much
+// faster than SetTableRow
+//
+// badgerdb: the rows are written by badger's StreamWriter, the database must
+// be empty and mustn't be written by others until Finish. boltdb: the rows
+// are written in order, ChunkRows records per transaction, with the pages
+// filled up to FillPercent. memdb: the rows are written in order, ChunkRows
+// records per lock.
+//
+// unsorted rows are sorted externally: spilled to sorted run files in
+// TempDir, then merged when Finish. If Checkpoint is set, a crashed loading
+// can be resumed: Init a loader with the same options again, skip the first
+// Skip() rows of the input and Add the rest.
+//
+// rows with a same table and id are merged, the later added field win.
+type BulkLoader struct {
+	DB *KVDB // must set
+	// the rows are added in order of (table, id), skip the external sort
+	Sorted bool
+	// folder of the sorted run files, default os.TempDir()
+	TempDir string
+	// rows per sorted run file, default 100000
+	RunRows int
+	// boltdb's bucket fill percent, default 1.0 as the rows are appended in order
+	FillPercent float64
+	// checkpoint file, default "" is not resumable
+	Checkpoint string
+
+	state  bulkState
+	buf    []com.BulkRow
+	bufIn  int64 // added rows in buf
+	sl     *badgerdb.StreamLoader
+	stats  BulkStats
+	start  time.Time
+	inited bool
+}
+
+// BulkStats throughput statistics of a BulkLoader
+type BulkStats struct {
+	Rows    int64         // added rows
+	Values  int64         // added values
+	Bytes   int64         // added bytes of ids, fields and values
+	Runs    int           // written sorted run files
+	Loaded  int64         // rows written to the database
+	Elapsed time.Duration // since Init
+}
+
+// RowsPerSecond added rows per second
+func (s BulkStats) RowsPerSecond() float64 {
+	if s.Elapsed <= 0 {
+		return 0
+	}
+	return float64(s.Rows) / s.Elapsed.Seconds()
+}
+
+// bulkState the checkpoint
+type bulkState struct {
+	Added   int64    `json:"added"`   // input rows that are safe in run files(or the db)
+	Runs    []string `json:"runs"`    // sorted run files
+	Loading bool     `json:"loading"` // merging runs to the db
+	Loaded  int64    `json:"loaded"`  // merged rows committed to boltdb
+}
+
+// Init init the loader, and load the checkpoint if exist
+func (l *BulkLoader) Init() error {
+	if l.DB == nil || l.DB.Type > 2 {
+		return errType
+	}
+	if l.TempDir == "" {
+		l.TempDir = os.TempDir()
+	}
+	if l.RunRows <= 0 {
+		l.RunRows = 100000
+	}
+	if l.FillPercent <= 0 {
+		l.FillPercent = 1.0
+	}
+
+	if l.Checkpoint != "" {
+		b, err := ioutil.ReadFile(l.Checkpoint)
+		if err == nil {
+			if err = json.Unmarshal(b, &l.state); err != nil {
+				return err
+			}
+		} else if !os.IsNotExist(err) {
+			return err
+		}
+	}
+	if l.Sorted && l.DB.Type == 0 {
+		// StreamWriter can't be continued, restart
+		resume := l.state.Loading
+		l.state = bulkState{Loading: true}
+		sl, err := l.DB.DH.bg.NewStreamLoader(resume)
+		if err != nil {
+			return err
+		}
+		l.sl = sl
+		if err = l.save(); err != nil {
+			return err
+		}
+	}
+
+	l.start, l.inited = time.Now(), true
+	return nil
+}
+
+// Skip the count of input rows that were added before the checkpoint, they
+// must be skipped when resume
+func (l *BulkLoader) Skip() int64 {
+	return l.state.Added
+}
+
+// Stats the statistics until now
+func (l *BulkLoader) Stats() BulkStats {
+	s := l.stats
+	s.Elapsed = time.Since(l.start)
+	return s
+}
+
+// Add add a record
+func (l *BulkLoader) Add(tableName, id string, row map[string][]byte) error {
+	if !l.inited {
+		return errors.New("kvdb: bulk loader is not inited")
+	}
+	l.stats.Rows++
+	l.stats.Values = l.stats.Values + int64(len(row))
+	l.stats.Bytes = l.stats.Bytes + int64(len(id))
+	for f, v := range row {
+		l.stats.Bytes = l.stats.Bytes + int64(len(f)+len(v))
+	}
+
+	// 复制，合并时不修改调用者的map
+	r := com.BulkRow{Table: tableName, ID: id, Row: make(map[string][]byte, len(row))}
+	mergeRow(r.Row, row)
+	if !l.Sorted {
+		l.buf = append(l.buf, r)
+		if l.bufIn++; len(l.buf) >= l.RunRows {
+			return l.spill()
+		}
+		return nil
+	}
+
+	if n := len(l.buf); n != 0 {
+		last := l.buf[n-1]
+		if bulkLess(r, last) {
+			return ErrNotSorted
+		} else if !bulkLess(last, r) {
+			mergeRow(last.Row, r.Row)
+			l.bufIn++
+			return nil
+		}
+	}
+	l.buf = append(l.buf, r)
+	l.bufIn++
+	if len(l.buf) > l.chunkRows() {
+		// 保留最后一行，可能与下一行合并
+		last := l.buf[len(l.buf)-1]
+		if err := l.load(l.buf[:len(l.buf)-1]); err != nil {
+			return err
+		}
+		l.buf = append(l.buf[:0], last)
+		l.state.Added = l.state.Added + l.bufIn - 1
+		l.bufIn = 1
+		if l.DB.Type == 1 {
+			return l.save()
+		}
+	}
+	return nil
+}
+
+// Finish write all rows to the database, remove the run files and the checkpoint
+func (l *BulkLoader) Finish() error {
+	if !l.inited {
+		return errors.New("kvdb: bulk loader is not inited")
+	}
+	if l.DB.DH.ch != nil {
+		defer l.DB.DH.ch.InvalidateAll()
+	}
+
+	if l.Sorted {
+		if err := l.load(l.buf); err != nil {
+			return err
+		}
+		l.buf = nil
+		if l.sl != nil {
+			if err := l.sl.Flush(); err != nil {
+				return err
+			}
+		}
+		return l.cleanup()
+	}
+
+	if err := l.spill(); err != nil {
+		return err
+	}
+	resume := l.state.Loading
+	l.state.Loading = true
+	if err := l.save(); err != nil {
+		return err
+	}
+	if l.DB.Type == 0 {
+		// 中断后从头开始
+		l.state.Loaded = 0
+		sl, err := l.DB.DH.bg.NewStreamLoader(resume)
+		if err != nil {
+			return err
+		}
+		l.sl = sl
+	}
+
+	m, err := newRunMerger(l.state.Runs)
+	if err != nil {
+		return err
+	}
+	defer m.close()
+
+	var skip int64 = l.state.Loaded
+	var loaded int64 = skip // 已提交的行，包括之前的运行
+	var chunk []com.BulkRow
+	for {
+		r, err := m.next()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return err
+		}
+		if skip > 0 {
+			skip--
+			continue
+		}
+		if chunk = append(chunk, r); len(chunk) >= l.chunkRows() {
+			if err = l.load(chunk); err != nil {
+				return err
+			}
+			loaded, chunk = loaded+int64(len(chunk)), chunk[:0]
+			if l.DB.Type == 1 {
+				l.state.Loaded = loaded
+				if err = l.save(); err != nil {
+					return err
+				}
+			}
+		}
+	}
+	if err = l.load(chunk); err != nil {
+		return err
+	}
+	if l.sl != nil {
+		if err = l.sl.Flush(); err != nil {
+			return err
+		}
+	}
+	m.close()
+	return l.cleanup()
+}
+
+func (l *BulkLoader) chunkRows() int {
+	if l.DB.ChunkRows <= 0 {
+		return 10000
+	}
+	return l.DB.ChunkRows
+}
+
+// load write sorted rows to the database
+func (l *BulkLoader) load(rows []com.BulkRow) error {
+	if len(rows) == 0 {
+		return nil
+	}
+	var err error
+	if l.DB.Type == 0 {
+		err = l.sl.Write(rows)
+	} else if l.DB.Type == 1 {
+		err = l.DB.DH.bt.BulkLoad(rows, l.FillPercent)
+	} else {
+		err = l.DB.DH.mm.BulkLoad(rows)
+	}
+	if err != nil {
+		return err
+	}
+	l.stats.Loaded = l.stats.Loaded + int64(len(rows))
+	if l.DB.Progress != nil {
+		l.DB.Progress("bulk", rows[len(rows)-1].Table, int(l.stats.Loaded))
+	}
+	return nil
+}
+
+// spill sort the buffered rows and write them to a run file
+func (l *BulkLoader) spill() error {
+	if len(l.buf) == 0 {
+		return nil
+	}
+	sort.SliceStable(l.buf, func(i, j int) bool { return bulkLess(l.buf[i], l.buf[j]) })
+
+	f, err := ioutil.TempFile(l.TempDir, "kvdb-bulk-*.run")
+	if err != nil {
+		return err
+	}
+	w := bufio.NewWriterSize(f, 1<<20)
+	for i := 0; i < len(l.buf); {
+		r := l.buf[i]
+		for i++; i < len(l.buf) && !bulkLess(r, l.buf[i]); i++ {
+			mergeRow(r.Row, l.buf[i].Row)
+		}
+		writeRunRow(w, r)
+	}
+	if err = w.Flush(); err == nil {
+		err = f.Sync()
+	}
+	if e := f.Close(); err == nil {
+		err = e
+	}
+	if err != nil {
+		os.Remove(f.Name())
+		return err
+	}
+
+	l.state.Runs = append(l.state.Runs, f.Name())
+	l.state.Added = l.state.Added + l.bufIn
+	l.stats.Runs++
+	l.buf, l.bufIn = l.buf[:0], 0
+	return l.save()
+}
+
+// save write the checkpoint
+func (l *BulkLoader) save() error {
+	if l.Checkpoint == "" {
+		return nil
+	}
+	b, err := json.Marshal(l.state)
+	if err != nil {
+		return err
+	}
+	tmp := l.Checkpoint + ".tmp"
+	if err = ioutil.WriteFile(tmp, b, 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, l.Checkpoint)
+}
+
+func (l *BulkLoader) cleanup() error {
+	for _, r := range l.state.Runs {
+		os.Remove(r)
+	}
+	l.state = bulkState{}
+	if l.Checkpoint != "" {
+		if err := os.Remove(l.Checkpoint); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	return nil
+}
+
+func bulkLess(a, b com.BulkRow) bool {
+	if a.Table != b.Table {
+		return a.Table < b.Table
+	}
+	return a.ID < b.ID
+}
+
+// mergeRow merge src into dst, src win
+func mergeRow(dst, src map[string][]byte) {
+	for f, v := range src {
+		dst[f] = v
+	}
+}
+
+// run file: a sequence of rows, every row is
+// table | id | count of fields | (field | value)...; strings are uvarint length prefixed
+
+func writeRunRow(w *bufio.Writer, r com.BulkRow) {
+	writeRunBytes(w, []byte(r.Table))
+	writeRunBytes(w, []byte(r.ID))
+	var b [binary.MaxVarintLen64]byte
+	w.Write(b[:binary.PutUvarint(b[:], uint64(len(r.Row)))])
+	for f, v := range r.Row {
+		writeRunBytes(w, []byte(f))
+		writeRunBytes(w, v)
+	}
+}
+
+func writeRunBytes(w *bufio.Writer, s []byte) {
+	var b [binary.MaxVarintLen64]byte
+	w.Write(b[:binary.PutUvarint(b[:], uint64(len(s)))])
+	w.Write(s)
+}
+
+func readRunRow(r *bufio.Reader) (com.BulkRow, error) {
+	var row com.BulkRow
+	t, err := readRunBytes(r)
+	if err != nil {
+		return row, err // io.EOF at the end
+	}
+	id, err := readRunBytes(r)
+	if err != nil {
+		return row, unexpectedEOF(err)
+	}
+	n, err := binary.ReadUvarint(r)
+	if err != nil {
+		return row, unexpectedEOF(err)
+	}
+	row = com.BulkRow{Table: string(t), ID: string(id), Row: make(map[string][]byte, n)}
+	for i := uint64(0); i < n; i++ {
+		f, err := readRunBytes(r)
+		if err != nil {
+			return row, unexpectedEOF(err)
+		}
+		v, err := readRunBytes(r)
+		if err != nil {
+			return row, unexpectedEOF(err)
+		}
+		row.Row[string(f)] = v
+	}
+	return row, nil
+}
+
+func readRunBytes(r *bufio.Reader) ([]byte, error) {
+	n, err := binary.ReadUvarint(r)
+	if err != nil {
+		return nil, err
+	}
+	b := make([]byte, n)
+	if _, err = io.ReadFull(r, b); err != nil {
+		return nil, unexpectedEOF(err)
+	}
+	return b, nil
+}
+
+func unexpectedEOF(err error) error {
+	if err == io.EOF {
+		return io.ErrUnexpectedEOF
+	}
+	return err
+}
+
+// runMerger k-way merge the sorted run files
+type runMerger struct {
+	files []*os.File
+	h     runHeap
+	next1 *com.BulkRow // the next row, already popped
+}
+
+type runHead struct {
+	row com.BulkRow
+	idx int // run index, the later run win
+	r   *bufio.Reader
+}
+
+type runHeap []*runHead
+
+func (h runHeap) Len() int { return len(h) }
+func (h runHeap) Less(i, j int) bool {
+	if bulkLess(h[i].row, h[j].row) {
+		return true
+	} else if bulkLess(h[j].row, h[i].row) {
+		return false
+	}
+	return h[i].idx < h[j].idx
+}
+func (h runHeap) Swap(i, j int)       { h[i], h[j] = h[j], h[i] }
+func (h *runHeap) Push(x interface{}) { *h = append(*h, x.(*runHead)) }
+func (h *runHeap) Pop() interface{} {
+	old := *h
+	x := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return x
+}
+
+func newRunMerger(runs []string) (*runMerger, error) {
+	var m = &runMerger{}
+	for i, name := range runs {
+		f, err := os.Open(name)
+		if err != nil {
+			m.close()
+			return nil, err
+		}
+		m.files = append(m.files, f)
+		r := bufio.NewReaderSize(f, 1<<20)
+		row, err := readRunRow(r)
+		if err == io.EOF {
+			continue
+		} else if err != nil {
+			m.close()
+			return nil, errors.New("kvdb: bulk run file " + strconv.Quote(filepath.Base(name)) + ": " + err.Error())
+		}
+		m.h = append(m.h, &runHead{row: row, idx: i, r: r})
+	}
+	heap.Init(&m.h)
+	return m, nil
+}
+
+// next the next merged row, io.EOF at the end
+func (m *runMerger) next() (com.BulkRow, error) {
+	if len(m.h) == 0 {
+		return com.BulkRow{}, io.EOF
+	}
+	r := m.h[0].row
+	if err := m.advance(); err != nil {
+		return r, err
+	}
+	for len(m.h) != 0 && !bulkLess(r, m.h[0].row) {
+		mergeRow(r.Row, m.h[0].row.Row)
+		if err := m.advance(); err != nil {
+			return r, err
+		}
+	}
+	return r, nil
+}
+
+// advance read the next row of the top run
+func (m *runMerger) advance() error {
+	top := m.h[0]
+	row, err := readRunRow(top.r)
+	if err == io.EOF {
+		heap.Pop(&m.h)
+		return nil
+	} else if err != nil {
+		return err
+	}
+	top.row = row
+	heap.Fix(&m.h, 0)
+	return nil
+}
+
+func (m *runMerger) close() {
+	for _, f := range m.files {
+		f.Close()
+	}
+	m.files = nil
+}
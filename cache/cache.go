@@ -0,0 +1,275 @@
+package cache
+
+import (
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/dgraph-io/ristretto"
+)
+
+// Cache in-process read cache in front of the database
+// entries are keyed by key, table+id (whole row) and table+id+field
+// every write must call one of the Invalidate methods after it reached the database
+type Cache struct {
+	rc *ristretto.Cache
+
+	mu    sync.RWMutex      // serialize populate and invalidate
+	epoch uint64            // increased by every invalidate
+	all   uint64            // generation of all entries
+	gens  map[string]uint64 // table generation, increased by table level invalidate
+
+	// ristretto apply Set asynchronously, a Set buffered before a Del can be
+	// applied after it; every entry record the generation of its slot and is
+	// ignored once the slot was invalidated
+	slots [slots]uint64
+}
+
+const slots = 1024
+
+type cached struct {
+	gen uint64
+	v   interface{}
+}
+
+// New create a cache, maxCost is the capacity in bytes
+func New(maxCost int64) (*Cache, error) {
+	if maxCost <= 0 {
+		maxCost = 64 << 20
+	}
+	counters := maxCost / 64 // assume ~640 bytes per entry, ten counters per entry
+	if counters < 1000 {
+		counters = 1000
+	}
+	rc, err := ristretto.NewCache(&ristretto.Config{
+		NumCounters: counters,
+		MaxCost:     maxCost,
+		BufferItems: 64,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &Cache{rc: rc, gens: make(map[string]uint64)}, nil
+}
+
+// Close release the cache
+func (c *Cache) Close() {
+	c.rc.Close()
+}
+
+// Epoch must be taken before reading the database, and passed to the Put
+// methods; a value read before a concurrent write is never cached
+func (c *Cache) Epoch() uint64 {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.epoch
+}
+
+// key/value
+
+// GetKey
+func (c *Cache) GetKey(key string) ([]byte, bool) {
+	c.mu.RLock()
+	k := keyKey(c.all, key)
+	c.mu.RUnlock()
+	v, ok := c.get(k)
+	if !ok {
+		return nil, false
+	}
+	return copyBytes(v.([]byte)), true
+}
+
+// PutKey expiresAt is unix time in seconds, 0 is never expire
+func (c *Cache) PutKey(epoch uint64, key string, value []byte, expiresAt uint64) {
+	c.put(epoch, func() (string, interface{}, int64) {
+		return keyKey(c.all, key), copyBytes(value), int64(len(key) + len(value))
+	}, expiresAt)
+}
+
+// InvalidateKey
+func (c *Cache) InvalidateKey(key string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.epoch++
+	c.del(keyKey(c.all, key))
+}
+
+// table
+
+// GetRow
+func (c *Cache) GetRow(tableName, id string) (map[string][]byte, bool) {
+	c.mu.RLock()
+	k := rowKey(c.all, c.gens[tableName], tableName, id)
+	c.mu.RUnlock()
+	v, ok := c.get(k)
+	if !ok {
+		return nil, false
+	}
+	return copyRow(v.(map[string][]byte)), true
+}
+
+// PutRow
+func (c *Cache) PutRow(epoch uint64, tableName, id string, row map[string][]byte, expiresAt uint64) {
+	c.put(epoch, func() (string, interface{}, int64) {
+		var cost int = len(tableName) + len(id)
+		for f, v := range row {
+			cost = cost + len(f) + len(v)
+		}
+		return rowKey(c.all, c.gens[tableName], tableName, id), copyRow(row), int64(cost)
+	}, expiresAt)
+}
+
+// GetValue
+func (c *Cache) GetValue(tableName, id, field string) ([]byte, bool) {
+	c.mu.RLock()
+	k := valueKey(c.all, c.gens[tableName], tableName, id, field)
+	c.mu.RUnlock()
+	v, ok := c.get(k)
+	if !ok {
+		return nil, false
+	}
+	return copyBytes(v.([]byte)), true
+}
+
+// PutValue
+func (c *Cache) PutValue(epoch uint64, tableName, id, field string, value []byte, expiresAt uint64) {
+	c.put(epoch, func() (string, interface{}, int64) {
+		return valueKey(c.all, c.gens[tableName], tableName, id, field), copyBytes(value),
+			int64(len(tableName) + len(id) + len(field) + len(value))
+	}, expiresAt)
+}
+
+// InvalidateRow invalidate a row and the given fields of it
+func (c *Cache) InvalidateRow(tableName, id string, fields ...string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.epoch++
+	all, gen := c.all, c.gens[tableName]
+	c.del(rowKey(all, gen, tableName, id))
+	for _, f := range fields {
+		c.del(valueKey(all, gen, tableName, id, f))
+	}
+}
+
+// InvalidateTable invalidate all entries of a table; used when the written
+// rows or fields are unknown, e.g. DeleteTable and DeleteTableRow
+func (c *Cache) InvalidateTable(tableName string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.epoch++
+	c.gens[tableName]++
+}
+
+// InvalidateAll invalidate every entry, e.g. after the database was replaced
+func (c *Cache) InvalidateAll() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.epoch++
+	c.all++
+	c.gens = make(map[string]uint64)
+}
+
+// put store an entry if no invalidate happened since epoch, entry is
+// evaluated under the lock so that it see the current table generation
+func (c *Cache) put(epoch uint64, entry func() (string, interface{}, int64), expiresAt uint64) {
+	var ttl time.Duration
+	if expiresAt != 0 {
+		if ttl = time.Until(time.Unix(int64(expiresAt), 0)); ttl <= 0 {
+			return
+		}
+	}
+
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	if c.epoch != epoch {
+		return
+	}
+	k, v, cost := entry()
+	c.rc.SetWithTTL(k, cached{gen: c.slots[slot(k)], v: v}, cost+64, ttl)
+}
+
+// get return the value if its slot wasn't invalidated after it was put
+func (c *Cache) get(k string) (interface{}, bool) {
+	c.mu.RLock()
+	gen := c.slots[slot(k)]
+	c.mu.RUnlock()
+	v, ok := c.rc.Get(k)
+	if !ok || v.(cached).gen != gen {
+		return nil, false
+	}
+	return v.(cached).v, true
+}
+
+// del must be called with the write lock held
+func (c *Cache) del(k string) {
+	c.slots[slot(k)]++
+	c.rc.Del(k)
+}
+
+// slot fnv-1a
+func slot(k string) int {
+	var h uint32 = 2166136261
+	for i := 0; i < len(k); i++ {
+		h ^= uint32(k[i])
+		h *= 16777619
+	}
+	return int(h % slots)
+}
+
+// names may contain any bytes, so every segment is length prefixed
+
+func keyKey(all uint64, key string) string {
+	var b strings.Builder
+	b.WriteString("k")
+	b.WriteString(strconv.FormatUint(all, 10))
+	writeSegment(&b, key)
+	return b.String()
+}
+
+func rowKey(all, gen uint64, tableName, id string) string {
+	var b strings.Builder
+	b.WriteString("r")
+	b.WriteString(strconv.FormatUint(all, 10))
+	b.WriteString(".")
+	b.WriteString(strconv.FormatUint(gen, 10))
+	writeSegment(&b, tableName)
+	writeSegment(&b, id)
+	return b.String()
+}
+
+func valueKey(all, gen uint64, tableName, id, field string) string {
+	var b strings.Builder
+	b.WriteString("v")
+	b.WriteString(strconv.FormatUint(all, 10))
+	b.WriteString(".")
+	b.WriteString(strconv.FormatUint(gen, 10))
+	writeSegment(&b, tableName)
+	writeSegment(&b, id)
+	writeSegment(&b, field)
+	return b.String()
+}
+
+func writeSegment(b *strings.Builder, s string) {
+	b.WriteString(":")
+	b.WriteString(strconv.Itoa(len(s)))
+	b.WriteString(":")
+	b.WriteString(s)
+}
+
+func copyBytes(v []byte) []byte {
+	if v == nil {
+		return nil
+	}
+	r := make([]byte, len(v))
+	copy(r, v)
+	return r
+}
+
+func copyRow(row map[string][]byte) map[string][]byte {
+	r := make(map[string][]byte, len(row))
+	for f, v := range row {
+		r[f] = copyBytes(v)
+	}
+	return r
+}
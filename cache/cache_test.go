@@ -0,0 +1,109 @@
+package cache
+
+import (
+	"strconv"
+	"testing"
+	"time"
+)
+
+func newTest(t *testing.T) *Cache {
+	c, err := New(1 << 20)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(c.Close)
+	return c
+}
+
+// eventually ristretto apply the sets asynchronously
+func eventually(t *testing.T, fn func() bool) {
+	for i := 0; !fn(); i++ {
+		if i > 100 {
+			t.Fatal("not cached")
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestInvalidate(t *testing.T) {
+	c := newTest(t)
+	c.PutKey(c.Epoch(), "k", []byte("1"), 0)
+	c.PutRow(c.Epoch(), "t", "1", map[string][]byte{"a": []byte("1")}, 0)
+	c.PutValue(c.Epoch(), "t", "1", "a", []byte("1"), 0)
+	c.PutValue(c.Epoch(), "u", "1", "a", []byte("1"), 0)
+	eventually(t, func() bool {
+		_, k := c.GetKey("k")
+		_, r := c.GetRow("t", "1")
+		_, v := c.GetValue("t", "1", "a")
+		_, u := c.GetValue("u", "1", "a")
+		return k && r && v && u
+	})
+
+	c.InvalidateKey("k")
+	if _, ok := c.GetKey("k"); ok {
+		t.Fatal("key")
+	}
+	c.InvalidateRow("t", "1", "a")
+	if _, ok := c.GetRow("t", "1"); ok {
+		t.Fatal("row")
+	} else if _, ok = c.GetValue("t", "1", "a"); ok {
+		t.Fatal("value")
+	}
+
+	c.PutRow(c.Epoch(), "t", "1", map[string][]byte{"a": []byte("1")}, 0)
+	eventually(t, func() bool { _, ok := c.GetRow("t", "1"); return ok })
+	c.InvalidateTable("t")
+	if _, ok := c.GetRow("t", "1"); ok {
+		t.Fatal("table")
+	} else if _, ok = c.GetValue("u", "1", "a"); !ok {
+		t.Fatal("other table is invalidated")
+	}
+	c.InvalidateAll()
+	if _, ok := c.GetValue("u", "1", "a"); ok {
+		t.Fatal("all")
+	}
+
+	// 已过期的值不缓存
+	c.PutKey(c.Epoch(), "e", []byte("1"), uint64(time.Now().Add(-time.Second).Unix()))
+	c.PutKey(c.Epoch(), "sentinel", []byte("1"), 0)
+	eventually(t, func() bool { _, ok := c.GetKey("sentinel"); return ok })
+	if _, ok := c.GetKey("e"); ok {
+		t.Fatal("expired value is cached")
+	}
+}
+
+// TestEpochRace a value read before a write mustn't be cached or served after
+// the write is invalidated
+func TestEpochRace(t *testing.T) {
+	c := newTest(t)
+
+	// 读取后发生写入，读到的旧值不能缓存
+	epoch := c.Epoch()
+	c.InvalidateRow("t", "1", "a")
+	c.PutRow(epoch, "t", "1", map[string][]byte{"a": []byte("old")}, 0)
+	c.PutValue(epoch, "t", "1", "a", []byte("old"), 0)
+	c.PutKey(c.Epoch(), "sentinel", []byte("1"), 0)
+	eventually(t, func() bool { _, ok := c.GetKey("sentinel"); return ok })
+	if _, ok := c.GetRow("t", "1"); ok {
+		t.Fatal("stale row is cached")
+	} else if _, ok = c.GetValue("t", "1", "a"); ok {
+		t.Fatal("stale value is cached")
+	}
+
+	// 缓存的写入还未被ristretto应用时失效，之后也不能读到
+	for i := 0; i < 10000; i++ {
+		k := strconv.Itoa(i % 16)
+		c.PutKey(c.Epoch(), k, []byte("old"), 0)
+		c.InvalidateKey(k)
+		for j := 0; j < 3; j++ {
+			if _, ok := c.GetKey(k); ok {
+				t.Fatal("stale key is served after invalidate", i)
+			}
+		}
+		c.PutRow(c.Epoch(), "t", k, map[string][]byte{"a": []byte("old")}, 0)
+		c.InvalidateTable("t")
+		if _, ok := c.GetRow("t", k); ok {
+			t.Fatal("stale row is served after invalidate", i)
+		}
+	}
+}
@@ -0,0 +1,39 @@
+package kvdb
+
+import "github.com/lysShub/kvdb/com"
+
+// integrity check
+//
+// the readers skip a key they can't decode(e.g. ReadTable return nil for a
+// malformed table key), Check find them: it run the storage engine's own
+// check(badgerdb table checksums, boltdb page consistency) and decode every
+// key by the kvdb layout. The db has no indexes or schema, so there is
+// nothing more to verify.
+
+// CheckReport result of Check, see CheckIssue
+type CheckReport = com.CheckReport
+
+// CheckIssue a problem found by Check, Kind is one of the Issue constants
+type CheckIssue = com.CheckIssue
+
+// kinds of CheckIssue
+const (
+	IssueStorage = com.IssueStorage
+	IssueKey     = com.IssueKey
+	IssueMeta    = com.IssueMeta
+	IssueTrash   = com.IssueTrash
+)
+
+// Check check the integrity of the db, the problems are in the report; the
+// error is a failure to run the check. It reads the whole db in one
+// transaction
+func (d *KVDB) Check() (CheckReport, error) {
+	if d.Type == 0 {
+		return d.DH.bg.Check()
+	} else if d.Type == 1 {
+		return d.DH.bt.Check()
+	} else if d.Type == 2 {
+		return d.DH.mm.Check()
+	}
+	return CheckReport{}, errType
+}
@@ -0,0 +1,158 @@
+// kvdb maintenance tool
+//
+//	kvdb migrate -path ./db [-delimiter "`"]
+//	kvdb compact -path ./db.bolt [-dst ./db.bolt.compact] [-fill 0.9]
+//	kvdb check -path ./db [-type 0]
+//	kvdb backup -path ./db -dir ./backups [-type 0] [-full] [-keep 7]
+//	kvdb restore -path ./db -dir ./backups [-type 0]
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/lysShub/kvdb"
+)
+
+var commands = map[string]func(args []string) error{
+	"migrate": migrate,
+	"compact": compact,
+	"check":   check,
+	"backup":  backup,
+	"restore": restore,
+}
+
+func main() {
+	if len(os.Args) < 2 || commands[os.Args[1]] == nil {
+		fmt.Fprintln(os.Stderr, "usage: kvdb <command> [flags]")
+		fmt.Fprintln(os.Stderr, "commands:")
+		fmt.Fprintln(os.Stderr, "  migrate  rewrite a badgerdb written with the delimiter key format")
+		fmt.Fprintln(os.Stderr, "  compact  rewrite a boltdb file to reclaim the freed pages")
+		fmt.Fprintln(os.Stderr, "  check    check the integrity of a badgerdb(-type 0) or boltdb(-type 1)")
+		fmt.Fprintln(os.Stderr, "  backup   write a full or incremental backup to a backup directory")
+		fmt.Fprintln(os.Stderr, "  restore  load the last backups of a backup directory into a db")
+		os.Exit(2)
+	}
+	if err := commands[os.Args[1]](os.Args[2:]); err != nil {
+		fmt.Fprintln(os.Stderr, os.Args[1]+":", err)
+		os.Exit(1)
+	}
+}
+
+func migrate(args []string) error {
+	fs := flag.NewFlagSet("migrate", flag.ExitOnError)
+	path := fs.String("path", "", "badgerdb folder")
+	delimiter := fs.String("delimiter", "`", "delimiter of the legacy key format")
+	fs.Parse(args)
+	if *path == "" {
+		return fmt.Errorf("-path is required")
+	}
+
+	var db = &kvdb.KVDB{Type: 0, Path: *path, Delimiter: *delimiter}
+	if err := db.Migrate(); err != nil {
+		return err
+	}
+	db.Close()
+	fmt.Println("migrated", *path)
+	return nil
+}
+
+func compact(args []string) error {
+	fs := flag.NewFlagSet("compact", flag.ExitOnError)
+	path := fs.String("path", "", "boltdb file")
+	dst := fs.String("dst", "", "temporary file, default path + \".compact\"")
+	fill := fs.Float64("fill", 0.9, "page fill percent")
+	fs.Parse(args)
+	if *path == "" {
+		return fmt.Errorf("-path is required")
+	}
+
+	var db = &kvdb.KVDB{Type: 1, Path: *path, CompactFillPercent: *fill}
+	if err := db.Init(); err != nil {
+		return err
+	}
+	defer db.Close()
+	s, err := db.Compact(*dst)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("compacted %s: %d keys, %d -> %d bytes\n", *path, s.Keys, s.SizeBefore, s.SizeAfter)
+	return nil
+}
+
+func check(args []string) error {
+	fs := flag.NewFlagSet("check", flag.ExitOnError)
+	path := fs.String("path", "", "badgerdb folder or boltdb file")
+	typ := fs.Uint("type", 0, "0 is badgerdb, 1 is boltdb")
+	fs.Parse(args)
+	if *path == "" {
+		return fmt.Errorf("-path is required")
+	}
+
+	var db = &kvdb.KVDB{Type: uint8(*typ), Path: *path}
+	if err := db.Init(); err != nil {
+		return err
+	}
+	defer db.Close()
+	r, err := db.Check()
+	if err != nil {
+		return err
+	}
+	fmt.Printf("checked %s: %d keys, %d tables, %d records\n", *path, r.Keys, r.Tables, r.Rows)
+	for _, is := range r.Issues {
+		fmt.Printf("%-8s %q %s\n", is.Kind, is.Key, is.Detail)
+	}
+	if n := len(r.Issues) + r.Lost; n > 0 {
+		return fmt.Errorf("%d issues found", n)
+	}
+	return nil
+}
+
+func backup(args []string) error {
+	fs := flag.NewFlagSet("backup", flag.ExitOnError)
+	path := fs.String("path", "", "badgerdb folder or boltdb file")
+	typ := fs.Uint("type", 0, "0 is badgerdb, 1 is boltdb")
+	dir := fs.String("dir", "", "backup directory")
+	full := fs.Bool("full", false, "full backup, default incremental after the last one")
+	keep := fs.Int("keep", 0, "full backups kept, 0 keep all")
+	fs.Parse(args)
+	if *path == "" || *dir == "" {
+		return fmt.Errorf("-path and -dir are required")
+	}
+
+	var db = &kvdb.KVDB{Type: uint8(*typ), Path: *path}
+	if err := db.Init(); err != nil {
+		return err
+	}
+	defer db.Close()
+	bd := &kvdb.BackupDir{Dir: *dir, Keep: *keep}
+	m, err := bd.Backup(db, *full)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("backup %s: %s, %d bytes, sha256 %s\n", *path, m.File, m.Size, m.SHA256)
+	return nil
+}
+
+func restore(args []string) error {
+	fs := flag.NewFlagSet("restore", flag.ExitOnError)
+	path := fs.String("path", "", "badgerdb folder or boltdb file")
+	typ := fs.Uint("type", 0, "0 is badgerdb, 1 is boltdb")
+	dir := fs.String("dir", "", "backup directory")
+	fs.Parse(args)
+	if *path == "" || *dir == "" {
+		return fmt.Errorf("-path and -dir are required")
+	}
+
+	var db = &kvdb.KVDB{Type: uint8(*typ), Path: *path}
+	if err := db.Init(); err != nil {
+		return err
+	}
+	defer db.Close()
+	if err := (&kvdb.BackupDir{Dir: *dir}).Restore(db); err != nil {
+		return err
+	}
+	fmt.Println("restored", *path)
+	return nil
+}
@@ -1,9 +1,14 @@
 package com
 
 import (
+	"bytes"
+	"encoding/binary"
 	"errors"
+	"math"
 	"os"
 	"path/filepath"
+	"sort"
+	"time"
 )
 
 // 方法可执行文件(不包括)所在路径
@@ -64,3 +69,269 @@ func ExpressionCalculate(exp string, lv int, rgt []byte) (bool, error) {
 		return false, errors.New(`invalid expression`)
 	}
 }
+
+// ListOptions options of listing the ids in a table
+type ListOptions struct {
+	Prefix string // only the ids start with Prefix
+	After  string // only the ids after it, for pagination; "" is from the first
+	Limit  int    // max count, 0 is no limit
+}
+
+// IterOptions options of iterating the records in a table
+type IterOptions struct {
+	Start   string // only the ids >= Start, "" is from the first
+	End     string // only the ids < End, "" is to the last
+	Reverse bool   // iterate from the last id to the first
+}
+
+// Progress report of a long running table operation, done is the count of
+// written or deleted values until now(records for boltdb's delete and
+// truncate); it's called after every committed chunk
+type Progress func(op, tableName string, done int)
+
+// ErrTableExist the target table is already exist
+var ErrTableExist error = errors.New("table is already exist")
+
+// ErrTableNotExist the table is not exist
+var ErrTableNotExist error = errors.New("table is not exist")
+
+// BulkRow a record of bulk loading
+type BulkRow struct {
+	Table string
+	ID    string
+	Row   map[string][]byte
+}
+
+// ErrBatchTooBig the atomic batch is over the backend's transaction limit
+var ErrBatchTooBig error = errors.New("batch is too big for one transaction")
+
+// batch operation kinds
+const (
+	OpSetKey byte = iota + 1
+	OpDeleteKey
+	OpSetRow
+	OpSetValue
+	OpDeleteRow
+)
+
+// BatchOp a write of a batch
+type BatchOp struct {
+	Kind  byte
+	Key   string // key of OpSetKey and OpDeleteKey
+	Table string
+	ID    string
+	Field string // field of OpSetValue
+	Value []byte
+	Row   map[string][]byte // fields of OpSetRow
+	TTL   time.Duration     // badgerdb and memdb, 0 is never expire
+}
+
+// ErrConditionFailed the condition of a conditional write isn't met, nothing is written
+var ErrConditionFailed error = errors.New("condition failed")
+
+// Condition the test of a record for a conditional write, all the set tests must pass
+type Condition struct {
+	Exist    bool                             // the record must exist
+	NotExist bool                             // the record mustn't exist
+	Equal    map[string][]byte                // the fields must have the values, a nil value means the field mustn't exist
+	Version  uint64                           // the record's version must be it, 0 is not tested
+	Func     func(row map[string][]byte) bool // custom test, row is nil if the record doesn't exist
+}
+
+// Match test the record and its version, row is nil or empty if it doesn't exist
+func (c Condition) Match(row map[string][]byte, version uint64) bool {
+	if len(row) == 0 {
+		row = nil
+	}
+	if (c.Exist && row == nil) || (c.NotExist && row != nil) {
+		return false
+	} else if c.Version != 0 && c.Version != version {
+		return false
+	}
+	for f, v := range c.Equal {
+		rv, ok := row[f]
+		if v == nil && ok {
+			return false
+		} else if v != nil && (!ok || !bytes.Equal(v, rv)) {
+			return false
+		}
+	}
+	return c.Func == nil || c.Func(row)
+}
+
+// ErrVersionConflict the record is written by others after the expected version
+var ErrVersionConflict error = errors.New("record version conflict")
+
+// RowMeta version of a record, kept by the backends
+type RowMeta struct {
+	Version   uint64    // increased on every write of the record, 0 is never written with version(e.g. bulk loaded)
+	UpdatedAt time.Time // time of the last write
+}
+
+// EncodeRowMeta 16 bytes: version | updated at(unix nanoseconds), big endian
+func EncodeRowMeta(m RowMeta) []byte {
+	var b = make([]byte, 16)
+	binary.BigEndian.PutUint64(b, m.Version)
+	binary.BigEndian.PutUint64(b[8:], uint64(m.UpdatedAt.UnixNano()))
+	return b
+}
+
+// DecodeRowMeta
+func DecodeRowMeta(b []byte) RowMeta {
+	if len(b) != 16 {
+		return RowMeta{}
+	}
+	return RowMeta{
+		Version:   binary.BigEndian.Uint64(b),
+		UpdatedAt: time.Unix(0, int64(binary.BigEndian.Uint64(b[8:]))),
+	}
+}
+
+// KeyVersion a kept version of a key/value
+type KeyVersion struct {
+	Ts        uint64 // commit timestamp of the write
+	Value     []byte
+	ExpiresAt uint64 // unix seconds, 0 is never expire
+	Deleted   bool   // deleted or expired
+}
+
+// RowVersion a record after a kept commit that wrote it
+type RowVersion struct {
+	Ts   uint64            // commit timestamp of the write
+	Row  map[string][]byte // nil if the record is deleted
+	Meta RowMeta
+}
+
+// trash item kinds
+const (
+	TrashKey byte = iota + 1
+	TrashRow
+	TrashTable
+)
+
+// TrashItem a soft deleted key/value, record or table
+type TrashItem struct {
+	ID        string // in order of deletion
+	Kind      byte
+	Key       string // of TrashKey
+	Table     string // of TrashRow and TrashTable
+	RowID     string // of TrashRow
+	DeletedAt time.Time
+}
+
+// ErrTrashNotExist the trash item is not exist, it's restored or purged
+var ErrTrashNotExist error = errors.New("trash item is not exist")
+
+// ErrRestoreExist the deleted data is written again, restore doesn't overwrite it
+var ErrRestoreExist error = errors.New("restored data is exist")
+
+// GCStats statistics of the badgerdb maintenance, see Maintain
+type GCStats struct {
+	Runs      int           // maintenance runs
+	Rewrites  int           // value log files rewritten by GC
+	Reclaimed int64         // bytes of the value log reclaimed
+	LastRun   time.Time     // start time of the last run
+	LastTook  time.Duration // duration of the last run
+	LastErr   error         // error of the last run
+}
+
+// CompactStats result of a boltdb compaction
+type CompactStats struct {
+	SizeBefore int64 // file size
+	SizeAfter  int64
+	Keys       int // key/values copied
+}
+
+// kinds of CheckIssue
+const (
+	IssueStorage = "storage" // the storage engine's own check, e.g. a checksum
+	IssueKey     = "key"     // a key kvdb can't decode
+	IssueMeta    = "meta"    // a malformed row meta
+	IssueTrash   = "trash"   // a malformed trash item
+)
+
+// CheckIssue a problem found by Check
+type CheckIssue struct {
+	Kind   string
+	Key    []byte // the raw key or bucket path, can be nil
+	Detail string
+}
+
+// MaxCheckIssues issues kept in a CheckReport, the rest are only counted
+const MaxCheckIssues = 1000
+
+// CheckReport result of Check
+type CheckReport struct {
+	Keys   int // key/values
+	Tables int
+	Rows   int
+	Issues []CheckIssue
+	Lost   int // issues over MaxCheckIssues
+}
+
+// OK no issue is found
+func (r *CheckReport) OK() bool {
+	return len(r.Issues) == 0
+}
+
+// Add add an issue
+func (r *CheckReport) Add(kind string, key []byte, detail string) {
+	if len(r.Issues) >= MaxCheckIssues {
+		r.Lost++
+		return
+	}
+	if key != nil {
+		key = append([]byte{}, key...)
+	}
+	r.Issues = append(r.Issues, CheckIssue{Kind: kind, Key: key, Detail: detail})
+}
+
+// ErrNotNumber the value isn't a counter written by the Incr operations
+var ErrNotNumber error = errors.New("value is not a 8 bytes counter")
+
+// counter encoding: 8 bytes big endian, int64 as two's complement and
+// float64 as IEEE 754 bits; a missing value is 0
+
+// EncodeInt64
+func EncodeInt64(n int64) []byte {
+	var b = make([]byte, 8)
+	binary.BigEndian.PutUint64(b, uint64(n))
+	return b
+}
+
+// DecodeInt64
+func DecodeInt64(v []byte) (int64, error) {
+	if len(v) == 0 {
+		return 0, nil
+	} else if len(v) != 8 {
+		return 0, ErrNotNumber
+	}
+	return int64(binary.BigEndian.Uint64(v)), nil
+}
+
+// EncodeFloat64
+func EncodeFloat64(f float64) []byte {
+	var b = make([]byte, 8)
+	binary.BigEndian.PutUint64(b, math.Float64bits(f))
+	return b
+}
+
+// DecodeFloat64
+func DecodeFloat64(v []byte) (float64, error) {
+	if len(v) == 0 {
+		return 0, nil
+	} else if len(v) != 8 {
+		return 0, ErrNotNumber
+	}
+	return math.Float64frombits(binary.BigEndian.Uint64(v)), nil
+}
+
+// SortedOrder indexes of s in sorted order, for reading in key order
+func SortedOrder(s []string) []int {
+	var r = make([]int, len(s))
+	for i := range r {
+		r[i] = i
+	}
+	sort.SliceStable(r, func(i, j int) bool { return s[r[i]] < s[r[j]] })
+	return r
+}
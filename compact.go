@@ -0,0 +1,37 @@
+package kvdb
+
+import (
+	"errors"
+
+	"github.com/lysShub/kvdb/boltdb"
+	"github.com/lysShub/kvdb/com"
+)
+
+// compaction, boltdb only
+//
+// a boltdb file never shrink after deletes, Compact rewrite it to a new file
+// and replace the db file with it, the KVDB is usable before and after it.
+// badgerdb reclaim the space by Maintain, see gc.go; memdb has no file,
+// Compact does nothing on it.
+
+// CompactStats result of Compact
+type CompactStats = com.CompactStats
+
+// ErrCompactBusy boltdb can't be compacted with open snapshots or iterators
+var ErrCompactBusy error = boltdb.ErrCompactBusy
+
+var errCompactType error = errors.New("kvdb.go: Compact is for boltdb, badgerdb use Maintain")
+
+// Compact rewrite the boltdb file compactly through the temporary file dst,
+// default the db path + ".compact" which must not exist; the writes wait for
+// it
+func (d *KVDB) Compact(dst string) (CompactStats, error) {
+	if d.Type == 0 {
+		return CompactStats{}, errCompactType
+	} else if d.Type == 1 {
+		return d.DH.bt.Compact(dst)
+	} else if d.Type == 2 {
+		return CompactStats{}, nil
+	}
+	return CompactStats{}, errType
+}
@@ -0,0 +1,54 @@
+package kvdb
+
+import (
+	"time"
+
+	"github.com/lysShub/kvdb/com"
+)
+
+// conditional writes, for optimistic concurrency
+//
+// the test and the write are done atomically in one transaction; if the
+// test fails nothing is written and ErrConditionFailed is returned.
+
+// ErrConditionFailed the condition of a conditional write isn't met
+var ErrConditionFailed = com.ErrConditionFailed
+
+// Condition the test of a record for SetTableRowIf
+type Condition = com.Condition
+
+// CompareAndSwapKey set the key to new if its value is old, a nil old means
+// the key mustn't exist
+func (d *KVDB) CompareAndSwapKey(key string, old, new []byte, ttl ...time.Duration) error {
+	if d.DH.ch != nil {
+		defer d.DH.ch.InvalidateKey(key)
+	}
+	if d.Type == 0 {
+		return d.DH.bg.CompareAndSwapKey(key, old, new, ttl...)
+	} else if d.Type == 1 {
+		return d.DH.bt.CompareAndSwapKey(key, old, new)
+	} else if d.Type == 2 {
+		return d.DH.mm.CompareAndSwapKey(key, old, new, ttl...)
+	}
+	return errType
+}
+
+// SetKeyIfNotExists create a value if the key doesn't exist
+func (d *KVDB) SetKeyIfNotExists(key string, value []byte, ttl ...time.Duration) error {
+	return d.CompareAndSwapKey(key, nil, value, ttl...)
+}
+
+// SetTableRowIf create/update a record in a table if the record match cond
+func (d *KVDB) SetTableRowIf(tableName, id string, cond Condition, p map[string][]byte, ttl ...time.Duration) error {
+	if d.DH.ch != nil {
+		defer d.DH.ch.InvalidateRow(tableName, id, fieldNames(p)...)
+	}
+	if d.Type == 0 {
+		return d.DH.bg.SetTableRowIf(tableName, id, cond, p, ttl...)
+	} else if d.Type == 1 {
+		return d.DH.bt.SetTableRowIf(tableName, id, cond, p)
+	} else if d.Type == 2 {
+		return d.DH.mm.SetTableRowIf(tableName, id, cond, p, ttl...)
+	}
+	return errType
+}
@@ -0,0 +1,84 @@
+package kvdb
+
+import "github.com/lysShub/kvdb/com"
+
+// atomic counters
+//
+// a counter is a 8 bytes big endian value, int64 or float64, read it by
+// DecodeInt64 or DecodeFloat64; a missing counter is 0, and incrementing a
+// value of other length return ErrNotNumber. The increment is atomic and
+// return the new value.
+//
+// badgerdb's MergeKeys don't read-modify-write, the deltas are merged by
+// badger's MergeOperator; the returned value includes the concurrent
+// increments that are done before it's read.
+
+// ErrNotNumber the value isn't a counter
+var ErrNotNumber = com.ErrNotNumber
+
+var (
+	EncodeInt64   = com.EncodeInt64
+	DecodeInt64   = com.DecodeInt64
+	EncodeFloat64 = com.EncodeFloat64
+	DecodeFloat64 = com.DecodeFloat64
+)
+
+// IncrKey add delta to a int64 counter
+func (d *KVDB) IncrKey(key string, delta int64) (int64, error) {
+	if d.DH.ch != nil {
+		defer d.DH.ch.InvalidateKey(key)
+	}
+	if d.Type == 0 {
+		return d.DH.bg.IncrKey(key, delta)
+	} else if d.Type == 1 {
+		return d.DH.bt.IncrKey(key, delta)
+	} else if d.Type == 2 {
+		return d.DH.mm.IncrKey(key, delta)
+	}
+	return 0, errType
+}
+
+// IncrKeyFloat add delta to a float64 counter
+func (d *KVDB) IncrKeyFloat(key string, delta float64) (float64, error) {
+	if d.DH.ch != nil {
+		defer d.DH.ch.InvalidateKey(key)
+	}
+	if d.Type == 0 {
+		return d.DH.bg.IncrKeyFloat(key, delta)
+	} else if d.Type == 1 {
+		return d.DH.bt.IncrKeyFloat(key, delta)
+	} else if d.Type == 2 {
+		return d.DH.mm.IncrKeyFloat(key, delta)
+	}
+	return 0, errType
+}
+
+// IncrTableValue add delta to a int64 counter field of a record
+func (d *KVDB) IncrTableValue(tableName, id, field string, delta int64) (int64, error) {
+	if d.DH.ch != nil {
+		defer d.DH.ch.InvalidateRow(tableName, id, field)
+	}
+	if d.Type == 0 {
+		return d.DH.bg.IncrTableValue(tableName, id, field, delta)
+	} else if d.Type == 1 {
+		return d.DH.bt.IncrTableValue(tableName, id, field, delta)
+	} else if d.Type == 2 {
+		return d.DH.mm.IncrTableValue(tableName, id, field, delta)
+	}
+	return 0, errType
+}
+
+// IncrTableValueFloat add delta to a float64 counter field of a record
+func (d *KVDB) IncrTableValueFloat(tableName, id, field string, delta float64) (float64, error) {
+	if d.DH.ch != nil {
+		defer d.DH.ch.InvalidateRow(tableName, id, field)
+	}
+	if d.Type == 0 {
+		return d.DH.bg.IncrTableValueFloat(tableName, id, field, delta)
+	} else if d.Type == 1 {
+		return d.DH.bt.IncrTableValueFloat(tableName, id, field, delta)
+	} else if d.Type == 2 {
+		return d.DH.mm.IncrTableValueFloat(tableName, id, field, delta)
+	}
+	return 0, errType
+}
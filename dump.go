@@ -0,0 +1,27 @@
+package kvdb
+
+// RAM dump, badgerdb only
+//
+// a RAMMode db is lost when it's closed; with KVDB.RAMDumpPath it's written
+// to the file on Close and every KVDB.RAMDumpInterval, and loaded on Init,
+// so a restart is warm. The writes after the last dump are lost if the
+// process crash.
+
+// Dump write the RAMMode db to KVDB.RAMDumpPath now, it does nothing if the
+// db isn't persisted
+func (d *KVDB) Dump() error {
+	if d.Type == 0 {
+		return d.DH.bg.Dump()
+	} else if d.Type == 1 || d.Type == 2 {
+		return nil
+	}
+	return errType
+}
+
+// DumpErr error of the last dump by RAMDumpInterval, nil if it succeeded
+func (d *KVDB) DumpErr() error {
+	if d.Type == 0 {
+		return d.DH.bg.DumpErr()
+	}
+	return nil
+}
@@ -0,0 +1,59 @@
+package kvdb
+
+import (
+	"context"
+
+	"github.com/lysShub/kvdb/com"
+)
+
+// maintenance
+//
+// badgerdb's value log isn't reclaimed by itself, Maintain GC it; set
+// KVDB.GCInterval to run it in background. boltdb reuse the freed pages
+// itself, the methods do nothing on it. memdb keep the expired values until
+// Maintain remove them, the readers skip them; it's run in background on
+// GCInterval too.
+
+// GCStats statistics of the badgerdb maintenance
+type GCStats = com.GCStats
+
+// Maintain run the badgerdb maintenance now: flatten the LSM tree if
+// KVDB.Flatten, then GC the value log until nothing is reclaimed or ctx is
+// done; on memdb remove the expired values
+func (d *KVDB) Maintain(ctx context.Context) error {
+	if d.Type == 0 {
+		return d.DH.bg.Maintain(ctx)
+	} else if d.Type == 1 {
+		return nil
+	} else if d.Type == 2 {
+		d.DH.mm.Maintain()
+		return nil
+	}
+	return errType
+}
+
+// GCStats statistics of the maintenance until now
+func (d *KVDB) GCStats() GCStats {
+	if d.Type == 0 {
+		return d.DH.bg.GCStats()
+	}
+	return GCStats{}
+}
+
+// PauseGC pause the background maintenance, e.g. during a heavy load
+func (d *KVDB) PauseGC() {
+	if d.Type == 0 {
+		d.DH.bg.PauseGC()
+	} else if d.Type == 2 {
+		d.DH.mm.PauseGC()
+	}
+}
+
+// ResumeGC resume the background maintenance
+func (d *KVDB) ResumeGC() {
+	if d.Type == 0 {
+		d.DH.bg.ResumeGC()
+	} else if d.Type == 2 {
+		d.DH.mm.ResumeGC()
+	}
+}
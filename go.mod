@@ -5,4 +5,5 @@ go 1.15
 require (
 	github.com/boltdb/bolt v1.3.1
 	github.com/dgraph-io/badger/v2 v2.2007.2
+	github.com/dgraph-io/ristretto v0.0.3-0.20200630154024-f66de99634de
 )
@@ -0,0 +1,121 @@
+package kvdb
+
+import (
+	"errors"
+
+	"github.com/lysShub/kvdb/com"
+)
+
+// value history, badgerdb only
+//
+// badgerdb keep NumVersionsToKeep versions of a value, each is identified by
+// the commit timestamp(ts) of the write, a logical counter rather than time;
+// a record's versions carry its RowMeta, whose UpdatedAt is the time. Take
+// Snapshot().Ts() to remember the ts of a time, e.g. at midnight, then
+// ReadAt(ts) read the keys and records as they were: the value at the ts is
+// the newest version whose Ts <= it.
+
+// KeyVersion a kept version of a key/value
+type KeyVersion = com.KeyVersion
+
+// RowVersion a record after a kept commit that wrote it
+type RowVersion = com.RowVersion
+
+// ErrNoHistory boltdb and memdb keep only the latest value
+var ErrNoHistory error = errors.New("value history is only kept by badgerdb")
+
+// KeyHistory the kept versions of a key, newest first
+func (d *KVDB) KeyHistory(key string) ([]KeyVersion, error) {
+	if d.Type == 0 {
+		return d.DH.bg.KeyHistory(key)
+	} else if d.Type == 1 || d.Type == 2 {
+		return nil, ErrNoHistory
+	}
+	return nil, errType
+}
+
+// RowHistory the record after every kept commit that wrote it, newest first;
+// Row is nil where it's deleted
+func (d *KVDB) RowHistory(tableName, id string) ([]RowVersion, error) {
+	if d.Type == 0 {
+		return d.DH.bg.RowHistory(tableName, id)
+	} else if d.Type == 1 || d.Type == 2 {
+		return nil, ErrNoHistory
+	}
+	return nil, errType
+}
+
+// HistoryView read the keys and records of a badgerdb as they were at a
+// commit ts, see ReadAt. A value expired by now is read as deleted, and the
+// versions discarded by compaction aren't seen
+type HistoryView struct {
+	db *KVDB
+	s  *Snapshot // the snapshot created it, can be nil
+	ts uint64
+}
+
+// ReadAt a view of the db at the commit ts; ErrNoHistory on boltdb and memdb
+func (d *KVDB) ReadAt(ts uint64) (*HistoryView, error) {
+	if d.Type == 0 {
+		return &HistoryView{db: d, ts: ts}, nil
+	} else if d.Type == 1 || d.Type == 2 {
+		return nil, ErrNoHistory
+	}
+	return nil, errType
+}
+
+// ReadAt see KVDB.ReadAt, a ts after the snapshot's Ts is read as its Ts;
+// the view is closed with the snapshot
+func (s *Snapshot) ReadAt(ts uint64) (*HistoryView, error) {
+	db := s.begin()
+	if db == nil {
+		return nil, ErrSnapshotClosed
+	}
+	defer s.end()
+	h, err := db.ReadAt(ts)
+	if err != nil {
+		return nil, err
+	}
+	h.s = s
+	return h, nil
+}
+
+// begin start a read, return nil if the snapshot is closed; call h.end after
+// the read if it isn't nil
+func (h *HistoryView) begin() *KVDB {
+	if h.s == nil {
+		return h.db
+	}
+	return h.s.begin()
+}
+
+func (h *HistoryView) end() {
+	if h.s != nil {
+		h.s.end()
+	}
+}
+
+// Ts the commit ts of the view
+func (h *HistoryView) Ts() uint64 {
+	return h.ts
+}
+
+// ReadKey read a value at the ts
+func (h *HistoryView) ReadKey(key string) ([]byte, error) {
+	db := h.begin()
+	if db == nil {
+		return nil, ErrSnapshotClosed
+	}
+	defer h.end()
+	return db.DH.bg.ReadKeyAt(key, h.ts)
+}
+
+// ReadTableRow read a record at the ts, nil if it isn't exist
+func (h *HistoryView) ReadTableRow(tableName, id string) (Row, error) {
+	db := h.begin()
+	if db == nil {
+		return nil, ErrSnapshotClosed
+	}
+	defer h.end()
+	return db.DH.bg.ReadTableRowAt(tableName, id, h.ts)
+}
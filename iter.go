@@ -0,0 +1,115 @@
+package kvdb
+
+import "github.com/lysShub/kvdb/com"
+
+// Row the fields of a record
+type Row = map[string][]byte
+
+// IterOptions options of iterating the records in a table
+type IterOptions = com.IterOptions
+
+// streaming reads
+//
+// the records are read one by one in id order, rather than all in a map as
+// ReadTable. Both ways read from a snapshot of the table, the cache isn't
+// used.
+
+// IterateTable call fn with every record in a table in id order, stop and
+// return the error when fn return error
+func (d *KVDB) IterateTable(tableName string, fn func(id string, row Row) error) error {
+	if d.Type == 0 {
+		return d.DH.bg.IterateTable(tableName, fn)
+	} else if d.Type == 1 {
+		return d.DH.bt.IterateTable(tableName, fn)
+	} else if d.Type == 2 {
+		return d.DH.mm.IterateTable(tableName, fn)
+	}
+	return errType
+}
+
+// IterateKeys call fn with every key/value between opts.Start and opts.End
+// in key order, stop and return the error when fn return error
+func (d *KVDB) IterateKeys(opts IterOptions, fn func(key string, value []byte) error) error {
+	if d.Type == 0 {
+		return d.DH.bg.IterateKeys(opts, fn)
+	} else if d.Type == 1 {
+		return d.DH.bt.IterateKeys(opts, fn)
+	} else if d.Type == 2 {
+		return d.DH.mm.IterateKeys(opts, fn)
+	}
+	return errType
+}
+
+// rowIterator a backend's RowIterator
+type rowIterator interface {
+	Seek(id string)
+	Next() bool
+	ID() string
+	Row() map[string][]byte
+	Err() error
+	Close()
+}
+
+// RowIterator pull-style iterator of the records in a table, must be closed
+//
+//	it, err := db.NewRowIterator("table", kvdb.IterOptions{})
+//	defer it.Close()
+//	for it.Next() {
+//		fmt.Println(it.ID(), it.Row())
+//	}
+//	err = it.Err()
+type RowIterator struct {
+	it   rowIterator
+	snap *Snapshot // the snapshot created it, can be nil; it's kept until Close
+}
+
+// NewRowIterator create a iterator between opts.Start and opts.End, it's
+// positioned before the first record, or the last in reverse mode
+func (d *KVDB) NewRowIterator(tableName string, opts IterOptions) (*RowIterator, error) {
+	if d.Type == 0 {
+		return &RowIterator{it: d.DH.bg.NewRowIterator(tableName, opts)}, nil
+	} else if d.Type == 1 {
+		it := d.DH.bt.NewRowIterator(tableName, opts)
+		if err := it.Err(); err != nil {
+			return nil, err
+		}
+		return &RowIterator{it: it}, nil
+	} else if d.Type == 2 {
+		return &RowIterator{it: d.DH.mm.NewRowIterator(tableName, opts)}, nil
+	}
+	return nil, errType
+}
+
+// Seek move to the first record whose id >= id, or <= id in reverse mode,
+// the next Next return it; the bounds are kept
+func (r *RowIterator) Seek(id string) {
+	r.it.Seek(id)
+}
+
+// Next move to the next record, return false at the end or on error
+func (r *RowIterator) Next() bool {
+	return r.it.Next()
+}
+
+// ID id of the current record
+func (r *RowIterator) ID() string {
+	return r.it.ID()
+}
+
+// Row fields of the current record
+func (r *RowIterator) Row() Row {
+	return r.it.Row()
+}
+
+// Err the error that stopped the iteration
+func (r *RowIterator) Err() error {
+	return r.it.Err()
+}
+
+// Close release the iterator, stop early is closing it
+func (r *RowIterator) Close() {
+	r.it.Close()
+	if r.snap != nil {
+		r.snap.forget(r.it)
+	}
+}
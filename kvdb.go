@@ -2,16 +2,23 @@ package kvdb
 
 import (
 	"errors"
+	"log"
 	"time"
 
 	"github.com/lysShub/kvdb/badgerdb"
 	"github.com/lysShub/kvdb/boltdb"
+	"github.com/lysShub/kvdb/cache"
+	"github.com/lysShub/kvdb/memdb"
 )
 
 // Handle
 type Handle struct {
 	bg *badgerdb.Badger
 	bt *boltdb.Bolt
+	mm *memdb.Mem
+	ch *cache.Cache
+	sn *snapshots
+	pg *purger
 }
 
 // key/value database
@@ -21,7 +28,8 @@ type KVDB struct {
 	// in table, you need a id for a "row", similar "PrimaryKey"
 	// all "name"(tableName,id,field) are string type, and all "value" are []byte type
 
-	// must set; 0:badgerdb; 1:boltdb
+	// must set; 0:badgerdb; 1:boltdb; 2:memdb, in memory without file, for
+	// tests and caches
 	Type uint8
 	// database handle
 	DH Handle
@@ -32,47 +40,153 @@ type KVDB struct {
 	Password [16]byte
 	// In memory mod, higher performance，default false
 	RAMMode bool
-	//delimit string, tableName and id can't contain it ;default ```
+	// the RAMMode db is dumped to this file on Close and loaded from it on
+	// Init, default "" is not persisted; see dump.go
+	RAMDumpPath string
+	// interval of dumping the RAMMode db, default 0 is only on Close
+	RAMDumpInterval time.Duration
+	// delimiter of the legacy key format, only used by Migrate; default `
+	// names can contain any bytes now
 	Delimiter string
+	// counter keys of IncrKey that use badger's MergeOperator, for high
+	// contention; they are int64 counters without TTL, see counter.go
+	MergeKeys []string
+	// versions of a value kept for KeyHistory, RowHistory and ReadAt,
+	// default 1; the older ones are discarded by compaction
+	NumVersionsToKeep int
+	// interval of the background maintenance(value log GC, memdb remove the
+	// expired values), default 0 is disable; see gc.go
+	GCInterval time.Duration
+	// a value log file is rewritten if this ratio of it is stale, default 0.5
+	GCDiscardRatio float64
+	// the maintenance flatten the LSM tree at first, default false
+	Flatten bool
 	/* only for boltdb */
 	//key/value store's bucket name, default _root
 	Root []byte
+	// records per transaction of SetTable, DeleteTable and TruncateTable, default 10000
+	ChunkRows int
+	// initial mmap size, default 256MB; a write that grow the file over it
+	// waits for the open snapshots and iterators
+	MmapSize int
+	// page fill percent of the file written by Compact, default 0.9
+	CompactFillPercent float64
+	// progress callback of chunked and long running table operations, e.g.
+	// CopyTable; called after every committed chunk, can be nil
+	Progress Progress
+	/* read cache */
+	// in-process read cache capacity in bytes, default 0 is disable
+	// ReadKey, ReadTableRow and ReadTableValue are served from it
+	CacheSize int64
+	/* snapshot */
+	// a snapshot open longer than it is reported to OnSnapshotLeak, default 10 minutes
+	SnapshotMaxAge time.Duration
+	// called with a snapshot that isn't closed: open longer than
+	// SnapshotMaxAge, garbage collected, or open when the db is closed;
+	// default log it with the stack where it's created
+	OnSnapshotLeak func(SnapshotInfo)
+	/* soft delete */
+	// DeleteKey, DeleteTableRow and DeleteTable move the data to the trash,
+	// see trash.go; default false
+	SoftDelete bool
+	// the trash items are purged after it, default 0 is kept for ever
+	TrashRetention time.Duration
 }
 
 var errType error = errors.New("kvdb.go: invalid value of KVDB.Type")
 
 // Init init function
 func (d *KVDB) Init() error {
+	return d.init(false)
+}
+
+// Migrate same as Init, but rewrite a badgerdb written with the legacy
+// delimiter key format to the current format at first
+func (d *KVDB) Migrate() error {
+	return d.init(true)
+}
+
+func (d *KVDB) init(migrate bool) error {
 	if d.Type == 0 { //badgerdb
 		var b = new(badgerdb.Badger)
 
 		b.Path = d.Path
 		b.Password = d.Password
 		b.RAM = d.RAMMode
-		b.Delimiter = "`"
-		if err := b.OpenDb(); err != nil {
+		b.Delimiter = d.Delimiter
+		b.Progress = d.Progress
+		b.MergeKeys = d.MergeKeys
+		b.NumVersions = d.NumVersionsToKeep
+		b.GCInterval = d.GCInterval
+		b.GCDiscardRatio = d.GCDiscardRatio
+		b.Flatten = d.Flatten
+		b.DumpPath = d.RAMDumpPath
+		b.DumpInterval = d.RAMDumpInterval
+		if b.Delimiter == "" {
+			b.Delimiter = "`"
+		}
+		if migrate {
+			if err := b.MigrateDb(); err != nil {
+				return err
+			}
+		} else if err := b.OpenDb(); err != nil {
 			return err
 		}
 		d.DH.bg = b
-		return nil
 	} else if d.Type == 1 { //blotdb
 		var b = new(boltdb.Bolt)
 		b.Path = d.Path
 		b.Root = d.Root
+		b.Progress = d.Progress
+		b.ChunkRows = d.ChunkRows
+		b.MmapSize = d.MmapSize
+		b.FillPercent = d.CompactFillPercent
 		if err := b.OpenDb(); err != nil {
 			return err
 		}
 		d.DH.bt = b
-		return nil
+	} else if d.Type == 2 { //memdb
+		var m = new(memdb.Mem)
+		m.Progress = d.Progress
+		m.GCInterval = d.GCInterval
+		if err := m.OpenDb(); err != nil {
+			return err
+		}
+		d.DH.mm = m
+	} else {
+		return errType
 	}
-	return errType
+
+	d.DH.sn = &snapshots{open: make(map[*snapshot]struct{})}
+	if d.SoftDelete && d.TrashRetention > 0 {
+		d.startPurge()
+	}
+	if d.CacheSize > 0 {
+		c, err := cache.New(d.CacheSize)
+		if err != nil {
+			d.Close()
+			return err
+		}
+		d.DH.ch = c
+	}
+	return nil
 }
 
 func (d *KVDB) Close() {
+	d.stopPurge()
+	d.closeSnapshots()
 	if d.Type == 0 { //badgerdb
-		d.DH.bg.Close()
+		if err := d.DH.bg.Close(); err != nil && d.RAMMode && d.RAMDumpPath != "" {
+			log.Printf("kvdb: close the RAM db: %v, the last dump may be lost", err)
+		}
 	} else if d.Type == 1 { //blotdb
 		d.DH.bt.Close()
+	} else if d.Type == 2 { //memdb
+		d.DH.mm.Close()
+	}
+	if d.DH.ch != nil {
+		d.DH.ch.Close()
+		d.DH.ch = nil
 	}
 	return
 }
@@ -81,30 +195,69 @@ func (d *KVDB) Close() {
 
 // SetKey create/update a value
 func (d *KVDB) SetKey(key string, value []byte, ttl ...time.Duration) error {
+	if d.DH.ch != nil {
+		defer d.DH.ch.InvalidateKey(key)
+	}
 	if d.Type == 0 {
-		return d.DH.bg.SetKey(key, value)
+		return d.DH.bg.SetKey(key, value, ttl...)
 	} else if d.Type == 1 {
 		return d.DH.bt.SetKey(key, value)
+	} else if d.Type == 2 {
+		return d.DH.mm.SetKey(key, value, ttl...)
 	}
 	return errType
 }
 
 // DeleteKey delete a value
 func (d *KVDB) DeleteKey(key string) error {
+	if d.DH.ch != nil {
+		defer d.DH.ch.InvalidateKey(key)
+	}
+	if d.SoftDelete {
+		return d.trash(TrashItem{Kind: TrashKey, Key: key})
+	}
 	if d.Type == 0 {
 		return d.DH.bg.DeleteKey(key)
 	} else if d.Type == 1 {
 		return d.DH.bt.DeleteKey(key)
+	} else if d.Type == 2 {
+		return d.DH.mm.DeleteKey(key)
 	}
 	return errType
 }
 
 // ReadKey read a value
 func (d *KVDB) ReadKey(key string) []byte {
+	if d.DH.ch == nil {
+		return d.readKey(key)
+	}
+	if v, ok := d.DH.ch.GetKey(key); ok {
+		return v
+	}
+
+	var v []byte
+	var expiresAt uint64
+	epoch := d.DH.ch.Epoch()
+	if d.Type == 0 {
+		v, expiresAt = d.DH.bg.ReadKeyExpires(key)
+	} else if d.Type == 2 {
+		v, expiresAt = d.DH.mm.ReadKeyExpires(key)
+	} else {
+		v = d.readKey(key)
+	}
+	if v != nil {
+		d.DH.ch.PutKey(epoch, key, v, expiresAt)
+	}
+	return v
+}
+
+func (d *KVDB) readKey(key string) []byte {
 	if d.Type == 0 {
 		return d.DH.bg.ReadKey(key)
 	} else if d.Type == 1 {
 		return d.DH.bt.ReadKey(key)
+	} else if d.Type == 2 {
+		return d.DH.mm.ReadKey(key)
 	}
 	return nil
 }
@@ -112,51 +265,137 @@ func (d *KVDB) ReadKey(key string) []byte {
 // table operations
 
 // SetTable create/update a table
+// a big table is written in chunks(badgerdb: over the transaction size
+// limit, boltdb: over ChunkRows records) and reported to Progress, then it
+// isn't atomic; see DeleteTable too
 func (d *KVDB) SetTable(tableName string, p map[string]map[string][]byte, ttl ...time.Duration) error {
+	if d.DH.ch != nil {
+		defer d.DH.ch.InvalidateTable(tableName)
+	}
 	if d.Type == 0 {
-		return d.DH.bg.SetTable(tableName, p)
+		return d.DH.bg.SetTable(tableName, p, ttl...)
 	} else if d.Type == 1 {
 		return d.DH.bt.SetTable(tableName, p)
+	} else if d.Type == 2 {
+		return d.DH.mm.SetTable(tableName, p, ttl...)
 	}
 	return errType
 }
 
 // SetTableRow create/update a record in a table
 func (d *KVDB) SetTableRow(tableName, id string, p map[string][]byte, ttl ...time.Duration) error {
+	if d.DH.ch != nil {
+		defer d.DH.ch.InvalidateRow(tableName, id, fieldNames(p)...)
+	}
 	if d.Type == 0 {
-		return d.DH.bg.SetTableRow(tableName, id, p)
+		return d.DH.bg.SetTableRow(tableName, id, p, ttl...)
 	} else if d.Type == 1 {
 		return d.DH.bt.SetTableRow(tableName, id, p)
+	} else if d.Type == 2 {
+		return d.DH.mm.SetTableRow(tableName, id, p, ttl...)
 	}
 	return errType
 }
 
 // SetTableValue create/update some one field's value in a table's some one record
 func (d *KVDB) SetTableValue(tableName, id, field string, value []byte, ttl ...time.Duration) error {
+	if d.DH.ch != nil {
+		defer d.DH.ch.InvalidateRow(tableName, id, field)
+	}
 	if d.Type == 0 {
-		return d.DH.bg.SetTableValue(tableName, id, field, value)
+		return d.DH.bg.SetTableValue(tableName, id, field, value, ttl...)
 	} else if d.Type == 1 {
 		return d.DH.bt.SetTableValue(tableName, id, field, value)
+	} else if d.Type == 2 {
+		return d.DH.mm.SetTableValue(tableName, id, field, value, ttl...)
+	}
+	return errType
+}
+
+// ReplaceTableRow set a record to p, the fields not in p are deleted
+// SetTableRow merge p into the existing record instead
+func (d *KVDB) ReplaceTableRow(tableName, id string, p map[string][]byte, ttl ...time.Duration) error {
+	if d.DH.ch != nil {
+		// the deleted fields are unknown
+		defer d.DH.ch.InvalidateTable(tableName)
+	}
+	if d.Type == 0 {
+		return d.DH.bg.ReplaceTableRow(tableName, id, p, ttl...)
+	} else if d.Type == 1 {
+		return d.DH.bt.ReplaceTableRow(tableName, id, p)
+	} else if d.Type == 2 {
+		return d.DH.mm.ReplaceTableRow(tableName, id, p, ttl...)
+	}
+	return errType
+}
+
+// PatchTableRow set the fields in set and delete the fields in unset of a record, atomically
+func (d *KVDB) PatchTableRow(tableName, id string, set map[string][]byte, unset []string, ttl ...time.Duration) error {
+	if d.DH.ch != nil {
+		defer d.DH.ch.InvalidateRow(tableName, id, append(fieldNames(set), unset...)...)
+	}
+	if d.Type == 0 {
+		return d.DH.bg.PatchTableRow(tableName, id, set, unset, ttl...)
+	} else if d.Type == 1 {
+		return d.DH.bt.PatchTableRow(tableName, id, set, unset)
+	} else if d.Type == 2 {
+		return d.DH.mm.PatchTableRow(tableName, id, set, unset, ttl...)
+	}
+	return errType
+}
+
+// DeleteTableValue delete some one field of a record in a table
+// a record without any field is deleted
+func (d *KVDB) DeleteTableValue(tableName, id, field string) error {
+	if d.DH.ch != nil {
+		defer d.DH.ch.InvalidateRow(tableName, id, field)
+	}
+	if d.Type == 0 {
+		return d.DH.bg.DeleteTableValue(tableName, id, field)
+	} else if d.Type == 1 {
+		return d.DH.bt.DeleteTableValue(tableName, id, field)
+	} else if d.Type == 2 {
+		return d.DH.mm.DeleteTableValue(tableName, id, field)
 	}
 	return errType
 }
 
 // DeleteTable deleta a teble
+// a big table is deleted in chunks(badgerdb: by DropPrefix, boltdb: ChunkRows
+// records per transaction), then it isn't atomic; badgerdb's DropPrefix
+// block all writes of the whole database until it finished
 func (d *KVDB) DeleteTable(tableName string) error {
+	if d.DH.ch != nil {
+		defer d.DH.ch.InvalidateTable(tableName)
+	}
+	if d.SoftDelete {
+		return d.trash(TrashItem{Kind: TrashTable, Table: tableName})
+	}
 	if d.Type == 0 {
 		return d.DH.bg.DeleteTable(tableName)
 	} else if d.Type == 1 {
 		return d.DH.bt.DeleteTable(tableName)
+	} else if d.Type == 2 {
+		return d.DH.mm.DeleteTable(tableName)
 	}
 	return errType
 }
 
 // DeleteTableRow delete some one record in a table
 func (d *KVDB) DeleteTableRow(tableName, id string) error {
+	if d.DH.ch != nil {
+		// the deleted fields are unknown
+		defer d.DH.ch.InvalidateTable(tableName)
+	}
+	if d.SoftDelete {
+		return d.trash(TrashItem{Kind: TrashRow, Table: tableName, RowID: id})
+	}
 	if d.Type == 0 {
 		return d.DH.bg.DeleteTableRow(tableName, id)
 	} else if d.Type == 1 {
 		return d.DH.bt.DeleteTableRow(tableName, id)
+	} else if d.Type == 2 {
+		return d.DH.mm.DeleteTableRow(tableName, id)
 	}
 	return errType
 }
@@ -167,6 +406,8 @@ func (d *KVDB) ReadTable(tableName string) map[string]map[string][]byte {
 		return d.DH.bg.ReadTable(tableName)
 	} else if d.Type == 1 {
 		return d.DH.bt.ReadTable(tableName)
+	} else if d.Type == 2 {
+		return d.DH.mm.ReadTable(tableName)
 	}
 	return nil
 }
@@ -177,36 +418,97 @@ func (d *KVDB) ReadTableExist(tableName string) bool {
 		return d.DH.bg.ReadTableExist(tableName)
 	} else if d.Type == 1 {
 		return d.DH.bt.ReadTableExist(tableName)
+	} else if d.Type == 2 {
+		return d.DH.mm.ReadTableExist(tableName)
 	}
 	return false
 }
 
 // ReadTableRow read a record in a table
 func (d *KVDB) ReadTableRow(tableName, id string) map[string][]byte {
+	if d.DH.ch == nil {
+		return d.readTableRow(tableName, id)
+	}
+	if r, ok := d.DH.ch.GetRow(tableName, id); ok {
+		return r
+	}
+
+	var r map[string][]byte
+	var expiresAt uint64
+	epoch := d.DH.ch.Epoch()
+	if d.Type == 0 {
+		r, expiresAt = d.DH.bg.ReadTableRowExpires(tableName, id)
+	} else if d.Type == 2 {
+		r, expiresAt = d.DH.mm.ReadTableRowExpires(tableName, id)
+	} else {
+		r = d.readTableRow(tableName, id)
+	}
+	if len(r) != 0 {
+		d.DH.ch.PutRow(epoch, tableName, id, r, expiresAt)
+	}
+	return r
+}
+
+func (d *KVDB) readTableRow(tableName, id string) map[string][]byte {
 	if d.Type == 0 {
 		return d.DH.bg.ReadTableRow(tableName, id)
 	} else if d.Type == 1 {
 		return d.DH.bt.ReadTableRow(tableName, id)
+	} else if d.Type == 2 {
+		return d.DH.mm.ReadTableRow(tableName, id)
 	}
 	return nil
 }
 
 // ReadTableRowExist judge a record is exist in a table
 func (d *KVDB) ReadTableRowExist(tableName, id string) bool {
+	if d.DH.ch != nil {
+		if r, ok := d.DH.ch.GetRow(tableName, id); ok {
+			return len(r) != 0
+		}
+	}
 	if d.Type == 0 {
 		return d.DH.bg.ReadTableRowExist(tableName, id)
 	} else if d.Type == 1 {
 		return d.DH.bt.ReadTableRowExist(tableName, id)
+	} else if d.Type == 2 {
+		return d.DH.mm.ReadTableRowExist(tableName, id)
 	}
 	return false
 }
 
 // ReadTableValue read a field's value of some one record in a table
 func (d *KVDB) ReadTableValue(tableName, id, field string) []byte {
+	if d.DH.ch == nil {
+		return d.readTableValue(tableName, id, field)
+	}
+	if v, ok := d.DH.ch.GetValue(tableName, id, field); ok {
+		return v
+	}
+
+	var v []byte
+	var expiresAt uint64
+	epoch := d.DH.ch.Epoch()
+	if d.Type == 0 {
+		v, expiresAt = d.DH.bg.ReadTableValueExpires(tableName, id, field)
+	} else if d.Type == 2 {
+		v, expiresAt = d.DH.mm.ReadTableValueExpires(tableName, id, field)
+	} else {
+		v = d.readTableValue(tableName, id, field)
+	}
+	if v != nil {
+		d.DH.ch.PutValue(epoch, tableName, id, field, v, expiresAt)
+	}
+	return v
+}
+
+func (d *KVDB) readTableValue(tableName, id, field string) []byte {
 	if d.Type == 0 {
 		return d.DH.bg.ReadTableValue(tableName, id, field)
 	} else if d.Type == 1 {
 		return d.DH.bt.ReadTableValue(tableName, id, field)
+	} else if d.Type == 2 {
+		return d.DH.mm.ReadTableValue(tableName, id, field)
 	}
 	return nil
 }
@@ -217,6 +519,17 @@ func (d *KVDB) ReadTableLimits(tableName, field, exp string, value int) []string
 		return d.DH.bg.ReadTableLimits(tableName, field, exp, value)
 	} else if d.Type == 1 {
 		return d.DH.bt.ReadTableLimits(tableName, field, exp, value)
+	} else if d.Type == 2 {
+		return d.DH.mm.ReadTableLimits(tableName, field, exp, value)
 	}
 	return nil
 }
+
+// fieldNames get the field names of a record
+func fieldNames(p map[string][]byte) []string {
+	var r []string = make([]string, 0, len(p))
+	for f := range p {
+		r = append(r, f)
+	}
+	return r
+}
@@ -0,0 +1,1757 @@
+package kvdb
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"runtime"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/boltdb/bolt"
+	badger "github.com/dgraph-io/badger/v2"
+)
+
+// openTest open a database of the Type in a temporary folder
+func openTest(t *testing.T, typ uint8) *KVDB {
+	var db = &KVDB{Type: typ, Path: filepath.Join(t.TempDir(), "db")}
+	if err := db.Init(); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(db.Close)
+	return db
+}
+
+func TestBinaryNames(t *testing.T) {
+	var names = [][]byte{
+		{0x00},
+		{0x00, 0x01},
+		{0xff, 0x00, 0xff},
+		[]byte("a`b```c"),
+		{0x12, 0x34, 0x56, 0x78, 0x9a, 0xbc, 0xde, 0xf0, 0x00, 0x00, 0x11, 0x22, 0x33, 0x44, 0x55, 0x66},
+	}
+	for _, typ := range []uint8{0, 1, 2} {
+		db := openTest(t, typ)
+		for i, n := range names {
+			v := []byte{byte(i)}
+			if err := db.SetKeyBytes(n, v); err != nil {
+				t.Fatal(typ, err)
+			}
+			if err := db.SetTableValueBytes(n, n, n, v); err != nil {
+				t.Fatal(typ, err)
+			}
+		}
+		for i, n := range names {
+			v := []byte{byte(i)}
+			if r := db.ReadKeyBytes(n); !bytes.Equal(r, v) {
+				t.Fatalf("type %d key %x: got %x", typ, n, r)
+			}
+			if r := db.ReadTableValueBytes(n, n, n); !bytes.Equal(r, v) {
+				t.Fatalf("type %d cell %x: got %x", typ, n, r)
+			}
+			row := db.ReadTableRowBytes(n, n)
+			if len(row) != 1 || !bytes.Equal(row[string(n)], v) {
+				t.Fatalf("type %d row %x: got %v", typ, n, row)
+			}
+		}
+		// ids and keys are iterated in byte order
+		var sorted []string
+		for i, n := range names {
+			if err := db.SetTableValueBytes([]byte("order"), n, []byte("f"), []byte{byte(i)}); err != nil {
+				t.Fatal(err)
+			}
+			sorted = append(sorted, string(n))
+		}
+		sort.Strings(sorted)
+		if ids := db.ListRowIDs("order", ListOptions{}); !reflect.DeepEqual(ids, sorted) {
+			t.Fatalf("type %d row ids order: %x", typ, ids)
+		}
+		var got []string
+		if err := db.IterateKeys(IterOptions{}, func(key string, value []byte) error {
+			got = append(got, key)
+			return nil
+		}); err != nil {
+			t.Fatal(err)
+		} else if !reflect.DeepEqual(got, sorted) {
+			t.Fatalf("type %d keys order: %x", typ, got)
+		}
+
+		// a key made of the legacy delimiter doesn't alias a table cell
+		if err := db.SetKey("users```1```name", []byte("k")); err != nil {
+			t.Fatal(err)
+		}
+		if db.ReadTableExist("users") {
+			t.Fatalf("type %d plain key created a table", typ)
+		}
+		if err := db.DeleteKeyBytes(names[0]); err != nil {
+			t.Fatal(err)
+		}
+		if db.ReadKeyBytes(names[0]) != nil || db.ReadKeyBytes(names[1]) == nil {
+			t.Fatalf("type %d delete by prefix", typ)
+		}
+	}
+}
+
+func TestIntrospection(t *testing.T) {
+	for _, typ := range []uint8{0, 1, 2} {
+		db := openTest(t, typ)
+		if err := db.SetKey("k", []byte("v")); err != nil {
+			t.Fatal(err)
+		}
+		for _, tn := range []string{"b", "a", "ab"} {
+			if err := db.SetTable(tn, map[string]map[string][]byte{
+				"u1":  {"name": []byte("1"), "age": []byte("1")},
+				"u2":  {"name": []byte("2")},
+				"u10": {"name": []byte("10"), "mail": []byte("10")},
+				"x":   {"name": []byte("x")},
+			}); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		if r := db.ListTables(); !reflect.DeepEqual(r, []string{"a", "ab", "b"}) {
+			t.Fatalf("type %d ListTables: %q", typ, r)
+		}
+		if r := db.ListRowIDs("a", ListOptions{}); !reflect.DeepEqual(r, []string{"u1", "u10", "u2", "x"}) {
+			t.Fatalf("type %d ListRowIDs: %q", typ, r)
+		}
+		if r := db.ListRowIDs("a", ListOptions{Prefix: "u", After: "u1", Limit: 1}); !reflect.DeepEqual(r, []string{"u10"}) {
+			t.Fatalf("type %d ListRowIDs page: %q", typ, r)
+		}
+		if r := db.ListRowIDs("a", ListOptions{Prefix: "u", After: "u2"}); len(r) != 0 {
+			t.Fatalf("type %d ListRowIDs end: %q", typ, r)
+		}
+		if r := db.ListFields("ab"); !reflect.DeepEqual(r, map[string]int{"name": 4, "age": 1, "mail": 1}) {
+			t.Fatalf("type %d ListFields: %v", typ, r)
+		}
+		if n := db.CountRows("b"); n != 4 {
+			t.Fatalf("type %d CountRows: %d", typ, n)
+		}
+		if n := db.CountRows("none"); n != 0 {
+			t.Fatalf("type %d CountRows: %d", typ, n)
+		}
+	}
+}
+
+func TestTableManagement(t *testing.T) {
+	var p = map[string]map[string][]byte{
+		"1": {"a": []byte("1a"), "b": []byte("1b")},
+		"2": {"a": []byte("2a")},
+	}
+	for _, typ := range []uint8{0, 1} {
+		db := openTest(t, typ)
+		if err := db.SetTable("t", p); err != nil {
+			t.Fatal(err)
+		}
+		if err := db.SetTable("other", p); err != nil {
+			t.Fatal(err)
+		}
+
+		if err := db.RenameTable("t", "other"); err != ErrTableExist {
+			t.Fatalf("type %d rename to exist table: %v", typ, err)
+		}
+		if err := db.RenameTable("none", "x"); err != ErrTableNotExist {
+			t.Fatalf("type %d rename not exist table: %v", typ, err)
+		}
+		if err := db.RenameTable("t", "t2"); err != nil {
+			t.Fatal(err)
+		}
+		if db.ReadTableExist("t") || !reflect.DeepEqual(db.ReadTable("t2"), p) {
+			t.Fatalf("type %d rename: %v", typ, db.ReadTable("t2"))
+		}
+
+		if err := db.CopyTable("t2", "t3"); err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(db.ReadTable("t2"), p) || !reflect.DeepEqual(db.ReadTable("t3"), p) {
+			t.Fatalf("type %d copy: %v", typ, db.ReadTable("t3"))
+		}
+
+		// copy to the other backend
+		other := openTest(t, 1-typ)
+		if err := db.CopyTable("t2", "t", other); err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(other.ReadTable("t"), p) {
+			t.Fatalf("type %d copy to other: %v", typ, other.ReadTable("t"))
+		}
+
+		if err := db.TruncateTable("t3"); err != nil {
+			t.Fatal(err)
+		}
+		if n := db.CountRows("t3"); n != 0 {
+			t.Fatalf("type %d truncate: %d rows", typ, n)
+		}
+		if !reflect.DeepEqual(db.ReadTable("t2"), p) {
+			t.Fatalf("type %d truncate touched other table", typ)
+		}
+		// the empty table is kept
+		if !db.ReadTableExist("t3") || !reflect.DeepEqual(db.ListTables(), []string{"other", "t2", "t3"}) {
+			t.Fatalf("type %d truncated table isn't kept: %v", typ, db.ListTables())
+		}
+		for _, target := range []*KVDB{other, openTest(t, 2)} {
+			if err := db.CopyTable("t3", "e", target); err != nil {
+				t.Fatal(err)
+			} else if !target.ReadTableExist("e") || target.CountRows("e") != 0 {
+				t.Fatalf("type %d copy empty table to type %d", typ, target.Type)
+			}
+		}
+		if err := db.CopyTable("t3", "t4"); err != nil {
+			t.Fatal(err)
+		} else if err = db.RenameTable("t4", "t5"); err != nil {
+			t.Fatal(err)
+		} else if db.ReadTableExist("t4") || !db.ReadTableExist("t5") {
+			t.Fatalf("type %d rename empty table", typ)
+		}
+		if err := db.SetTableValue("t3", "1", "f", []byte("v")); err != nil {
+			t.Fatal(err)
+		} else if err = db.DeleteTable("t3"); err != nil {
+			t.Fatal(err)
+		} else if db.ReadTableExist("t3") {
+			t.Fatalf("type %d truncated table isn't deleted", typ)
+		}
+	}
+}
+
+func TestRowReplacePatch(t *testing.T) {
+	for _, typ := range []uint8{0, 1, 2} {
+		db := openTest(t, typ)
+		if err := db.SetTableRow("t", "1", map[string][]byte{"a": []byte("a"), "b": []byte("b")}); err != nil {
+			t.Fatal(err)
+		}
+
+		if err := db.DeleteTableValue("t", "1", "a"); err != nil {
+			t.Fatal(err)
+		}
+		if r := db.ReadTableRow("t", "1"); !reflect.DeepEqual(r, map[string][]byte{"b": []byte("b")}) {
+			t.Fatalf("type %d DeleteTableValue: %q", typ, r)
+		}
+
+		if err := db.ReplaceTableRow("t", "1", map[string][]byte{"c": []byte("c")}); err != nil {
+			t.Fatal(err)
+		}
+		if r := db.ReadTableRow("t", "1"); !reflect.DeepEqual(r, map[string][]byte{"c": []byte("c")}) {
+			t.Fatalf("type %d ReplaceTableRow: %q", typ, r)
+		}
+
+		if err := db.PatchTableRow("t", "1", map[string][]byte{"d": []byte("d")}, []string{"c", "none"}); err != nil {
+			t.Fatal(err)
+		}
+		if r := db.ReadTableRow("t", "1"); !reflect.DeepEqual(r, map[string][]byte{"d": []byte("d")}) {
+			t.Fatalf("type %d PatchTableRow: %q", typ, r)
+		}
+
+		// the last field deleted, the record is gone on both backends
+		if err := db.DeleteTableValue("t", "1", "d"); err != nil {
+			t.Fatal(err)
+		}
+		if db.ReadTableRowExist("t", "1") || db.CountRows("t") != 0 {
+			t.Fatalf("type %d empty record exist", typ)
+		}
+	}
+}
+
+func TestBulkLoader(t *testing.T) {
+	for _, typ := range []uint8{0, 1, 2} {
+		for _, sorted := range []bool{false, true} {
+			db := openTest(t, typ)
+			l := &BulkLoader{DB: db, Sorted: sorted, TempDir: t.TempDir(), RunRows: 7}
+			if err := l.Init(); err != nil {
+				t.Fatal(typ, err)
+			}
+			var ids []int
+			for i := 0; i < 50; i++ {
+				ids = append(ids, i)
+			}
+			if !sorted {
+				ids = append(ids, 3) // merged with the first one
+				for i := range ids {
+					j := (i * 17) % len(ids)
+					ids[i], ids[j] = ids[j], ids[i]
+				}
+			}
+			for _, i := range ids {
+				id := string([]byte{'r', byte('0' + i/10), byte('0' + i%10)})
+				if err := l.Add("t", id, map[string][]byte{"a": {byte(i)}}); err != nil {
+					t.Fatal(typ, err)
+				}
+			}
+			// 合并同一记录时不修改调用者的map
+			first, second := map[string][]byte{"a": {1}}, map[string][]byte{"b": {2}}
+			l.Add("u", "x", first)
+			l.Add("u", "x", second)
+			if sorted {
+				if err := l.Add("s", "x", nil); err != ErrNotSorted {
+					t.Fatal(typ, "want ErrNotSorted:", err)
+				}
+			}
+			if err := l.Finish(); err != nil {
+				t.Fatal(typ, sorted, err)
+			}
+			if n := db.CountRows("t"); n != 50 {
+				t.Fatal(typ, sorted, "count", n)
+			}
+			if v := db.ReadTableValue("t", "r42", "a"); !bytes.Equal(v, []byte{42}) {
+				t.Fatal(typ, sorted, v)
+			}
+			if r := db.ReadTableRow("u", "x"); len(r) != 2 {
+				t.Fatal(typ, sorted, r)
+			} else if len(first) != 1 || len(second) != 1 {
+				t.Fatal(typ, sorted, "the added rows are modified", first, second)
+			}
+			if s := l.Stats(); s.Loaded != 51 || (!sorted && s.Runs == 0) {
+				t.Fatal(typ, sorted, s)
+			}
+		}
+	}
+}
+
+func TestBulkLoaderResume(t *testing.T) {
+	for _, typ := range []uint8{0, 1, 2} {
+		for _, sorted := range []bool{false, true} {
+			db := openTest(t, typ)
+			cp := filepath.Join(t.TempDir(), "checkpoint")
+			var tmp = t.TempDir()
+
+			// 模拟写入db时崩溃：checkpoint标记为loading，db中已有部分记录
+			l := &BulkLoader{DB: db, TempDir: tmp, RunRows: 7, Checkpoint: cp}
+			if sorted {
+				l.state.Loading = true
+			} else {
+				if err := l.Init(); err != nil {
+					t.Fatal(typ, err)
+				}
+				for i := 0; i < 20; i++ {
+					l.Add("t", strconv.Itoa(100+i), map[string][]byte{"a": {byte(i)}})
+				}
+				if err := l.spill(); err != nil {
+					t.Fatal(typ, err)
+				}
+				l.state.Loading = true
+			}
+			if err := l.save(); err != nil {
+				t.Fatal(typ, err)
+			}
+			db.SetTableRow("t", "100", Row{"a": {0}, "b": {0}})
+
+			l = &BulkLoader{DB: db, Sorted: sorted, TempDir: tmp, RunRows: 7, Checkpoint: cp}
+			if err := l.Init(); err != nil {
+				t.Fatal(typ, sorted, "resume:", err)
+			}
+			for i := l.Skip(); i < 20; i++ {
+				l.Add("t", strconv.Itoa(100+int(i)), map[string][]byte{"a": {byte(i)}})
+			}
+			if err := l.Finish(); err != nil {
+				t.Fatal(typ, sorted, err)
+			}
+			if n := db.CountRows("t"); n != 20 {
+				t.Fatal(typ, sorted, "count", n)
+			}
+			if r := db.ReadTableRow("t", "119"); !bytes.Equal(r["a"], []byte{19}) {
+				t.Fatal(typ, sorted, r)
+			}
+			if _, err := os.Stat(cp); !os.IsNotExist(err) {
+				t.Fatal(typ, sorted, "checkpoint isn't removed")
+			}
+		}
+	}
+
+	// boltdb 恢复后再次崩溃，checkpoint 不后退
+	db := openTest(t, 1)
+	db.ChunkRows = 3
+	cp := filepath.Join(t.TempDir(), "checkpoint")
+	var tmp = t.TempDir()
+	l := &BulkLoader{DB: db, TempDir: tmp, RunRows: 7, Checkpoint: cp}
+	if err := l.Init(); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 20; i++ {
+		l.Add("t", strconv.Itoa(100+i), map[string][]byte{"a": {byte(i)}})
+	}
+	type crash struct{}
+	db.Progress = func(op, table string, n int) {
+		if op == "bulk" && n >= 6 { // 第二批提交后、保存checkpoint前
+			panic(crash{})
+		}
+	}
+	finish := func(l *BulkLoader) (crashed bool, err error) {
+		defer func() {
+			if r := recover(); r != nil {
+				if _, ok := r.(crash); !ok {
+					panic(r)
+				}
+				crashed = true
+			}
+		}()
+		return false, l.Finish()
+	}
+	for i := 0; i < 3; i++ {
+		if i > 0 {
+			l = &BulkLoader{DB: db, TempDir: tmp, RunRows: 7, Checkpoint: cp}
+			if err := l.Init(); err != nil {
+				t.Fatal(i, err)
+			} else if l.Skip() != 20 {
+				t.Fatal(i, l.Skip())
+			}
+		}
+		if i == 2 {
+			db.Progress = nil
+		}
+		crashed, err := finish(l)
+		if err != nil || crashed != (i < 2) {
+			t.Fatal(i, crashed, err)
+		}
+	}
+	// 崩溃的运行提交两批、保存了一批：checkpoint 3，6，最后加载剩下的14行
+	if l.stats.Loaded != 14 {
+		t.Fatal("reloaded rows", l.stats.Loaded)
+	}
+	if n := db.CountRows("t"); n != 20 {
+		t.Fatal("count", n)
+	}
+}
+
+func TestRowIterator(t *testing.T) {
+	for _, typ := range []uint8{0, 1, 2} {
+		db := openTest(t, typ)
+		for _, id := range []string{"a", "b", "b\x00", "c", "d"} {
+			if err := db.SetTableRow("t", id, Row{"f": []byte(id)}); err != nil {
+				t.Fatal(typ, err)
+			}
+		}
+		var cases = []struct {
+			opts IterOptions
+			seek string
+			want []string
+		}{
+			{IterOptions{}, "", []string{"a", "b", "b\x00", "c", "d"}},
+			{IterOptions{Start: "b", End: "d"}, "", []string{"b", "b\x00", "c"}},
+			{IterOptions{Reverse: true}, "", []string{"d", "c", "b\x00", "b", "a"}},
+			{IterOptions{Reverse: true, Start: "b", End: "d"}, "", []string{"c", "b\x00", "b"}},
+			{IterOptions{}, "b\x00", []string{"b\x00", "c", "d"}},
+			{IterOptions{Reverse: true}, "bb", []string{"b\x00", "b", "a"}},
+			{IterOptions{Reverse: true, End: "c"}, "z", []string{"b\x00", "b", "a"}},
+		}
+		for i, c := range cases {
+			it, err := db.NewRowIterator("t", c.opts)
+			if err != nil {
+				t.Fatal(typ, err)
+			}
+			if c.seek != "" {
+				it.Seek(c.seek)
+			}
+			var got []string
+			for it.Next() {
+				if string(it.Row()["f"]) != it.ID() {
+					t.Fatal(typ, i, it.ID(), it.Row())
+				}
+				got = append(got, it.ID())
+			}
+			if err = it.Err(); err != nil {
+				t.Fatal(typ, i, err)
+			}
+			it.Close()
+			if !reflect.DeepEqual(got, c.want) {
+				t.Fatalf("type %d case %d: %q", typ, i, got)
+			}
+		}
+
+		var n int
+		err := db.IterateTable("t", func(id string, row Row) error {
+			if n++; n == 2 {
+				return ErrTableExist
+			}
+			return nil
+		})
+		if err != ErrTableExist || n != 2 {
+			t.Fatal(typ, "stop early", err, n)
+		}
+		if r := db.ReadTableLimits("t", "f", "=", 0); len(r) != 0 {
+			t.Fatal(typ, r)
+		}
+	}
+}
+
+func TestPagination(t *testing.T) {
+	for _, typ := range []uint8{0, 1, 2} {
+		db := openTest(t, typ)
+		for _, id := range []string{"a", "b", "c", "d", "e"} {
+			db.SetTableRow("t", id, Row{"f": []byte(id)})
+			db.SetKey(id, []byte(id))
+		}
+		for _, reverse := range []bool{false, true} {
+			var got []string
+			opts := PageOptions{Limit: 2, Reverse: reverse}
+			for i := 0; ; i++ {
+				p, err := db.ReadTablePage("t", opts)
+				if err != nil {
+					t.Fatal(typ, err)
+				}
+				for _, r := range p.Rows {
+					got = append(got, r.ID)
+				}
+				if i == 0 {
+					// 页之间的写入
+					db.SetTableRow("t", "a0", Row{"f": []byte("a0")})
+					db.SetTableRow("t", "d0", Row{"f": []byte("d0")})
+					db.DeleteTableRow("t", "e")
+				}
+				if opts.After = p.Next; p.Next == "" {
+					break
+				}
+			}
+			want := []string{"a", "b", "c", "d", "d0"}
+			if reverse {
+				want = []string{"e", "d", "c", "b", "a0", "a"}
+			}
+			if !reflect.DeepEqual(got, want) {
+				t.Fatalf("type %d reverse %v: %q", typ, reverse, got)
+			}
+			db.DeleteTableRow("t", "a0")
+			db.DeleteTableRow("t", "d0")
+			db.SetTableRow("t", "e", Row{"f": []byte("e")})
+		}
+
+		p, err := db.ReadKeysPage(PageOptions{Limit: 3, Reverse: true})
+		if err != nil || len(p.Keys) != 3 || p.Keys[0].Key != "e" || p.Next == "" {
+			t.Fatal(typ, p, err)
+		}
+		if _, err = db.ReadTablePage("t", PageOptions{After: p.Next}); err != ErrPageToken {
+			t.Fatal(typ, "want ErrPageToken", err)
+		}
+		p, err = db.ReadKeysPage(PageOptions{Limit: 3, Reverse: true, After: p.Next})
+		if err != nil || len(p.Keys) != 2 || p.Keys[1].Key != "a" || string(p.Keys[1].Value) != "a" || p.Next != "" {
+			t.Fatal(typ, p, err)
+		}
+	}
+}
+
+func TestParallelScan(t *testing.T) {
+	for _, typ := range []uint8{0, 1, 2} {
+		db := openTest(t, typ)
+		var p = make(map[string]map[string][]byte)
+		for i := 0; i < 2000; i++ {
+			id := strconv.Itoa(i)
+			p[id] = map[string][]byte{"a": []byte(id), "b": {byte(i)}}
+		}
+		if err := db.SetTable("t", p); err != nil {
+			t.Fatal(typ, err)
+		}
+		db.SetTableRow("u", "x", Row{"a": nil})
+
+		var mu sync.Mutex
+		var got = make(map[string]map[string][]byte)
+		err := db.ParallelScan("t", 4, func(worker int, batch []PageRow) error {
+			mu.Lock()
+			defer mu.Unlock()
+			for _, r := range batch {
+				if got[r.ID] != nil {
+					return errors.New("duplicate " + r.ID)
+				}
+				got[r.ID] = r.Row
+			}
+			return nil
+		})
+		if err != nil {
+			t.Fatal(typ, err)
+		}
+		if !reflect.DeepEqual(got, p) {
+			t.Fatal(typ, len(got))
+		}
+
+		err = db.ParallelScan("t", 4, func(worker int, batch []PageRow) error {
+			return ErrTableExist
+		})
+		if err != ErrTableExist {
+			t.Fatal(typ, err)
+		}
+	}
+}
+
+func TestMultiGet(t *testing.T) {
+	for _, typ := range []uint8{0, 1, 2} {
+		for _, cache := range []int64{0, 1 << 20} {
+			db := &KVDB{Type: typ, Path: filepath.Join(t.TempDir(), "db"), CacheSize: cache}
+			if err := db.Init(); err != nil {
+				t.Fatal(err)
+			}
+			defer db.Close()
+			for _, k := range []string{"c", "a", "b"} {
+				db.SetKey(k, []byte(k))
+				db.SetTableRow("t", k, Row{"f": []byte(k)})
+			}
+			db.ReadKey("b") // 缓存
+			db.ReadTableRow("t", "b")
+
+			vs, missing := db.ReadKeys("c", "x", "b", "a", "c")
+			if !reflect.DeepEqual(missing, []int{1}) || string(vs[0]) != "c" || vs[1] != nil || string(vs[2]) != "b" || string(vs[3]) != "a" || string(vs[4]) != "c" {
+				t.Fatalf("type %d cache %d: %q %v", typ, cache, vs, missing)
+			}
+			rows, missing := db.ReadTableRows("t", "y", "b", "a")
+			if !reflect.DeepEqual(missing, []int{0}) || rows[0] != nil || string(rows[1]["f"]) != "b" || string(rows[2]["f"]) != "a" {
+				t.Fatalf("type %d cache %d: %v %v", typ, cache, rows, missing)
+			}
+			if _, missing = db.ReadTableRows("none", "a"); !reflect.DeepEqual(missing, []int{0}) {
+				t.Fatal(typ, missing)
+			}
+		}
+	}
+}
+
+func TestBatch(t *testing.T) {
+	for _, typ := range []uint8{0, 1, 2} {
+		db := &KVDB{Type: typ, Path: filepath.Join(t.TempDir(), "db"), ChunkRows: 3}
+		if err := db.Init(); err != nil {
+			t.Fatal(err)
+		}
+		defer db.Close()
+		db.SetTableRow("t", "old", Row{"f": []byte("x")})
+
+		b := db.NewBatch()
+		for i := 0; i < 10; i++ {
+			b.SetKey(strconv.Itoa(i), []byte{byte(i)})
+		}
+		b.DeleteKey("3")
+		b.SetTableRow("t", "a", Row{"f": []byte("a"), "g": []byte("g")})
+		b.SetTableValue("t", "a", "f", []byte("b"))
+		b.DeleteTableRow("t", "old")
+		b.DeleteTableRow("t", "none")
+		if err := b.Commit(); err != nil {
+			t.Fatal(typ, err)
+		}
+		if b.Len() != 0 || string(db.ReadKey("9")) != "\x09" || db.ReadKey("3") != nil {
+			t.Fatal(typ, "keys")
+		}
+		if r := db.ReadTable("t"); len(r) != 1 || string(r["a"]["f"]) != "b" || string(r["a"]["g"]) != "g" {
+			t.Fatal(typ, r)
+		}
+	}
+}
+
+func TestBatchAtomic(t *testing.T) {
+	db := openTest(t, 0)
+	n := int(db.DH.bg.DbHandle.MaxBatchCount()) + 10
+	b := db.NewBatch()
+	b.Atomic = true
+	for i := 0; i < n; i++ {
+		b.SetKey(strconv.Itoa(i), []byte{1})
+	}
+	if err := b.Commit(); err != ErrBatchTooBig {
+		t.Fatal(err)
+	}
+	if db.ReadKey("0") != nil {
+		t.Fatal("atomic batch is partly written")
+	}
+	for i := 0; i < n; i++ {
+		b.SetKey(strconv.Itoa(i), []byte{1})
+	}
+	b.Atomic = false
+	if err := b.Commit(); err != nil {
+		t.Fatal(err)
+	}
+	if db.ReadKey(strconv.Itoa(n-1)) == nil {
+		t.Fatal("chunked batch")
+	}
+}
+
+// TestChunkedTable tables over the transaction limit(badgerdb) or ChunkRows(boltdb)
+func TestChunkedTable(t *testing.T) {
+	type call struct {
+		op   string
+		done int
+	}
+	for _, typ := range []uint8{0, 1} {
+		var calls []call
+		db := &KVDB{Type: typ, Path: filepath.Join(t.TempDir(), "db"), ChunkRows: 3, Progress: func(op, tableName string, done int) {
+			if tableName == "t" {
+				calls = append(calls, call{op, done})
+			}
+		}}
+		if err := db.Init(); err != nil {
+			t.Fatal(err)
+		}
+		defer db.Close()
+
+		var n = 10
+		if typ == 0 {
+			// 每行一个字段和meta，超过事务大小限制
+			n = int(db.DH.bg.DbHandle.MaxBatchCount())/2 + 10
+		}
+		var tab = make(map[string]map[string][]byte, n)
+		for i := 0; i < n; i++ {
+			tab[strconv.Itoa(i)] = map[string][]byte{"f": []byte{1}}
+		}
+		db.SetTableRow("other", "a", Row{"f": []byte("1")})
+		if err := db.SetTable("t", tab); err != nil {
+			t.Fatal(typ, err)
+		}
+		if c := db.CountRows("t"); c != n {
+			t.Fatal(typ, "set", c)
+		} else if len(calls) == 0 || calls[len(calls)-1] != (call{"set", n}) {
+			t.Fatal(typ, "set isn't chunked", calls)
+		}
+
+		calls = nil
+		if err := db.DeleteTable("t"); err != nil {
+			t.Fatal(typ, err)
+		}
+		if db.ReadTableExist("t") || db.CountRows("other") != 1 {
+			t.Fatal(typ, "delete")
+		}
+		var want []call
+		if typ == 0 {
+			want = []call{{"delete", 2 * n}} // by DropPrefix
+		} else {
+			want = []call{{"delete", 3}, {"delete", 6}, {"delete", 9}, {"delete", 10}}
+		}
+		if !reflect.DeepEqual(calls, want) {
+			t.Fatal(typ, calls)
+		}
+
+		if typ == 1 {
+			db.SetTable("t", tab)
+			calls = nil
+			if err := db.TruncateTable("t"); err != nil {
+				t.Fatal(err)
+			}
+			want = []call{{"truncate", 3}, {"truncate", 6}, {"truncate", 9}, {"truncate", 10}}
+			if !reflect.DeepEqual(calls, want) || !db.ReadTableExist("t") || db.CountRows("t") != 0 {
+				t.Fatal(typ, "truncate", calls)
+			}
+		}
+	}
+}
+
+// TestChunkedRestore the trash item is kept until a chunked restore is done
+func TestChunkedRestore(t *testing.T) {
+	var db *KVDB
+	var listed []int
+	db = &KVDB{Type: 0, Path: filepath.Join(t.TempDir(), "db"), SoftDelete: true, Progress: func(op, tableName string, done int) {
+		if op == "restore" {
+			items, _ := db.ListTrash()
+			listed = append(listed, len(items))
+		}
+	}}
+	if err := db.Init(); err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	n := int(db.DH.bg.DbHandle.MaxBatchCount())/2 + 10
+	var tab = make(map[string]map[string][]byte, n)
+	for i := 0; i < n; i++ {
+		tab[strconv.Itoa(i)] = map[string][]byte{"f": []byte{1}}
+	}
+	db.SetTable("t", tab)
+	if err := db.DeleteTable("t"); err != nil {
+		t.Fatal(err)
+	}
+	items, err := db.ListTrash()
+	if err != nil || len(items) != 1 {
+		t.Fatal(items, err)
+	}
+	if err = db.Restore(items[0].ID); err != nil {
+		t.Fatal(err)
+	}
+	if len(listed) < 2 || listed[0] != 1 {
+		t.Fatal("info is deleted before the data is restored", listed)
+	}
+	if items, _ = db.ListTrash(); len(items) != 0 || db.CountRows("t") != n {
+		t.Fatal(items, db.CountRows("t"))
+	}
+}
+
+func TestConditionalWrites(t *testing.T) {
+	for _, typ := range []uint8{0, 1, 2} {
+		db := openTest(t, typ)
+		if err := db.SetKeyIfNotExists("k", []byte("1")); err != nil {
+			t.Fatal(typ, err)
+		}
+		if err := db.SetKeyIfNotExists("k", []byte("2")); err != ErrConditionFailed {
+			t.Fatal(typ, err)
+		}
+		if err := db.CompareAndSwapKey("k", []byte("2"), []byte("3")); err != ErrConditionFailed {
+			t.Fatal(typ, err)
+		}
+		if err := db.CompareAndSwapKey("k", []byte("1"), []byte("3")); err != nil || string(db.ReadKey("k")) != "3" {
+			t.Fatal(typ, err)
+		}
+
+		insert := Condition{NotExist: true}
+		if err := db.SetTableRowIf("t", "a", insert, Row{"n": []byte("1")}); err != nil {
+			t.Fatal(typ, err)
+		}
+		if err := db.SetTableRowIf("t", "a", insert, Row{"n": []byte("9")}); err != ErrConditionFailed {
+			t.Fatal(typ, err)
+		}
+		if err := db.SetTableRowIf("t", "a", Condition{Equal: Row{"n": []byte("2")}}, Row{"n": []byte("9")}); err != ErrConditionFailed {
+			t.Fatal(typ, err)
+		}
+		if err := db.SetTableRowIf("t", "a", Condition{Equal: Row{"n": []byte("1"), "x": nil}}, Row{"n": []byte("2")}); err != nil {
+			t.Fatal(typ, err)
+		}
+		if err := db.SetTableRowIf("t", "b", Condition{Exist: true}, Row{"n": []byte("1")}); err != ErrConditionFailed {
+			t.Fatal(typ, err)
+		}
+		if r := db.ReadTable("t"); len(r) != 1 || string(r["a"]["n"]) != "2" {
+			t.Fatal(typ, r)
+		}
+
+		// 并发自增，每次成功都基于最新值
+		var wg sync.WaitGroup
+		for i := 0; i < 8; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				for j := 0; j < 10; {
+					old := db.ReadTableValue("t", "a", "n")
+					n, _ := strconv.Atoi(string(old))
+					err := db.SetTableRowIf("t", "a", Condition{Equal: Row{"n": old}}, Row{"n": []byte(strconv.Itoa(n + 1))})
+					if err == nil {
+						j++
+					} else if err != ErrConditionFailed {
+						t.Error(err)
+						return
+					}
+				}
+			}()
+		}
+		wg.Wait()
+		if v := string(db.ReadTableValue("t", "a", "n")); v != "82" {
+			t.Fatal(typ, v)
+		}
+	}
+}
+
+func TestCounters(t *testing.T) {
+	for _, typ := range []uint8{0, 1, 2} {
+		db := &KVDB{Type: typ, Path: filepath.Join(t.TempDir(), "db"), MergeKeys: []string{"hot"}, CacheSize: 1 << 20}
+		if err := db.Init(); err != nil {
+			t.Fatal(err)
+		}
+		defer db.Close()
+
+		var wg sync.WaitGroup
+		for i := 0; i < 8; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				for j := 0; j < 25; j++ {
+					if _, err := db.IncrKey("hot", 2); err != nil {
+						t.Error(err)
+					}
+					if _, err := db.IncrTableValue("t", "a", "views", 1); err != nil {
+						t.Error(err)
+					}
+				}
+			}()
+		}
+		wg.Wait()
+		if n, err := DecodeInt64(db.ReadKey("hot")); err != nil || n != 400 {
+			t.Fatal(typ, n, err)
+		}
+		sn, err := db.Snapshot()
+		if err != nil {
+			t.Fatal(typ, err)
+		}
+		db.IncrKey("hot", 1)
+		for i, want := range []int64{400, 401} {
+			var vs [][]byte
+			var missing []int
+			if i == 0 {
+				vs, missing = sn.ReadKeys("none", "hot")
+			} else {
+				vs, missing = db.ReadKeys("none", "hot")
+			}
+			if n, err := DecodeInt64(vs[1]); err != nil || n != want || !reflect.DeepEqual(missing, []int{0}) {
+				t.Fatal(typ, n, want, missing, err)
+			}
+		}
+		sn.Close()
+		if n, err := db.IncrTableValue("t", "a", "views", -1); err != nil || n != 199 {
+			t.Fatal(typ, n, err)
+		}
+		if f, err := db.IncrKeyFloat("f", 1.5); err != nil || f != 1.5 {
+			t.Fatal(typ, f, err)
+		}
+		if f, _ := db.IncrTableValueFloat("t", "a", "avg", 0.25); f != 0.25 {
+			t.Fatal(typ, f)
+		}
+		db.SetKey("s", []byte("abc"))
+		if _, err := db.IncrKey("s", 1); err != ErrNotNumber {
+			t.Fatal(typ, err)
+		}
+		if string(db.ReadKey("s")) != "abc" {
+			t.Fatal(typ, "failed increment wrote")
+		}
+	}
+}
+
+func TestMergeKeyReset(t *testing.T) {
+	for _, typ := range []uint8{0, 1, 2} {
+		db := &KVDB{Type: typ, Path: filepath.Join(t.TempDir(), "db"), MergeKeys: []string{"hot"}}
+		if err := db.Init(); err != nil {
+			t.Fatal(err)
+		}
+		defer db.Close()
+
+		db.IncrKey("hot", 5)
+		db.IncrKey("hot", 5)
+		if err := db.DeleteKey("hot"); err != nil {
+			t.Fatal(typ, err)
+		}
+		if v := db.ReadKey("hot"); v != nil {
+			t.Fatal(typ, "deleted counter", v)
+		}
+		s, err := db.Snapshot()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if n, err := db.IncrKey("hot", 1); err != nil || n != 1 {
+			t.Fatal(typ, "increment after delete", n, err)
+		}
+		if v := s.ReadKey("hot"); v != nil {
+			t.Fatal(typ, "deleted counter in snapshot", v)
+		}
+		s.Close()
+
+		if err = db.SetKey("hot", EncodeInt64(100)); err != nil {
+			t.Fatal(typ, err)
+		}
+		if n, err := db.IncrKey("hot", 1); err != nil || n != 101 {
+			t.Fatal(typ, "increment after set", n, err)
+		}
+		if n, _ := DecodeInt64(db.ReadKey("hot")); n != 101 {
+			t.Fatal(typ, n)
+		}
+
+		b := db.NewBatch()
+		b.DeleteKey("hot")
+		if err = b.Commit(); err != nil {
+			t.Fatal(typ, err)
+		}
+		if n, err := db.IncrKey("hot", 3); err != nil || n != 3 {
+			t.Fatal(typ, "increment after batch delete", n, err)
+		}
+
+		// 软删除移动合并后的值
+		db.IncrKey("hot", 4)
+		db.SoftDelete = true
+		if err = db.DeleteKey("hot"); err != nil {
+			t.Fatal(typ, err)
+		}
+		db.SoftDelete = false
+		if v := db.ReadKey("hot"); v != nil {
+			t.Fatal(typ, "trashed counter", v)
+		}
+		items, err := db.ListTrash()
+		if err != nil || len(items) != 1 {
+			t.Fatal(typ, items, err)
+		}
+		if err = db.Restore(items[0].ID); err != nil {
+			t.Fatal(typ, err)
+		}
+		if n, err := db.IncrKey("hot", 1); err != nil || n != 8 {
+			t.Fatal(typ, "increment after restore", n, err)
+		}
+
+		// 条件写入比较合并后的值
+		db.DeleteKey("hot")
+		db.IncrKey("hot", 5)
+		db.IncrKey("hot", 7)
+		if err = db.CompareAndSwapKey("hot", EncodeInt64(7), EncodeInt64(100)); err != ErrConditionFailed {
+			t.Fatal(typ, "compared with a delta", err)
+		}
+		if err = db.SetKeyIfNotExists("hot", EncodeInt64(100)); err != ErrConditionFailed {
+			t.Fatal(typ, err)
+		}
+		if err = db.CompareAndSwapKey("hot", EncodeInt64(12), EncodeInt64(100)); err != nil {
+			t.Fatal(typ, err)
+		}
+		if n, err := db.IncrKey("hot", 1); err != nil || n != 101 {
+			t.Fatal(typ, "increment after swap", n, err)
+		}
+		db.DeleteKey("hot")
+		if err = db.SetKeyIfNotExists("hot", EncodeInt64(10)); err != nil {
+			t.Fatal(typ, err)
+		}
+		if n, err := db.IncrKey("hot", 1); err != nil || n != 11 {
+			t.Fatal(typ, "increment after create", n, err)
+		}
+		if typ == 0 {
+			if err = db.SetKey("hot", EncodeInt64(1), time.Hour); err == nil {
+				t.Fatal("merge key with ttl")
+			}
+		}
+	}
+}
+
+func TestRowVersion(t *testing.T) {
+	for _, typ := range []uint8{0, 1, 2} {
+		db := openTest(t, typ)
+		if err := db.SetTableRow("t", "a", Row{"f": []byte("1")}); err != nil {
+			t.Fatal(typ, err)
+		}
+		r, m1 := db.ReadTableRowMeta("t", "a")
+		if m1.Version == 0 || time.Since(m1.UpdatedAt) > time.Minute || !reflect.DeepEqual(r, Row{"f": []byte("1")}) {
+			t.Fatal(typ, r, m1)
+		}
+		db.SetTableValue("t", "a", "g", []byte("2"))
+		_, m2 := db.ReadTableRowMeta("t", "a")
+		if m2.Version <= m1.Version {
+			t.Fatal(typ, m1, m2)
+		}
+
+		// 乐观锁
+		v, err := db.UpdateRow("t", "a", m2.Version, Row{"f": []byte("3")})
+		if err != nil || v <= m2.Version {
+			t.Fatal(typ, v, err)
+		}
+		if _, err = db.UpdateRow("t", "a", m2.Version, Row{"f": []byte("4")}); err != ErrVersionConflict {
+			t.Fatal(typ, err)
+		}
+		if err = db.SetTableRowIf("t", "a", Condition{Version: v}, Row{"h": []byte("5")}); err != nil {
+			t.Fatal(typ, err)
+		}
+		if _, err = db.UpdateRow("t", "new", 0, Row{"f": nil}); err != nil {
+			t.Fatal(typ, err)
+		}
+
+		// meta不是字段
+		if f := db.ListFields("t"); len(f) != 3 || f["f"] != 2 {
+			t.Fatal(typ, f)
+		}
+		if r := db.ReadTable("t"); len(r) != 2 || len(r["a"]) != 3 {
+			t.Fatal(typ, r)
+		}
+
+		// 随记录重命名，删除后重建版本不回退
+		_, m3 := db.ReadTableRowMeta("t", "a")
+		if err = db.RenameTable("t", "t2"); err != nil {
+			t.Fatal(typ, err)
+		}
+		if _, m := db.ReadTableRowMeta("t2", "a"); m != m3 {
+			t.Fatal(typ, m, m3)
+		}
+		db.PatchTableRow("t2", "a", nil, []string{"f", "g", "h"})
+		if db.ReadTableRowExist("t2", "a") || !reflect.DeepEqual(db.ListRowIDs("t2", ListOptions{}), []string{"new"}) {
+			t.Fatal(typ, "empty record isn't deleted")
+		}
+		db.SetTableRow("t2", "a", Row{"f": nil})
+		_, m4 := db.ReadTableRowMeta("t2", "a")
+		if m4.Version <= m3.Version {
+			t.Fatal(typ, m4, m3)
+		}
+
+		// 删除表后重建
+		if err = db.DeleteTable("t2"); err != nil {
+			t.Fatal(typ, err)
+		}
+		db.SetTableRow("t2", "a", Row{"f": nil})
+		if _, m := db.ReadTableRowMeta("t2", "a"); m.Version <= m4.Version {
+			t.Fatal(typ, m, m4)
+		}
+		if _, err = db.UpdateRow("t2", "a", m4.Version, Row{"f": []byte("6")}); err != ErrVersionConflict {
+			t.Fatal(typ, err)
+		}
+	}
+}
+
+func TestRowMetaExpire(t *testing.T) {
+	var dbs []*KVDB
+	for _, typ := range []uint8{0, 2} { // boltdb 不支持ttl
+		db := openTest(t, typ)
+		if err := db.SetTableRow("t", "a", Row{"f": []byte("1")}); err != nil {
+			t.Fatal(typ, err)
+		}
+		if err := db.ReplaceTableRow("t", "a", Row{"f": []byte("2")}, time.Second); err != nil {
+			t.Fatal(typ, err)
+		}
+		if err := db.SetTableRow("t", "b", Row{"f": []byte("3")}); err != nil {
+			t.Fatal(typ, err)
+		}
+		dbs = append(dbs, db)
+	}
+	time.Sleep(2 * time.Second)
+	for _, db := range dbs {
+		typ := db.Type
+		if db.ReadTableRowExist("t", "a") {
+			t.Fatal(typ, "record isn't expired")
+		}
+		if ids := db.ListRowIDs("t", ListOptions{}); !reflect.DeepEqual(ids, []string{"b"}) {
+			t.Fatal(typ, ids)
+		}
+		if n := db.CountRows("t"); n != 1 {
+			t.Fatal(typ, n)
+		}
+	}
+}
+
+func TestSnapshot(t *testing.T) {
+	for _, typ := range []uint8{0, 1, 2} {
+		var leaks = make(chan SnapshotInfo, 4)
+		db := &KVDB{Type: typ, Path: filepath.Join(t.TempDir(), "db"), MergeKeys: []string{"hot"}, CacheSize: 1 << 20,
+			SnapshotMaxAge: 50 * time.Millisecond, OnSnapshotLeak: func(i SnapshotInfo) { leaks <- i }}
+		if err := db.Init(); err != nil {
+			t.Fatal(typ, err)
+		}
+		db.SetKey("k", []byte("1"))
+		db.IncrKey("hot", 1)
+		db.SetTable("t", map[string]map[string][]byte{"a": {"f": []byte("1")}, "b": {"f": []byte("2")}})
+		db.ReadTableRow("t", "a") // 缓存
+
+		s, err := db.Snapshot()
+		if err != nil {
+			t.Fatal(typ, err)
+		}
+		db.SetKey("k", []byte("2"))
+		db.IncrKey("hot", 1)
+		db.DeleteTableRow("t", "a")
+		db.SetTableRow("t", "c", Row{"f": []byte("3")})
+		db.SetTableRow("u", "a", Row{"f": nil})
+
+		if v := s.ReadKey("k"); string(v) != "1" {
+			t.Fatal(typ, string(v))
+		}
+		if n, _ := DecodeInt64(s.ReadKey("hot")); n != 1 {
+			t.Fatal(typ, n)
+		}
+		if r := s.ReadTableRow("t", "a"); string(r["f"]) != "1" || s.ReadTableRowExist("t", "c") {
+			t.Fatal(typ, r)
+		}
+		if ids := s.ListRowIDs("t", ListOptions{}); !reflect.DeepEqual(ids, []string{"a", "b"}) || s.CountRows("t") != 2 {
+			t.Fatal(typ, ids)
+		}
+		if s.ReadTableExist("u") || len(s.ListTables()) != 1 {
+			t.Fatal(typ, s.ListTables())
+		}
+		if p, err := s.ReadTablePage("t", PageOptions{Limit: 1}); err != nil || len(p.Rows) != 1 || p.Rows[0].ID != "a" {
+			t.Fatal(typ, p, err)
+		}
+		if ids := db.ListRowIDs("t", ListOptions{}); !reflect.DeepEqual(ids, []string{"b", "c"}) {
+			t.Fatal(typ, ids)
+		}
+
+		// 关闭快照时关闭其迭代器
+		it, err := s.NewRowIterator("t", IterOptions{})
+		if err != nil || !it.Next() || it.ID() != "a" {
+			t.Fatal(typ, err)
+		}
+		if i := <-leaks; !strings.Contains(i.Reason, "open for more than") || !strings.Contains(i.Stack, "TestSnapshot") {
+			t.Fatal(typ, i)
+		}
+		s.Close()
+		if it.Next() || s.ReadTable("t") != nil {
+			t.Fatal(typ, "read a closed snapshot")
+		}
+		if err := s.IterateTable("t", func(string, Row) error { return nil }); err != ErrSnapshotClosed {
+			t.Fatal(typ, err)
+		}
+
+		// 忘记关闭的快照在关闭db时释放
+		if _, err = db.Snapshot(); err != nil {
+			t.Fatal(typ, err)
+		}
+		db.Close()
+		if i := <-leaks; i.Reason != "open when the db is closed" {
+			t.Fatal(typ, i)
+		}
+	}
+}
+
+func TestSnapshotIterator(t *testing.T) {
+	for _, typ := range []uint8{0, 1, 2} {
+		var leaks = make(chan SnapshotInfo, 4)
+		db := &KVDB{Type: typ, Path: filepath.Join(t.TempDir(), "db"), OnSnapshotLeak: func(i SnapshotInfo) { leaks <- i }}
+		if err := db.Init(); err != nil {
+			t.Fatal(typ, err)
+		}
+		db.SetTable("t", map[string]map[string][]byte{"a": {"f": []byte("1")}, "b": {"f": []byte("2")}})
+
+		s, err := db.Snapshot()
+		if err != nil {
+			t.Fatal(typ, err)
+		}
+		if r := s.ReadTableRowBytes([]byte("t"), []byte("a")); string(r["f"]) != "1" || !s.ReadTableExistBytes([]byte("t")) {
+			t.Fatal(typ, r)
+		}
+		db.DeleteTableRow("t", "a")
+
+		// 迭代器持有快照，不会被回收
+		it, err := s.NewRowIterator("t", IterOptions{})
+		if err != nil {
+			t.Fatal(typ, err)
+		}
+		for i := 0; i < 3; i++ {
+			runtime.GC()
+		}
+		if !it.Next() || it.ID() != "a" || !it.Next() || it.ID() != "b" || len(leaks) != 0 {
+			t.Fatal(typ, it.Err(), len(leaks))
+		}
+		it.Close()
+		for i := 0; i < 10 && len(leaks) == 0; i++ {
+			runtime.GC()
+			time.Sleep(10 * time.Millisecond)
+		}
+		if i := <-leaks; i.Reason != "garbage collected without Close" {
+			t.Fatal(typ, i)
+		}
+		db.Close()
+	}
+}
+
+func TestHistory(t *testing.T) {
+	db := &KVDB{Type: 0, Path: filepath.Join(t.TempDir(), "db"), NumVersionsToKeep: 10}
+	if err := db.Init(); err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	ts := func() uint64 {
+		s, err := db.Snapshot()
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer s.Close()
+		return s.Ts()
+	}
+
+	db.SetKey("k", []byte("1"))
+	db.SetTableRow("t", "a", Row{"f": []byte("1")})
+	ts1 := ts()
+	db.SetKey("k", []byte("2"))
+	db.PatchTableRow("t", "a", Row{"g": []byte("2")}, []string{"f"})
+	ts2 := ts()
+	db.DeleteKey("k")
+	db.DeleteTableRow("t", "a")
+
+	kh, err := db.KeyHistory("k")
+	if err != nil || len(kh) != 3 || !kh[0].Deleted || string(kh[1].Value) != "2" || string(kh[2].Value) != "1" || kh[1].Ts <= kh[2].Ts {
+		t.Fatal(kh, err)
+	}
+	rh, err := db.RowHistory("t", "a")
+	if err != nil || len(rh) != 3 {
+		t.Fatal(rh, err)
+	}
+	if rh[0].Row != nil || !reflect.DeepEqual(rh[1].Row, Row{"g": []byte("2")}) || !reflect.DeepEqual(rh[2].Row, Row{"f": []byte("1")}) {
+		t.Fatal(rh)
+	}
+	if rh[1].Meta.Version <= rh[2].Meta.Version || rh[2].Ts > ts1 || rh[1].Ts > ts2 {
+		t.Fatal(rh, ts1, ts2)
+	}
+	// ts1 时的值是 Ts <= ts1 的最新版本
+	if kh[2].Ts > ts1 || kh[1].Ts <= ts1 || kh[1].Ts > ts2 || kh[0].Ts <= ts2 {
+		t.Fatal(kh, ts1, ts2)
+	}
+
+	// 读取之前的版本
+	sn, err := db.Snapshot()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sn.Close()
+	db.SetKey("k", []byte("3"))
+	db.SetTableRow("t", "a", Row{"f": []byte("3")})
+	for _, c := range []struct {
+		ts  uint64
+		key string
+		row Row
+	}{{ts1, "1", Row{"f": []byte("1")}}, {ts2, "2", Row{"g": []byte("2")}}, {sn.Ts(), "", nil}} {
+		h, err := db.ReadAt(c.ts)
+		if err != nil {
+			t.Fatal(err)
+		}
+		hs, err := sn.ReadAt(c.ts)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, h := range []*HistoryView{h, hs} {
+			k, err := h.ReadKey("k")
+			if err != nil || string(k) != c.key || (c.key == "") != (k == nil) {
+				t.Fatal(c.ts, k, err)
+			}
+			if r, err := h.ReadTableRow("t", "a"); err != nil || !reflect.DeepEqual(r, c.row) {
+				t.Fatal(c.ts, r, err)
+			}
+		}
+	}
+	if h, err := sn.ReadAt(sn.Ts() + 100); err != nil {
+		t.Fatal(err)
+	} else if k, _ := h.ReadKey("k"); k != nil { // 快照之后的写入不可见
+		t.Fatal(k)
+	}
+	if string(db.ReadKey("k")) != "3" {
+		t.Fatal("ReadAt changed the db's reads")
+	}
+
+	bt := openTest(t, 1)
+	if _, err = bt.RowHistory("t", "a"); err != ErrNoHistory {
+		t.Fatal(err)
+	}
+	if _, err = bt.ReadAt(1); err != ErrNoHistory {
+		t.Fatal(err)
+	}
+}
+
+func TestSoftDelete(t *testing.T) {
+	for _, typ := range []uint8{0, 1, 2} {
+		db := &KVDB{Type: typ, Path: filepath.Join(t.TempDir(), "db"), SoftDelete: true, CacheSize: 1 << 20}
+		if err := db.Init(); err != nil {
+			t.Fatal(typ, err)
+		}
+		db.SetKey("k", []byte("1"))
+		db.SetTable("t", map[string]map[string][]byte{"a": {"f": []byte("1")}, "b": {"f": []byte("2")}})
+		db.SetTable("u", map[string]map[string][]byte{"x": {"f": []byte("3")}})
+		_, meta := db.ReadTableRowMeta("t", "a")
+		db.ReadTableRow("t", "a") // 缓存
+
+		if err := db.DeleteKey("k"); err != nil {
+			t.Fatal(typ, err)
+		}
+		db.DeleteTableRow("t", "a")
+		db.DeleteTable("u")
+		db.DeleteKey("missing")
+		if err := db.DeleteTable("missing"); err != ErrTableNotExist {
+			t.Fatal(typ, err)
+		}
+		if db.ReadKey("k") != nil || len(db.ReadTableRow("t", "a")) != 0 || db.ReadTableExist("u") {
+			t.Fatal(typ, "not deleted")
+		}
+		if tables := db.ListTables(); !reflect.DeepEqual(tables, []string{"t"}) {
+			t.Fatal(typ, tables)
+		}
+
+		items, err := db.ListTrash()
+		if err != nil || len(items) != 3 {
+			t.Fatal(typ, items, err)
+		}
+		if items[0].Kind != TrashKey || items[0].Key != "k" || items[1].Kind != TrashRow || items[1].Table != "t" || items[1].RowID != "a" ||
+			items[2].Kind != TrashTable || items[2].Table != "u" || time.Since(items[0].DeletedAt) > time.Minute {
+			t.Fatal(typ, items)
+		}
+
+		if err = db.Restore(items[1].ID); err != nil {
+			t.Fatal(typ, err)
+		}
+		if r, m := db.ReadTableRowMeta("t", "a"); string(r["f"]) != "1" || m != meta || string(db.ReadTableValue("t", "a", "f")) != "1" {
+			t.Fatal(typ, r, m)
+		}
+		if err = db.Restore(items[2].ID); err != nil || db.CountRows("u") != 1 {
+			t.Fatal(typ, err)
+		}
+		if err = db.Restore(items[2].ID); err != ErrTrashNotExist {
+			t.Fatal(typ, err)
+		}
+		db.SetKey("k", []byte("2"))
+		if err = db.Restore(items[0].ID); err != ErrRestoreExist {
+			t.Fatal(typ, err)
+		}
+
+		// 清空后的空表
+		db.TruncateTable("u")
+		if err = db.DeleteTable("u"); err != nil || db.ReadTableExist("u") {
+			t.Fatal(typ, "empty table isn't deleted", err)
+		}
+		items, _ = db.ListTrash()
+		if err = db.Restore(items[len(items)-1].ID); err != nil || !db.ReadTableExist("u") || db.CountRows("u") != 0 {
+			t.Fatal(typ, "empty table isn't restored", err)
+		}
+
+		if n, err := db.PurgeTrash(time.Now()); err != nil || n != 1 {
+			t.Fatal(typ, n, err)
+		}
+		if items, _ = db.ListTrash(); len(items) != 0 {
+			t.Fatal(typ, items)
+		}
+		if typ == 1 {
+			if err = db.SetTableRow("\x00kvdb", "a", Row{"f": nil}); err == nil {
+				t.Fatal("the reserved table is written")
+			}
+		}
+		db.Close()
+
+		// 定时清除
+		db = &KVDB{Type: typ, Path: filepath.Join(t.TempDir(), "db"), SoftDelete: true, TrashRetention: 20 * time.Millisecond}
+		if err := db.Init(); err != nil {
+			t.Fatal(typ, err)
+		}
+		db.SetKey("k", []byte("1"))
+		db.DeleteKey("k")
+		for i := 0; ; i++ {
+			if items, _ = db.ListTrash(); len(items) == 0 {
+				break
+			} else if i > 100 {
+				t.Fatal(typ, "trash isn't purged")
+			}
+			time.Sleep(20 * time.Millisecond)
+		}
+		db.Close()
+	}
+}
+
+func TestMaintain(t *testing.T) {
+	db := &KVDB{Type: 0, Path: filepath.Join(t.TempDir(), "db"), GCInterval: 10 * time.Millisecond, Flatten: true}
+	if err := db.Init(); err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	for i := 0; i < 100; i++ {
+		db.SetKey("k", bytes.Repeat([]byte{byte(i)}, 4096))
+	}
+
+	db.PauseGC()
+	time.Sleep(50 * time.Millisecond) // 等待进行中的维护
+	s := db.GCStats()
+	if err := db.Maintain(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := db.Maintain(ctx); err != context.Canceled {
+		t.Fatal(err)
+	}
+	time.Sleep(50 * time.Millisecond)
+	if g := db.GCStats(); g.Runs != s.Runs+2 || g.LastErr != context.Canceled || g.LastRun.IsZero() {
+		t.Fatal("paused", s, g)
+	}
+
+	db.ResumeGC()
+	for i := 0; db.GCStats().Runs <= s.Runs+2; i++ {
+		if i > 100 {
+			t.Fatal("background maintenance isn't running")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if string(db.ReadKey("k")) != string(bytes.Repeat([]byte{99}, 4096)) {
+		t.Fatal("value is lost")
+	}
+
+	bt := openTest(t, 1)
+	if err := bt.Maintain(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	// memdb 后台删除过期的值
+	mm := &KVDB{Type: 2, GCInterval: 50 * time.Millisecond}
+	if err := mm.Init(); err != nil {
+		t.Fatal(err)
+	}
+	defer mm.Close()
+	mm.SetKey("k", []byte("1"))
+	mm.SetKey("e", []byte("1"), time.Second)
+	mm.SetTableRow("t", "a", Row{"f": []byte("1")}, time.Second)
+	if r, _ := mm.Check(); r.Keys != 3 {
+		t.Fatal(r)
+	}
+	for i := 0; ; i++ {
+		if r, _ := mm.Check(); r.Keys == 1 && r.Rows == 0 {
+			break
+		} else if i > 60 {
+			t.Fatal("expired values aren't removed", r)
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+}
+
+func TestCompact(t *testing.T) {
+	db := openTest(t, 1)
+	for i := 0; i < 2000; i++ {
+		db.SetKey(strconv.Itoa(i), bytes.Repeat([]byte{byte(i)}, 4096))
+	}
+	if err := db.SetTable("t", map[string]map[string][]byte{"1": {"a": []byte("1")}, "2": {"a": []byte("2")}}); err != nil {
+		t.Fatal(err)
+	}
+	for i := 10; i < 2000; i++ {
+		db.DeleteKey(strconv.Itoa(i))
+	}
+
+	sn, err := db.Snapshot()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.Compact(""); err != ErrCompactBusy {
+		t.Fatal(err)
+	}
+	sn.Close()
+
+	s, err := db.Compact("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s.SizeAfter >= s.SizeBefore || s.Keys == 0 {
+		t.Fatal("not compacted", s)
+	}
+	if _, err := os.Stat(db.Path + ".compact"); !os.IsNotExist(err) {
+		t.Fatal("temporary file is left", err)
+	}
+	for i := 0; i < 10; i++ {
+		if !bytes.Equal(db.ReadKey(strconv.Itoa(i)), bytes.Repeat([]byte{byte(i)}, 4096)) {
+			t.Fatal("key is lost", i)
+		}
+	}
+	if db.ReadKey("10") != nil || string(db.ReadTableValue("t", "2", "a")) != "2" {
+		t.Fatal("compacted data")
+	}
+	if err := db.SetKey("new", []byte("v")); err != nil || string(db.ReadKey("new")) != "v" {
+		t.Fatal("write after compact", err)
+	}
+
+	// 复制时写入不阻塞，也不丢失
+	var stop = make(chan struct{})
+	var written = make(chan int)
+	go func() {
+		var n int
+		for ; ; n++ {
+			select {
+			case <-stop:
+				written <- n
+				return
+			default:
+			}
+			if err := db.SetKey("w"+strconv.Itoa(n), []byte("v")); err != nil {
+				t.Error(err)
+			}
+		}
+	}()
+	time.Sleep(10 * time.Millisecond)
+	if _, err = db.Compact(""); err != nil {
+		t.Fatal(err)
+	}
+	close(stop)
+	n := <-written
+	for i := 0; i < n; i++ {
+		if db.ReadKey("w"+strconv.Itoa(i)) == nil {
+			t.Fatal("write during compact is lost", i, n)
+		}
+	}
+
+	if _, err := openTest(t, 0).Compact(""); err == nil {
+		t.Fatal("compact badgerdb")
+	}
+}
+
+func TestCheck(t *testing.T) {
+	for _, typ := range []uint8{0, 1} {
+		db := openTest(t, typ)
+		db.SoftDelete = true
+		db.SetKey("k", []byte("v"))
+		db.SetKey("big", bytes.Repeat([]byte("v"), 64<<10)) // badgerdb 的值在value log中
+		db.SetTable("t", map[string]map[string][]byte{"1": {"a": []byte("1")}, "2": {"a": []byte("2")}})
+		if err := db.DeleteTableRow("t", "2"); err != nil {
+			t.Fatal(typ, err)
+		}
+		r, err := db.Check()
+		if err != nil {
+			t.Fatal(typ, err)
+		} else if !r.OK() || r.Tables != 1 || r.Rows != 1 {
+			t.Fatal(typ, r)
+		}
+
+		// 写入kvdb不能解析的键
+		if typ == 0 {
+			err = db.DH.bg.DbHandle.Update(func(txn *badger.Txn) error {
+				return txn.Set([]byte{0x02, 0x02, 't', 0x00, 0x01, 'x'}, []byte("v"))
+			})
+		} else {
+			err = db.DH.bt.DbHandle.Update(func(tx *bolt.Tx) error {
+				return tx.Bucket([]byte("t")).Put([]byte("x"), []byte("v"))
+			})
+		}
+		if err != nil {
+			t.Fatal(typ, err)
+		}
+		if r, err = db.Check(); err != nil {
+			t.Fatal(typ, err)
+		} else if r.OK() || r.Issues[0].Kind != IssueKey {
+			t.Fatal(typ, r)
+		}
+		if string(db.ReadTableValue("t", "1", "a")) != "1" {
+			t.Fatal(typ, "read")
+		}
+	}
+}
+
+// TestCheckValueLog a corrupted value in badger's value log
+func TestCheckValueLog(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "db")
+	db := &KVDB{Type: 0, Path: path}
+	if err := db.Init(); err != nil {
+		t.Fatal(err)
+	}
+	db.SetKey("big", bytes.Repeat([]byte("v"), 64<<10))
+	db.Close()
+
+	// 值日志是加密的，改写值所在的文件中间的一个字节
+	files, _ := filepath.Glob(filepath.Join(path, "*.vlog"))
+	var f string
+	var b []byte
+	for _, name := range files {
+		if r, err := ioutil.ReadFile(name); err != nil {
+			t.Fatal(err)
+		} else if len(r) > len(b) {
+			f, b = name, r
+		}
+	}
+	if len(b) < 64<<10 {
+		t.Fatal("value isn't in the value log", files)
+	}
+	b[len(b)/2] ^= 0xff
+	if err := ioutil.WriteFile(f, b, 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	db = &KVDB{Type: 0, Path: path}
+	if err := db.Init(); err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	if r, err := db.Check(); err != nil {
+		t.Fatal(err)
+	} else if r.OK() || r.Issues[0].Kind != IssueStorage {
+		t.Fatal(r)
+	}
+}
+
+func TestBackup(t *testing.T) {
+	for _, typ := range []uint8{0, 1, 2} {
+		db := openTest(t, typ)
+		bd := &BackupDir{Dir: filepath.Join(t.TempDir(), "backups"), Keep: 1}
+		db.SetKey("k", []byte("1"))
+		db.SetTable("t", map[string]map[string][]byte{"1": {"a": []byte("1")}, "2": {"a": []byte("2")}})
+		if m, err := bd.Backup(db, false); err != nil || !m.Full || m.Size == 0 {
+			t.Fatal(typ, m, err)
+		}
+		db.SetKey("k", []byte("2"))
+		db.DeleteTableRow("t", "2")
+		if m, err := bd.Backup(db, false); err != nil || m.Full != (typ != 0) {
+			t.Fatal(typ, m, err)
+		}
+
+		target := openTest(t, typ)
+		target.SetKey("k", []byte("x"))
+		target.SetKey("extra", []byte("x"))
+		target.SetTable("t", map[string]map[string][]byte{"2": {"a": []byte("x")}, "9": {"a": []byte("x")}})
+		target.ReadKey("extra") // 缓存
+		if err := bd.Restore(target); err != nil {
+			t.Fatal(typ, err)
+		}
+		if string(target.ReadKey("k")) != "2" || target.ReadTableRowExist("t", "2") || string(target.ReadTableValue("t", "1", "a")) != "1" {
+			t.Fatal(typ, "restored data")
+		}
+		if target.ReadKey("extra") != nil || target.ReadTableRowExist("t", "9") {
+			t.Fatal(typ, "data not in the backup is kept")
+		}
+		if err := target.SetTableRow("t", "3", Row{"a": []byte("3")}); err != nil {
+			t.Fatal(typ, err)
+		}
+		if _, m := target.ReadTableRowMeta("t", "3"); m.Version == 0 {
+			t.Fatal(typ, m)
+		}
+
+		// 只保留最后一个全量备份
+		m, err := bd.Backup(db, true)
+		if err != nil {
+			t.Fatal(typ, err)
+		}
+		if ms, err := bd.List(); err != nil || len(ms) != 1 || ms[0].File != m.File {
+			t.Fatal(typ, ms, err)
+		}
+		if files, _ := filepath.Glob(filepath.Join(bd.Dir, "*.backup")); len(files) != 1 {
+			t.Fatal(typ, files)
+		}
+
+		f, err := os.OpenFile(filepath.Join(bd.Dir, m.File), os.O_WRONLY|os.O_APPEND, 0)
+		if err != nil {
+			t.Fatal(typ, err)
+		}
+		f.Write([]byte{0})
+		f.Close()
+		if err := bd.Restore(openTest(t, typ)); !errors.Is(err, ErrBackupChecksum) {
+			t.Fatal(typ, err)
+		}
+	}
+}
+
+func TestRAMDump(t *testing.T) {
+	dir := t.TempDir()
+	dump := filepath.Join(dir, "ram.dump")
+	open := func() *KVDB {
+		db := &KVDB{Type: 0, Path: filepath.Join(dir, "db"), RAMMode: true, RAMDumpPath: dump, RAMDumpInterval: 10 * time.Millisecond}
+		if err := db.Init(); err != nil {
+			t.Fatal(err)
+		}
+		return db
+	}
+
+	db := open()
+	db.SetKey("k", []byte("1"))
+	for i := 0; ; i++ {
+		if _, err := os.Stat(dump); err == nil {
+			break
+		} else if i > 100 {
+			t.Fatal("not dumped by the interval")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if err := db.DumpErr(); err != nil {
+		t.Fatal(err)
+	}
+	db.SetTable("t", map[string]map[string][]byte{"1": {"a": []byte("1")}})
+	db.Close()
+
+	db = open()
+	defer db.Close()
+	if string(db.ReadKey("k")) != "1" || string(db.ReadTableValue("t", "1", "a")) != "1" {
+		t.Fatal("not reloaded")
+	}
+	if err := db.SetKey("k", []byte("2")); err != nil || string(db.ReadKey("k")) != "2" {
+		t.Fatal("write after reload", err)
+	}
+}
+
+func TestMemDB(t *testing.T) {
+	db := &KVDB{Type: 2, CacheSize: 1 << 20}
+	if err := db.Init(); err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	db.SetKey("k", []byte("1"), time.Second)
+	db.SetKey("kept", []byte("1"))
+	db.SetTableRow("t", "1", Row{"a": []byte("1")}, time.Second)
+	db.SetTableValue("t", "2", "a", []byte("2"), time.Second)
+	db.SetTableValue("t", "2", "b", []byte("2"))
+	if string(db.ReadKey("k")) != "1" || string(db.ReadTableValue("t", "1", "a")) != "1" {
+		t.Fatal("read before expired")
+	}
+	if vs, missing := db.ReadKeys("k", "kept"); len(vs) != 2 || len(missing) != 0 { // 缓存，按过期时间失效
+		t.Fatal(vs, missing)
+	}
+
+	time.Sleep(1100 * time.Millisecond)
+	if db.ReadKey("k") != nil || db.ReadTableRowExist("t", "1") || db.ReadTableValue("t", "2", "a") != nil {
+		t.Fatal("read expired")
+	}
+	if r := db.ReadTableRow("t", "2"); !reflect.DeepEqual(r, Row{"b": []byte("2")}) || db.CountRows("t") != 1 {
+		t.Fatal(r)
+	}
+
+	r, err := db.Check()
+	if err != nil || !r.OK() || r.Keys != 5 || r.Rows != 2 {
+		t.Fatal(r, err)
+	}
+	if err = db.Maintain(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if r, err = db.Check(); err != nil || r.Keys != 2 || r.Rows != 1 {
+		t.Fatal("expired values aren't removed", r, err)
+	}
+}
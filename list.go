@@ -0,0 +1,57 @@
+package kvdb
+
+import "github.com/lysShub/kvdb/com"
+
+// ListOptions options of listing the ids in a table
+type ListOptions = com.ListOptions
+
+// table introspection
+
+// ListTables get all table names, in order
+func (d *KVDB) ListTables() []string {
+	if d.Type == 0 {
+		return d.DH.bg.ListTables()
+	} else if d.Type == 1 {
+		return d.DH.bt.ListTables()
+	} else if d.Type == 2 {
+		return d.DH.mm.ListTables()
+	}
+	return nil
+}
+
+// ListRowIDs get the ids in a table in order, filtered by opts.Prefix and
+// paginated by opts.After and opts.Limit
+func (d *KVDB) ListRowIDs(tableName string, opts ListOptions) []string {
+	if d.Type == 0 {
+		return d.DH.bg.ListRowIDs(tableName, opts)
+	} else if d.Type == 1 {
+		return d.DH.bt.ListRowIDs(tableName, opts)
+	} else if d.Type == 2 {
+		return d.DH.mm.ListRowIDs(tableName, opts)
+	}
+	return nil
+}
+
+// ListFields get all fields in a table, and the count of records that have the field
+func (d *KVDB) ListFields(tableName string) map[string]int {
+	if d.Type == 0 {
+		return d.DH.bg.ListFields(tableName)
+	} else if d.Type == 1 {
+		return d.DH.bt.ListFields(tableName)
+	} else if d.Type == 2 {
+		return d.DH.mm.ListFields(tableName)
+	}
+	return nil
+}
+
+// CountRows get the count of records in a table
+func (d *KVDB) CountRows(tableName string) int {
+	if d.Type == 0 {
+		return d.DH.bg.CountRows(tableName)
+	} else if d.Type == 1 {
+		return d.DH.bt.CountRows(tableName)
+	} else if d.Type == 2 {
+		return d.DH.mm.CountRows(tableName)
+	}
+	return 0
+}
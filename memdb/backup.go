@@ -0,0 +1,157 @@
+package memdb
+
+import (
+	"encoding/gob"
+	"io"
+
+	"github.com/lysShub/kvdb/com"
+)
+
+// backup
+//
+// a backup is a gob stream of the key/values, tables and trash items not
+// expired, written from a snapshot so the writes aren't blocked. Load
+// replace the whole db with it.
+
+// dumpCell a key/value or a field
+type dumpCell struct {
+	Key       string
+	Value     []byte
+	ExpiresAt uint64
+}
+
+type dumpRow struct {
+	ID     string
+	Fields []dumpCell
+	Meta   com.RowMeta
+}
+
+type dumpTable struct {
+	Name string
+	Rows []dumpRow
+}
+
+type dumpTrash struct {
+	Item  com.TrashItem
+	Key   *dumpCell
+	Row   *dumpRow
+	Table *dumpTable
+}
+
+// dump the gob value of a backup
+type dump struct {
+	Keys   []dumpCell
+	Tables []dumpTable
+	Trash  []dumpTrash
+	Seq    uint64 // 行版本的序列
+}
+
+func dumpRowOf(id string, r *row, now uint64) dumpRow {
+	var dr = dumpRow{ID: id, Meta: r.meta}
+	for f, c := range r.fields {
+		if !c.expired(now) {
+			dr.Fields = append(dr.Fields, dumpCell{Key: f, Value: c.value, ExpiresAt: c.expiresAt})
+		}
+	}
+	return dr
+}
+
+func dumpTableOf(name string, t *table, now uint64) dumpTable {
+	var dt = dumpTable{Name: name}
+	t.rows.ascend("", func(id string, v interface{}) bool {
+		if v.(*row).live(now) {
+			dt.Rows = append(dt.Rows, dumpRowOf(id, v.(*row), now))
+		}
+		return true
+	})
+	return dt
+}
+
+func (dr dumpRow) row() *row {
+	var r = &row{fields: make(map[string]cell, len(dr.Fields)), meta: dr.Meta}
+	for _, c := range dr.Fields {
+		r.fields[c.Key] = cell{value: c.Value, expiresAt: c.ExpiresAt}
+	}
+	return r
+}
+
+func (dt dumpTable) table() *table {
+	var t = &table{rows: newOmap()}
+	for _, dr := range dt.Rows {
+		t.rows.set(dr.ID, dr.row())
+	}
+	return t
+}
+
+// Backup write all data to w; the db has no versions, a backup is always full
+func (d *Mem) Backup(w io.Writer) error {
+	d.mu.RLock()
+	st := d.st.clone()
+	d.mu.RUnlock()
+
+	var n = now()
+	var dp = dump{Seq: st.seq}
+	st.keys.ascend("", func(key string, v interface{}) bool {
+		if c := v.(cell); !c.expired(n) {
+			dp.Keys = append(dp.Keys, dumpCell{Key: key, Value: c.value, ExpiresAt: c.expiresAt})
+		}
+		return true
+	})
+	st.tables.ascend("", func(name string, v interface{}) bool {
+		dp.Tables = append(dp.Tables, dumpTableOf(name, v.(*table), n))
+		return true
+	})
+	st.trash.ascend("", func(_ string, v interface{}) bool {
+		e := v.(*trashEntry)
+		if (e.key != nil && e.key.expired(n)) || (e.row != nil && !e.row.live(n)) {
+			return true
+		}
+		var dt = dumpTrash{Item: e.item}
+		if e.key != nil {
+			dt.Key = &dumpCell{Key: e.item.Key, Value: e.key.value, ExpiresAt: e.key.expiresAt}
+		} else if e.row != nil {
+			dr := dumpRowOf(e.item.RowID, e.row, n)
+			dt.Row = &dr
+		} else {
+			tb := dumpTableOf(e.item.Table, e.table, n)
+			dt.Table = &tb
+		}
+		dp.Trash = append(dp.Trash, dt)
+		return true
+	})
+	return gob.NewEncoder(w).Encode(&dp)
+}
+
+// Load replace the db with a backup written by Backup
+func (d *Mem) Load(r io.Reader) error {
+	var dp dump
+	if err := gob.NewDecoder(r).Decode(&dp); err != nil {
+		return err
+	}
+	var st = newStore()
+	st.seq = dp.Seq
+	for _, c := range dp.Keys {
+		st.keys.set(c.Key, cell{value: c.Value, expiresAt: c.ExpiresAt})
+	}
+	for _, dt := range dp.Tables {
+		st.tables.set(dt.Name, dt.table())
+	}
+	for _, dt := range dp.Trash {
+		var e = &trashEntry{item: dt.Item}
+		if dt.Key != nil {
+			e.key = &cell{value: dt.Key.Value, expiresAt: dt.Key.ExpiresAt}
+		} else if dt.Row != nil {
+			e.row = dt.Row.row()
+		} else if dt.Table != nil {
+			e.table = dt.Table.table()
+		} else {
+			continue
+		}
+		st.trash.set(dt.Item.ID, e)
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.st = st
+	return nil
+}
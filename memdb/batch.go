@@ -0,0 +1,32 @@
+package memdb
+
+import (
+	"time"
+
+	"github.com/lysShub/kvdb/com"
+)
+
+// WriteBatch write the ops in order under the lock, so it's always atomic.
+// Deleting a missing record isn't an error
+func (d *Mem) WriteBatch(ops []com.BatchOp, atomic bool) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	for _, op := range ops {
+		var exp = expiresAt([]time.Duration{op.TTL})
+		switch op.Kind {
+		case com.OpSetKey:
+			d.st.keys.set(op.Key, cell{value: copyBytes(op.Value), expiresAt: exp})
+		case com.OpDeleteKey:
+			d.st.keys.delete(op.Key)
+		case com.OpSetRow:
+			d.st.table(op.Table, true).write(&d.st.seq, op.ID, op.Row, nil, false, exp)
+		case com.OpSetValue:
+			d.st.table(op.Table, true).write(&d.st.seq, op.ID, map[string][]byte{op.Field: op.Value}, nil, false, exp)
+		case com.OpDeleteRow:
+			if t := d.st.table(op.Table, false); t != nil {
+				t.rows.delete(op.ID)
+			}
+		}
+	}
+	return nil
+}
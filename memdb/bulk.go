@@ -0,0 +1,36 @@
+package memdb
+
+import (
+	"github.com/lysShub/kvdb/com"
+)
+
+// BulkLoad write records under one lock, the fields are merged into the
+// existing records; the records aren't versioned, same as boltdb
+func (d *Mem) BulkLoad(rows []com.BulkRow) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	var n = now()
+	var t *table
+	var tableName string
+	for _, r := range rows {
+		if t == nil || r.Table != tableName {
+			t, tableName = d.st.table(r.Table, true), r.Table
+		}
+		var nr = &row{fields: make(map[string]cell, len(r.Row))}
+		if old := t.row(r.ID, n); old != nil {
+			nr.meta = old.meta
+			for f, c := range old.fields {
+				if !c.expired(n) {
+					nr.fields[f] = c
+				}
+			}
+		}
+		for f, v := range r.Row {
+			nr.fields[f] = cell{value: copyBytes(v)}
+		}
+		if len(nr.fields) != 0 {
+			t.rows.set(r.ID, nr)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,35 @@
+package memdb
+
+import (
+	"fmt"
+
+	"github.com/lysShub/kvdb/com"
+)
+
+// Check count the key/values, tables and records, and verify the records:
+// a record has fields, and its version isn't after the db's sequence.
+// The expired values are counted until Maintain remove them
+func (d *Mem) Check() (com.CheckReport, error) {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+	var r com.CheckReport
+	r.Keys = d.st.keys.len()
+	d.st.tables.ascend("", func(name string, v interface{}) bool {
+		t := v.(*table)
+		r.Tables++
+		t.rows.ascend("", func(id string, v interface{}) bool {
+			row := v.(*row)
+			r.Rows++
+			r.Keys = r.Keys + len(row.fields)
+			if len(row.fields) == 0 {
+				r.Add(com.IssueKey, []byte(name+"/"+id), "record without field")
+			}
+			if row.meta.Version > d.st.seq {
+				r.Add(com.IssueMeta, []byte(name+"/"+id), fmt.Sprintf("version %d is after the sequence %d", row.meta.Version, d.st.seq))
+			}
+			return true
+		})
+		return true
+	})
+	return r, nil
+}
@@ -0,0 +1,52 @@
+package memdb
+
+import (
+	"bytes"
+	"time"
+
+	"github.com/lysShub/kvdb/com"
+)
+
+// conditional writes, the test and the write are under the lock
+
+// CompareAndSwapKey set the key to new if its value is old, a nil old means
+// the key mustn't exist; otherwise return com.ErrConditionFailed
+func (d *Mem) CompareAndSwapKey(key string, old, new []byte, ttl ...time.Duration) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	c, ok := d.st.key(key, now())
+	if (old == nil) == ok || (ok && !bytes.Equal(c.value, old)) {
+		return com.ErrConditionFailed
+	}
+	d.st.keys.set(key, cell{value: copyBytes(new), expiresAt: expiresAt(ttl)})
+	return nil
+}
+
+// SetTableRowIf set the fields of a record if the record match cond,
+// otherwise return com.ErrConditionFailed
+func (d *Mem) SetTableRowIf(tableName, id string, cond com.Condition, fv map[string][]byte, ttl ...time.Duration) error {
+	_, err := d.setRowIf(tableName, id, func(row map[string][]byte, m com.RowMeta) error {
+		if !cond.Match(row, m.Version) {
+			return com.ErrConditionFailed
+		}
+		return nil
+	}, fv, ttl)
+	return err
+}
+
+// setRowIf set the fields of a record if test return nil, return the new version
+func (d *Mem) setRowIf(tableName, id string, test func(row map[string][]byte, m com.RowMeta) error, fv map[string][]byte, ttl []time.Duration) (uint64, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	var n = now()
+	var r map[string][]byte
+	var m com.RowMeta
+	if old := d.st.table(tableName, false).row(id, n); old != nil {
+		r, _ = old.read(n)
+		m = old.meta
+	}
+	if err := test(r, m); err != nil {
+		return 0, err
+	}
+	return d.st.table(tableName, true).write(&d.st.seq, id, fv, nil, false, expiresAt(ttl)), nil
+}
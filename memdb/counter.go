@@ -0,0 +1,83 @@
+package memdb
+
+import (
+	"github.com/lysShub/kvdb/com"
+)
+
+// counters, an increment is a read-modify-write under the lock, the expire
+// time is kept
+
+func incrInt64(r *int64, delta int64) func(v []byte) ([]byte, error) {
+	return func(v []byte) ([]byte, error) {
+		n, err := com.DecodeInt64(v)
+		*r = n + delta
+		return com.EncodeInt64(*r), err
+	}
+}
+
+func incrFloat64(r *float64, delta float64) func(v []byte) ([]byte, error) {
+	return func(v []byte) ([]byte, error) {
+		f, err := com.DecodeFloat64(v)
+		*r = f + delta
+		return com.EncodeFloat64(*r), err
+	}
+}
+
+// incrKey read-modify-write the value of a key by fn
+func (d *Mem) incrKey(key string, fn func(v []byte) ([]byte, error)) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	c, _ := d.st.key(key, now())
+	v, err := fn(c.value)
+	if err != nil {
+		return err
+	}
+	d.st.keys.set(key, cell{value: v, expiresAt: c.expiresAt})
+	return nil
+}
+
+// incrValue read-modify-write a field by fn, the record is touched
+func (d *Mem) incrValue(tableName, id, field string, fn func(v []byte) ([]byte, error)) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	var n = now()
+	t := d.st.table(tableName, true)
+	var c cell
+	if r := t.row(id, n); r != nil && !r.fields[field].expired(n) {
+		c = r.fields[field]
+	}
+	v, err := fn(c.value)
+	if err != nil {
+		return err
+	}
+	t.write(&d.st.seq, id, map[string][]byte{field: v}, nil, false, c.expiresAt)
+	return nil
+}
+
+// IncrKey add delta to a int64 counter, return the new value
+func (d *Mem) IncrKey(key string, delta int64) (int64, error) {
+	var r int64
+	err := d.incrKey(key, incrInt64(&r, delta))
+	return r, err
+}
+
+// IncrKeyFloat add delta to a float64 counter, return the new value
+func (d *Mem) IncrKeyFloat(key string, delta float64) (float64, error) {
+	var r float64
+	err := d.incrKey(key, incrFloat64(&r, delta))
+	return r, err
+}
+
+// IncrTableValue add delta to a int64 counter field, return the new value
+func (d *Mem) IncrTableValue(tableName, id, field string, delta int64) (int64, error) {
+	var r int64
+	err := d.incrValue(tableName, id, field, incrInt64(&r, delta))
+	return r, err
+}
+
+// IncrTableValueFloat add delta to a float64 counter field, return the new value
+func (d *Mem) IncrTableValueFloat(tableName, id, field string, delta float64) (float64, error) {
+	var r float64
+	err := d.incrValue(tableName, id, field, incrFloat64(&r, delta))
+	return r, err
+}
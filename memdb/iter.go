@@ -0,0 +1,107 @@
+package memdb
+
+import (
+	"sort"
+
+	"github.com/lysShub/kvdb/com"
+)
+
+// RowIterator pull-style iterator of the records in a table, the records
+// are the snapshot when it's created; it doesn't hold the lock
+type RowIterator struct {
+	rows    []rowEntry // in order of id, between the bounds
+	reverse bool
+	pos     int // index of the next record
+
+	id  string
+	row map[string][]byte
+}
+
+// NewRowIterator
+func (d *Mem) NewRowIterator(tableName string, opts com.IterOptions) *RowIterator {
+	r := &RowIterator{rows: d.rows(tableName, opts), reverse: opts.Reverse}
+	if r.reverse {
+		r.pos = len(r.rows) - 1
+	}
+	return r
+}
+
+// Seek move to the first record whose id >= id, or <= id in reverse mode;
+// the bounds are kept
+func (r *RowIterator) Seek(id string) {
+	r.pos = sort.Search(len(r.rows), func(i int) bool { return r.rows[i].id >= id })
+	if r.reverse && (r.pos == len(r.rows) || r.rows[r.pos].id != id) {
+		r.pos--
+	}
+}
+
+// Next move to the next record, return false at the end
+func (r *RowIterator) Next() bool {
+	if r.pos < 0 || r.pos >= len(r.rows) {
+		r.id, r.row = "", nil
+		return false
+	}
+	e := r.rows[r.pos]
+	r.id = e.id
+	r.row, _ = e.r.read(now())
+	if r.reverse {
+		r.pos--
+	} else {
+		r.pos++
+	}
+	return true
+}
+
+// ID id of the current record
+func (r *RowIterator) ID() string {
+	return r.id
+}
+
+// Row fields of the current record
+func (r *RowIterator) Row() map[string][]byte {
+	return r.row
+}
+
+// Err the error that stopped the iteration, always nil
+func (r *RowIterator) Err() error {
+	return nil
+}
+
+// Close release the records
+func (r *RowIterator) Close() {
+	r.rows, r.row = nil, nil
+}
+
+// IterateKeys call fn with every key/value between opts.Start and opts.End in
+// order, stop when fn return error; the key/values are read before the
+// first call, fn can write
+func (d *Mem) IterateKeys(opts com.IterOptions, fn func(key string, value []byte) error) error {
+	type kv struct {
+		key string
+		c   cell
+	}
+	var kvs []kv
+	var n = now()
+	d.mu.RLock()
+	d.st.keys.ascend(opts.Start, func(key string, v interface{}) bool {
+		if opts.End != "" && key >= opts.End {
+			return false
+		}
+		if !v.(cell).expired(n) {
+			kvs = append(kvs, kv{key: key, c: v.(cell)})
+		}
+		return true
+	})
+	d.mu.RUnlock()
+
+	for i := range kvs {
+		e := kvs[i]
+		if opts.Reverse {
+			e = kvs[len(kvs)-1-i]
+		}
+		if err := fn(e.key, copyBytes(e.c.value)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
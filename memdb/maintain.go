@@ -0,0 +1,126 @@
+package memdb
+
+import (
+	"sync"
+	"time"
+)
+
+// maintainer state of the background Maintain
+type maintainer struct {
+	mu     sync.Mutex
+	paused bool
+
+	stop chan struct{}
+	done chan struct{}
+}
+
+func (d *Mem) startMaintain() {
+	d.mt = &maintainer{}
+	if d.GCInterval <= 0 {
+		return
+	}
+	d.mt.stop, d.mt.done = make(chan struct{}), make(chan struct{})
+	go func() {
+		defer close(d.mt.done)
+		t := time.NewTicker(d.GCInterval)
+		defer t.Stop()
+		for {
+			select {
+			case <-d.mt.stop:
+				return
+			case <-t.C:
+				if !d.gcPaused() {
+					d.Maintain()
+				}
+			}
+		}
+	}()
+}
+
+func (d *Mem) stopMaintain() {
+	if d.mt != nil && d.mt.stop != nil {
+		close(d.mt.stop)
+		<-d.mt.done
+		d.mt.stop = nil
+	}
+}
+
+// PauseGC pause the background Maintain
+func (d *Mem) PauseGC() {
+	d.mt.mu.Lock()
+	d.mt.paused = true
+	d.mt.mu.Unlock()
+}
+
+// ResumeGC resume the background Maintain
+func (d *Mem) ResumeGC() {
+	d.mt.mu.Lock()
+	d.mt.paused = false
+	d.mt.mu.Unlock()
+}
+
+func (d *Mem) gcPaused() bool {
+	d.mt.mu.Lock()
+	defer d.mt.mu.Unlock()
+	return d.mt.paused
+}
+
+// Maintain remove the expired values, and the records whose fields are all
+// expired; the readers skip them before. Return the count of removed values
+func (d *Mem) Maintain() int {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	var n = now()
+	var keys []string
+	d.st.keys.ascend("", func(key string, v interface{}) bool {
+		if v.(cell).expired(n) {
+			keys = append(keys, key)
+		}
+		return true
+	})
+	for _, key := range keys {
+		d.st.keys.delete(key)
+	}
+	var removed = len(keys)
+
+	d.st.tables.ascend("", func(_ string, v interface{}) bool {
+		t := v.(*table)
+		var ids []string
+		var rows []*row // 替换的行，nil是删除
+		t.rows.ascend("", func(id string, v interface{}) bool {
+			r := v.(*row)
+			var fields map[string]cell
+			for f, c := range r.fields {
+				if !c.expired(n) {
+					continue
+				} else if fields == nil {
+					fields = make(map[string]cell, len(r.fields))
+					for f, c := range r.fields {
+						fields[f] = c
+					}
+				}
+				delete(fields, f)
+				removed++
+			}
+			if fields == nil {
+				return true
+			}
+			ids = append(ids, id)
+			if len(fields) == 0 {
+				rows = append(rows, nil)
+			} else {
+				rows = append(rows, &row{fields: fields, meta: r.meta})
+			}
+			return true
+		})
+		for i, id := range ids {
+			if rows[i] == nil {
+				t.rows.delete(id)
+			} else {
+				t.rows.set(id, rows[i])
+			}
+		}
+		return true
+	})
+	return removed
+}
@@ -0,0 +1,470 @@
+package memdb
+
+import (
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/lysShub/kvdb/com"
+)
+
+// Mem in-memory db, the key/values and tables are kept in ordered maps,
+// nothing is written to disk. Every write is done under one lock, so a
+// write(batch, conditional write, increment) is atomic; the values have ttl
+// and version of records as badgerdb.
+//
+// the records and values are never changed after written, a write replace
+// them, so the reads can use them after unlock.
+type Mem struct {
+	Progress   com.Progress  //表操作完成后的进度回调，可以为nil；删除、清空、重命名和复制报告行数
+	GCInterval time.Duration //后台Maintain的间隔，默认0不运行
+
+	mu *sync.RWMutex
+	st *store
+	mt *maintainer
+}
+
+// cell a value and its expire time
+type cell struct {
+	value     []byte
+	expiresAt uint64 // unix seconds, 0 is never expire
+}
+
+func (c cell) expired(now uint64) bool {
+	return c.expiresAt != 0 && c.expiresAt <= now
+}
+
+// row a record, it's replaced on write
+type row struct {
+	fields map[string]cell
+	meta   com.RowMeta
+}
+
+// table records of a table
+type table struct {
+	rows *omap // id -> *row
+}
+
+type store struct {
+	keys   *omap  // key -> cell
+	tables *omap  // table name -> *table
+	trash  *omap  // trash id -> *trashEntry
+	seq    uint64 // 最后的行版本，删除行、表后不回退
+}
+
+func newStore() *store {
+	return &store{keys: newOmap(), tables: newOmap(), trash: newOmap()}
+}
+
+func newTable() *table {
+	return &table{rows: newOmap()}
+}
+
+func now() uint64 {
+	return uint64(time.Now().Unix())
+}
+
+// expiresAt expire time of a write with ttl, same as badger's WithTTL
+func expiresAt(ttl []time.Duration) uint64 {
+	if len(ttl) > 0 && ttl[0] > 0 {
+		return uint64(time.Now().Add(ttl[0]).Unix())
+	}
+	return 0
+}
+
+// copyBytes the values are copied in and out, the callers can reuse them
+func copyBytes(v []byte) []byte {
+	if v == nil {
+		return nil
+	}
+	r := make([]byte, len(v))
+	copy(r, v)
+	return r
+}
+
+// OpenDb open
+func (d *Mem) OpenDb() error {
+	d.mu = &sync.RWMutex{}
+	d.st = newStore()
+	d.startMaintain()
+	return nil
+}
+
+// Close drop all data
+func (d *Mem) Close() error {
+	d.stopMaintain()
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.st = newStore()
+	return nil
+}
+
+// table the table, created if create; nil if it isn't exist
+func (s *store) table(tableName string, create bool) *table {
+	if v, ok := s.tables.get(tableName); ok {
+		return v.(*table)
+	} else if !create {
+		return nil
+	}
+	t := newTable()
+	s.tables.set(tableName, t)
+	return t
+}
+
+// row the record, nil if it isn't exist or all its fields are expired
+func (t *table) row(id string, now uint64) *row {
+	if t == nil {
+		return nil
+	}
+	v, ok := t.rows.get(id)
+	if !ok || !v.(*row).live(now) {
+		return nil
+	}
+	return v.(*row)
+}
+
+// live the record has any field not expired
+func (r *row) live(now uint64) bool {
+	for _, c := range r.fields {
+		if !c.expired(now) {
+			return true
+		}
+	}
+	return false
+}
+
+// read copy of the fields not expired and the earliest expire time of them
+func (r *row) read(now uint64) (map[string][]byte, uint64) {
+	var fv = make(map[string][]byte, len(r.fields))
+	var exp uint64
+	for f, c := range r.fields {
+		if c.expired(now) {
+			continue
+		}
+		fv[f] = copyBytes(c.value)
+		if c.expiresAt != 0 && (exp == 0 || c.expiresAt < exp) {
+			exp = c.expiresAt
+		}
+	}
+	return fv, exp
+}
+
+// write set the fields in set with expire time exp, delete the fields in
+// unset, or all fields not in set if replace; a record without field is
+// deleted, same as badgerdb. The version is taken from seq, the store's
+// sequence. Return the new version, 0 if it's deleted
+func (t *table) write(seq *uint64, id string, set map[string][]byte, unset []string, replace bool, exp uint64) uint64 {
+	var n = now()
+	var fields = make(map[string]cell)
+	if old := t.row(id, n); old != nil && !replace {
+		for f, c := range old.fields {
+			if !c.expired(n) {
+				fields[f] = c
+			}
+		}
+	}
+	for _, f := range unset {
+		delete(fields, f)
+	}
+	for f, v := range set {
+		fields[f] = cell{value: copyBytes(v), expiresAt: exp}
+	}
+	if len(fields) == 0 {
+		t.rows.delete(id)
+		return 0
+	}
+	*seq++
+	t.rows.set(id, &row{fields: fields, meta: com.RowMeta{Version: *seq, UpdatedAt: time.Now()}})
+	return *seq
+}
+
+// key/value
+
+// SetKey set or updata key/value
+func (d *Mem) SetKey(key string, value []byte, ttl ...time.Duration) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.st.keys.set(key, cell{value: copyBytes(value), expiresAt: expiresAt(ttl)})
+	return nil
+}
+
+// DeleteKey delete key
+func (d *Mem) DeleteKey(key string) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.st.keys.delete(key)
+	return nil
+}
+
+// key the cell of a key, false if it isn't exist or is expired
+func (s *store) key(key string, now uint64) (cell, bool) {
+	v, ok := s.keys.get(key)
+	if !ok || v.(cell).expired(now) {
+		return cell{}, false
+	}
+	return v.(cell), true
+}
+
+// ReadKey
+func (d *Mem) ReadKey(key string) []byte {
+	v, _ := d.ReadKeyExpires(key)
+	return v
+}
+
+// ReadKeyExpires read key and its expire time(unix seconds, 0 is never expire)
+func (d *Mem) ReadKeyExpires(key string) ([]byte, uint64) {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+	c, ok := d.st.key(key, now())
+	if !ok {
+		return nil, 0
+	}
+	return copyBytes(c.value), c.expiresAt
+}
+
+// table
+
+// SetTable
+func (d *Mem) SetTable(tableName string, p map[string]map[string][]byte, ttl ...time.Duration) error {
+	var exp = expiresAt(ttl)
+	var done int
+	d.mu.Lock()
+	if len(p) != 0 {
+		t := d.st.table(tableName, true)
+		for id, fv := range p {
+			t.write(&d.st.seq, id, fv, nil, false, exp)
+			done = done + len(fv)
+		}
+	}
+	d.mu.Unlock()
+	if d.Progress != nil {
+		d.Progress("set", tableName, done)
+	}
+	return nil
+}
+
+// SetTableRow
+func (d *Mem) SetTableRow(tableName, id string, fv map[string][]byte, ttl ...time.Duration) error {
+	return d.PatchTableRow(tableName, id, fv, nil, ttl...)
+}
+
+// SetTableValue
+func (d *Mem) SetTableValue(tableName, id, field string, value []byte, ttl ...time.Duration) error {
+	return d.PatchTableRow(tableName, id, map[string][]byte{field: value}, nil, ttl...)
+}
+
+// ReplaceTableRow set the record to fv, the fields not in fv are deleted
+func (d *Mem) ReplaceTableRow(tableName, id string, fv map[string][]byte, ttl ...time.Duration) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if t := d.st.table(tableName, len(fv) != 0); t != nil {
+		t.write(&d.st.seq, id, fv, nil, true, expiresAt(ttl))
+	}
+	return nil
+}
+
+// PatchTableRow set the fields in set and delete the fields in unset
+func (d *Mem) PatchTableRow(tableName, id string, set map[string][]byte, unset []string, ttl ...time.Duration) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if t := d.st.table(tableName, len(set) != 0); t != nil {
+		t.write(&d.st.seq, id, set, unset, false, expiresAt(ttl))
+	}
+	return nil
+}
+
+// DeleteTableValue delete a field of a record
+func (d *Mem) DeleteTableValue(tableName, id, field string) error {
+	return d.PatchTableRow(tableName, id, nil, []string{field})
+}
+
+// DeleteTable
+func (d *Mem) DeleteTable(tableName string) error {
+	d.mu.Lock()
+	t := d.st.table(tableName, false)
+	if t == nil {
+		d.mu.Unlock()
+		return com.ErrTableNotExist
+	}
+	d.st.tables.delete(tableName)
+	d.mu.Unlock()
+	if d.Progress != nil {
+		d.Progress("delete", tableName, t.rows.len())
+	}
+	return nil
+}
+
+// DeleteTableRow
+func (d *Mem) DeleteTableRow(tableName, id string) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if t := d.st.table(tableName, false); t != nil {
+		t.rows.delete(id)
+	}
+	return nil
+}
+
+// ReadTable
+func (d *Mem) ReadTable(tableName string) map[string]map[string][]byte {
+	var r map[string]map[string][]byte = make(map[string]map[string][]byte)
+	_ = d.IterateTable(tableName, func(id string, row map[string][]byte) error {
+		r[id] = row
+		return nil
+	})
+	return r
+}
+
+// ReadTableExist
+func (d *Mem) ReadTableExist(tableName string) bool {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+	return d.st.table(tableName, false) != nil
+}
+
+// ReadTableRow
+func (d *Mem) ReadTableRow(tableName, id string) map[string][]byte {
+	r, _ := d.ReadTableRowExpires(tableName, id)
+	return r
+}
+
+// ReadTableRowExpires read a record and the earliest expire time of its fields
+func (d *Mem) ReadTableRowExpires(tableName, id string) (map[string][]byte, uint64) {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+	var n = now()
+	if r := d.st.table(tableName, false).row(id, n); r != nil {
+		return r.read(n)
+	}
+	return nil, 0
+}
+
+// ReadTableRowExist
+func (d *Mem) ReadTableRowExist(tableName, id string) bool {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+	return d.st.table(tableName, false).row(id, now()) != nil
+}
+
+// ReadTableValue
+func (d *Mem) ReadTableValue(tableName, id, field string) []byte {
+	v, _ := d.ReadTableValueExpires(tableName, id, field)
+	return v
+}
+
+// ReadTableValueExpires read a field and its expire time
+func (d *Mem) ReadTableValueExpires(tableName, id, field string) ([]byte, uint64) {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+	var n = now()
+	if r := d.st.table(tableName, false).row(id, n); r != nil {
+		if c, ok := r.fields[field]; ok && !c.expired(n) {
+			return copyBytes(c.value), c.expiresAt
+		}
+	}
+	return nil, 0
+}
+
+// ReadTableLimits
+func (d *Mem) ReadTableLimits(tableName, field, exp string, value int) []string {
+	var r []string
+	var n = now()
+	for _, e := range d.rows(tableName, com.IterOptions{}) {
+		c, ok := e.r.fields[field]
+		if !ok || c.expired(n) {
+			continue
+		}
+		fag, err := com.ExpressionCalculate(exp, value, c.value)
+		if err != nil {
+			return nil
+		} else if fag {
+			r = append(r, e.id)
+		}
+	}
+	return r
+}
+
+// rowEntry a record of a table, read under the lock
+type rowEntry struct {
+	id string
+	r  *row
+}
+
+// rows the records not expired between opts.Start and opts.End, in order of
+// id; opts.Reverse is ignored
+func (d *Mem) rows(tableName string, opts com.IterOptions) []rowEntry {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+	var r []rowEntry
+	var n = now()
+	if t := d.st.table(tableName, false); t != nil {
+		t.rows.ascend(opts.Start, func(id string, v interface{}) bool {
+			if opts.End != "" && id >= opts.End {
+				return false
+			}
+			if v.(*row).live(n) {
+				r = append(r, rowEntry{id: id, r: v.(*row)})
+			}
+			return true
+		})
+	}
+	return r
+}
+
+// introspection
+
+// ListTables all table names, in order
+func (d *Mem) ListTables() []string {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+	var r []string = make([]string, 0, d.st.tables.len())
+	d.st.tables.ascend("", func(name string, _ interface{}) bool {
+		r = append(r, name)
+		return true
+	})
+	return r
+}
+
+// ListRowIDs ids in a table, in order
+func (d *Mem) ListRowIDs(tableName string, opts com.ListOptions) []string {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+	var r []string = []string{}
+	var n = now()
+	t := d.st.table(tableName, false)
+	if t == nil {
+		return r
+	}
+	var start = opts.Prefix
+	if opts.After != "" && opts.After >= opts.Prefix {
+		start = opts.After
+	}
+	t.rows.ascend(start, func(id string, v interface{}) bool {
+		if !strings.HasPrefix(id, opts.Prefix) || (opts.Limit > 0 && len(r) >= opts.Limit) {
+			return false
+		}
+		if id != opts.After && v.(*row).live(n) {
+			r = append(r, id)
+		}
+		return true
+	})
+	return r
+}
+
+// ListFields all fields in a table and the count of records having it
+func (d *Mem) ListFields(tableName string) map[string]int {
+	var r map[string]int = make(map[string]int)
+	var n = now()
+	for _, e := range d.rows(tableName, com.IterOptions{}) {
+		for f, c := range e.r.fields {
+			if !c.expired(n) {
+				r[f]++
+			}
+		}
+	}
+	return r
+}
+
+// CountRows count of records in a table
+func (d *Mem) CountRows(tableName string) int {
+	return len(d.rows(tableName, com.IterOptions{}))
+}
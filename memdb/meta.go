@@ -0,0 +1,37 @@
+package memdb
+
+import (
+	"time"
+
+	"github.com/lysShub/kvdb/com"
+)
+
+// row meta
+//
+// every written record keep its version and update time, the version is
+// taken from the db's sequence, so it never go back even if the record or
+// its table is deleted and created again.
+
+// ReadTableRowMeta read a record and its meta
+func (d *Mem) ReadTableRowMeta(tableName, id string) (map[string][]byte, com.RowMeta) {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+	var n = now()
+	if r := d.st.table(tableName, false).row(id, n); r != nil {
+		fv, _ := r.read(n)
+		return fv, r.meta
+	}
+	return nil, com.RowMeta{}
+}
+
+// UpdateRow set the fields of a record if its version is version(0 is a
+// new or not versioned record), otherwise return com.ErrVersionConflict;
+// return the new version
+func (d *Mem) UpdateRow(tableName, id string, version uint64, fv map[string][]byte, ttl ...time.Duration) (uint64, error) {
+	return d.setRowIf(tableName, id, func(row map[string][]byte, m com.RowMeta) error {
+		if m.Version != version {
+			return com.ErrVersionConflict
+		}
+		return nil
+	}, fv, ttl)
+}
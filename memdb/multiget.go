@@ -0,0 +1,33 @@
+package memdb
+
+// multi-get, read under one lock, the results are in order of the input
+
+// ReadKeys read values and their expire time, found report the key is exist
+func (d *Mem) ReadKeys(keys []string) (values [][]byte, expiresAt []uint64, found []bool) {
+	values, expiresAt, found = make([][]byte, len(keys)), make([]uint64, len(keys)), make([]bool, len(keys))
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+	var n = now()
+	for i, key := range keys {
+		if c, ok := d.st.key(key, n); ok {
+			values[i], expiresAt[i], found[i] = copyBytes(c.value), c.expiresAt, true
+		}
+	}
+	return values, expiresAt, found
+}
+
+// ReadTableRows read records and the earliest expire time of their fields,
+// a missing record is nil
+func (d *Mem) ReadTableRows(tableName string, ids []string) ([]map[string][]byte, []uint64) {
+	var rows, expiresAt = make([]map[string][]byte, len(ids)), make([]uint64, len(ids))
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+	var n = now()
+	t := d.st.table(tableName, false)
+	for i, id := range ids {
+		if r := t.row(id, n); r != nil {
+			rows[i], expiresAt[i] = r.read(n)
+		}
+	}
+	return rows, expiresAt
+}
@@ -0,0 +1,130 @@
+package memdb
+
+// omap ordered map of string keys, a skip list; it isn't safe for
+// concurrent use, Mem lock it
+type omap struct {
+	head  node
+	level int
+	n     int
+	rnd   uint32
+}
+
+type node struct {
+	key  string
+	val  interface{}
+	next []*node
+}
+
+const maxLevel = 24
+
+func newOmap() *omap {
+	m := &omap{level: 1, rnd: 0x9e3779b9}
+	m.head.next = make([]*node, maxLevel)
+	return m
+}
+
+// randomLevel level of a new node, p = 1/4
+func (m *omap) randomLevel() int {
+	l := 1
+	for l < maxLevel {
+		m.rnd ^= m.rnd << 13
+		m.rnd ^= m.rnd >> 17
+		m.rnd ^= m.rnd << 5
+		if m.rnd&3 != 0 {
+			break
+		}
+		l++
+	}
+	return l
+}
+
+// seek the first node whose key >= key; prev[i] is the last node before it
+// at level i, if prev isn't nil
+func (m *omap) seek(key string, prev []*node) *node {
+	x := &m.head
+	for i := m.level - 1; i >= 0; i-- {
+		for x.next[i] != nil && x.next[i].key < key {
+			x = x.next[i]
+		}
+		if prev != nil {
+			prev[i] = x
+		}
+	}
+	return x.next[0]
+}
+
+func (m *omap) get(key string) (interface{}, bool) {
+	if x := m.seek(key, nil); x != nil && x.key == key {
+		return x.val, true
+	}
+	return nil, false
+}
+
+// set insert or replace
+func (m *omap) set(key string, val interface{}) {
+	var prev [maxLevel]*node
+	if x := m.seek(key, prev[:]); x != nil && x.key == key {
+		x.val = val
+		return
+	}
+	l := m.randomLevel()
+	for ; m.level < l; m.level++ {
+		prev[m.level] = &m.head
+	}
+	x := &node{key: key, val: val, next: make([]*node, l)}
+	for i := 0; i < l; i++ {
+		x.next[i], prev[i].next[i] = prev[i].next[i], x
+	}
+	m.n++
+}
+
+// delete return false if the key isn't exist
+func (m *omap) delete(key string) bool {
+	var prev [maxLevel]*node
+	x := m.seek(key, prev[:])
+	if x == nil || x.key != key {
+		return false
+	}
+	for i := range x.next {
+		prev[i].next[i] = x.next[i]
+	}
+	for m.level > 1 && m.head.next[m.level-1] == nil {
+		m.level--
+	}
+	m.n--
+	return true
+}
+
+func (m *omap) len() int {
+	return m.n
+}
+
+// ascend call fn with the keys >= start in order, until fn return false
+func (m *omap) ascend(start string, fn func(key string, val interface{}) bool) {
+	for x := m.seek(start, nil); x != nil; x = x.next[0] {
+		if !fn(x.key, x.val) {
+			return
+		}
+	}
+}
+
+// clone copy the map, the values are copied by cp
+func (m *omap) clone(cp func(val interface{}) interface{}) *omap {
+	r := newOmap()
+	var last [maxLevel]*node // 按顺序追加
+	for i := range last {
+		last[i] = &r.head
+	}
+	for x := m.head.next[0]; x != nil; x = x.next[0] {
+		l := r.randomLevel()
+		if l > r.level {
+			r.level = l
+		}
+		n := &node{key: x.key, val: cp(x.val), next: make([]*node, l)}
+		for i := 0; i < l; i++ {
+			last[i].next[i], last[i] = n, n
+		}
+		r.n++
+	}
+	return r
+}
@@ -0,0 +1,63 @@
+package memdb
+
+import (
+	"math/rand"
+	"sort"
+	"strconv"
+	"testing"
+)
+
+// keys of the map in order
+func keys(m *omap, start string) []string {
+	var r []string
+	m.ascend(start, func(key string, _ interface{}) bool {
+		r = append(r, key)
+		return true
+	})
+	return r
+}
+
+func TestOmap(t *testing.T) {
+	r := rand.New(rand.NewSource(1))
+	m, ref := newOmap(), map[string]int{}
+	for i := 0; i < 20000; i++ {
+		k := strconv.Itoa(r.Intn(2000))
+		if r.Intn(3) == 0 {
+			_, ok := ref[k]
+			if m.delete(k) != ok {
+				t.Fatal("delete", k)
+			}
+			delete(ref, k)
+		} else {
+			m.set(k, i)
+			ref[k] = i
+		}
+	}
+
+	var want []string
+	for k, v := range ref {
+		if got, ok := m.get(k); !ok || got.(int) != v {
+			t.Fatal("get", k, got, v)
+		}
+		want = append(want, k)
+	}
+	sort.Strings(want)
+	if m.len() != len(want) {
+		t.Fatal("len", m.len(), len(want))
+	}
+	got := keys(m, "")
+	for i := range want {
+		if got[i] != want[i] {
+			t.Fatal("order", i, got[i], want[i])
+		}
+	}
+	if from := keys(m, "5"); len(from) == 0 || from[0] < "5" || len(keys(m, "~")) != 0 {
+		t.Fatal("ascend from", from)
+	}
+
+	c := m.clone(func(v interface{}) interface{} { return v })
+	c.set("x", 0)
+	if _, ok := m.get("x"); ok || c.len() != m.len()+1 || len(keys(c, "")) != c.len() {
+		t.Fatal("clone")
+	}
+}
@@ -0,0 +1,77 @@
+package memdb
+
+import (
+	"sync"
+
+	"github.com/lysShub/kvdb/com"
+)
+
+// ScanBatch rows per batch of ParallelScan
+const ScanBatch = 256
+
+// ParallelScan read a table with workers goroutines, the records are read
+// under the lock then split to workers ranges of about the same count. fn
+// is called concurrently with a worker's batch(ScanBatch rows at most) in
+// order of the range; stop when fn return error
+func (d *Mem) ParallelScan(tableName string, workers int, fn func(worker int, ids []string, rows []map[string][]byte) error) error {
+	if workers <= 0 {
+		workers = 1
+	}
+	var rows = d.rows(tableName, com.IterOptions{})
+	var per = (len(rows) + workers - 1) / workers
+	if per == 0 {
+		return nil
+	}
+
+	var wg sync.WaitGroup
+	var errs = make([]error, workers)
+	var stop = make(chan struct{})
+	var once sync.Once
+	for i := 0; i*per < len(rows); i++ {
+		end := (i + 1) * per
+		if end > len(rows) {
+			end = len(rows)
+		}
+		wg.Add(1)
+		go func(worker int, part []rowEntry) {
+			defer wg.Done()
+			if errs[worker] = scanRange(worker, part, stop, fn); errs[worker] != nil {
+				once.Do(func() { close(stop) })
+			}
+		}(i, rows[i*per:end])
+	}
+	wg.Wait()
+	for _, err := range errs {
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func scanRange(worker int, part []rowEntry, stop chan struct{}, fn func(worker int, ids []string, rows []map[string][]byte) error) error {
+	var n = now()
+	for len(part) != 0 {
+		select {
+		case <-stop:
+			return nil
+		default:
+		}
+		batch := part
+		if len(batch) > ScanBatch {
+			batch = part[:ScanBatch]
+		}
+		part = part[len(batch):]
+
+		var ids = make([]string, len(batch))
+		var rows = make([]map[string][]byte, len(batch))
+		for i, e := range batch {
+			ids[i] = e.id
+			rows[i], _ = e.r.read(n)
+		}
+		if err := fn(worker, ids, rows); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,35 @@
+package memdb
+
+import "sync"
+
+// snapshot
+//
+// a snapshot is a Mem with a copy of the ordered maps, the records and
+// values are immutable so they are shared. Copying take O(keys + records)
+// under the read lock; all methods can be called on it, the writes only
+// change the snapshot
+
+// clone copy of the store, the records and values are shared
+func (s *store) clone() *store {
+	same := func(v interface{}) interface{} { return v }
+	return &store{
+		keys:   s.keys.clone(same),
+		tables: s.tables.clone(func(v interface{}) interface{} { return v.(*table).clone() }),
+		trash:  s.trash.clone(same),
+		seq:    s.seq,
+	}
+}
+
+// Snapshot a view of the db at now, Release it when done
+func (d *Mem) Snapshot() *Mem {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+	return &Mem{mu: &sync.RWMutex{}, st: d.st.clone()}
+}
+
+// Release drop the copy of a snapshot
+func (d *Mem) Release() {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.st = newStore()
+}
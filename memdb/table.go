@@ -0,0 +1,88 @@
+package memdb
+
+import (
+	"github.com/lysShub/kvdb/com"
+)
+
+// table management, the records are immutable, so a copied table share them
+
+// clone copy of the table, the records are shared
+func (t *table) clone() *table {
+	return &table{rows: t.rows.clone(func(v interface{}) interface{} { return v })}
+}
+
+// RenameTable
+func (d *Mem) RenameTable(oldName, newName string) error {
+	d.mu.Lock()
+	t := d.st.table(oldName, false)
+	if t == nil {
+		d.mu.Unlock()
+		return com.ErrTableNotExist
+	} else if d.st.table(newName, false) != nil {
+		d.mu.Unlock()
+		return com.ErrTableExist
+	}
+	d.st.tables.delete(oldName)
+	d.st.tables.set(newName, t)
+	d.mu.Unlock()
+	if d.Progress != nil {
+		d.Progress("rename", oldName, t.rows.len())
+	}
+	return nil
+}
+
+// CopyTable
+func (d *Mem) CopyTable(src, dst string) error {
+	d.mu.Lock()
+	t := d.st.table(src, false)
+	if t == nil {
+		d.mu.Unlock()
+		return com.ErrTableNotExist
+	} else if d.st.table(dst, false) != nil {
+		d.mu.Unlock()
+		return com.ErrTableExist
+	}
+	t = t.clone()
+	d.st.tables.set(dst, t)
+	d.mu.Unlock()
+	if d.Progress != nil {
+		d.Progress("copy", src, t.rows.len())
+	}
+	return nil
+}
+
+// CreateTable create a empty table if it isn't exist
+func (d *Mem) CreateTable(tableName string) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.st.table(tableName, true)
+	return nil
+}
+
+// TruncateTable delete all records in a table, the empty table is kept
+func (d *Mem) TruncateTable(tableName string) error {
+	d.mu.Lock()
+	var n int
+	if t := d.st.table(tableName, false); t != nil {
+		n = t.rows.len()
+		t.rows = newOmap()
+	}
+	d.mu.Unlock()
+	if d.Progress != nil {
+		d.Progress("truncate", tableName, n)
+	}
+	return nil
+}
+
+// IterateTable call fn with every record in a table in order, stop when fn
+// return error; the records are read before the first call, fn can write
+func (d *Mem) IterateTable(tableName string, fn func(id string, row map[string][]byte) error) error {
+	var n = now()
+	for _, e := range d.rows(tableName, com.IterOptions{}) {
+		fv, _ := e.r.read(n)
+		if err := fn(e.id, fv); err != nil {
+			return err
+		}
+	}
+	return nil
+}
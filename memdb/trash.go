@@ -0,0 +1,116 @@
+package memdb
+
+import (
+	"time"
+
+	"github.com/lysShub/kvdb/com"
+)
+
+// trash
+//
+// a soft deleted key, record or table is moved to the trash map under its
+// trash id, with the expire time kept
+
+// trashEntry a trash item and its data, one of key, row and table is set
+type trashEntry struct {
+	item  com.TrashItem
+	key   *cell
+	row   *row
+	table *table
+}
+
+// Trash move the key, record or table of item to the trash; a missing key
+// or record isn't trashed, a missing table is com.ErrTableNotExist
+func (d *Mem) Trash(item com.TrashItem) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	var n = now()
+	var e = &trashEntry{item: item}
+	switch item.Kind {
+	case com.TrashKey:
+		c, ok := d.st.key(item.Key, n)
+		if !ok {
+			return nil
+		}
+		e.key = &c
+		d.st.keys.delete(item.Key)
+	case com.TrashRow:
+		t := d.st.table(item.Table, false)
+		if e.row = t.row(item.RowID, n); e.row == nil {
+			return nil
+		}
+		t.rows.delete(item.RowID)
+	default:
+		if e.table = d.st.table(item.Table, false); e.table == nil {
+			return com.ErrTableNotExist
+		}
+		d.st.tables.delete(item.Table)
+	}
+	d.st.trash.set(item.ID, e)
+	return nil
+}
+
+// ListTrash the trash items in order of id
+func (d *Mem) ListTrash() ([]com.TrashItem, error) {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+	var r = make([]com.TrashItem, 0, d.st.trash.len())
+	d.st.trash.ascend("", func(_ string, v interface{}) bool {
+		r = append(r, v.(*trashEntry).item)
+		return true
+	})
+	return r, nil
+}
+
+// Restore move the trash item back, it's com.ErrRestoreExist if the key,
+// record or table is written again; return the restored item
+func (d *Mem) Restore(trashID string) (com.TrashItem, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	v, ok := d.st.trash.get(trashID)
+	if !ok {
+		return com.TrashItem{}, com.ErrTrashNotExist
+	}
+	var n = now()
+	e := v.(*trashEntry)
+	switch e.item.Kind {
+	case com.TrashKey:
+		if _, ok := d.st.key(e.item.Key, n); ok {
+			return e.item, com.ErrRestoreExist
+		}
+		d.st.keys.set(e.item.Key, *e.key)
+	case com.TrashRow:
+		t := d.st.table(e.item.Table, true)
+		if t.row(e.item.RowID, n) != nil {
+			return e.item, com.ErrRestoreExist
+		}
+		t.rows.set(e.item.RowID, e.row)
+		if e.row.meta.Version > d.st.seq { // 可能是Load之前删除的，版本不回退
+			d.st.seq = e.row.meta.Version
+		}
+	default:
+		if d.st.table(e.item.Table, false) != nil {
+			return e.item, com.ErrRestoreExist
+		}
+		d.st.tables.set(e.item.Table, e.table)
+	}
+	d.st.trash.delete(trashID)
+	return e.item, nil
+}
+
+// PurgeTrash delete the trash items deleted before t, return the count
+func (d *Mem) PurgeTrash(t time.Time) (int, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	var ids []string
+	d.st.trash.ascend("", func(id string, v interface{}) bool {
+		if v.(*trashEntry).item.DeletedAt.Before(t) {
+			ids = append(ids, id)
+		}
+		return true
+	})
+	for _, id := range ids {
+		d.st.trash.delete(id)
+	}
+	return len(ids), nil
+}
@@ -0,0 +1,53 @@
+package kvdb
+
+import (
+	"time"
+
+	"github.com/lysShub/kvdb/com"
+)
+
+// row versioning
+//
+// every record written by the table api carry a version and the update
+// time. The version is increased by every write of the record and never go
+// back, even if the record is deleted and created again; it's not the
+// backend's commit timestamp. A record not written with version, e.g. bulk
+// loaded, has version 0.
+//
+// optimistic concurrency: read the record with ReadTableRowMeta, and write
+// it back by UpdateRow with the version, it fails with ErrVersionConflict if
+// others wrote the record in between.
+
+// RowMeta version and update time of a record
+type RowMeta = com.RowMeta
+
+// ErrVersionConflict the record is written by others after the expected version
+var ErrVersionConflict = com.ErrVersionConflict
+
+// ReadTableRowMeta read a record and its meta, the cache isn't used
+func (d *KVDB) ReadTableRowMeta(tableName, id string) (Row, RowMeta) {
+	if d.Type == 0 {
+		return d.DH.bg.ReadTableRowMeta(tableName, id)
+	} else if d.Type == 1 {
+		return d.DH.bt.ReadTableRowMeta(tableName, id)
+	} else if d.Type == 2 {
+		return d.DH.mm.ReadTableRowMeta(tableName, id)
+	}
+	return nil, RowMeta{}
+}
+
+// UpdateRow create/update a record if its version is version, 0 is a new(or
+// not versioned) record; return the new version
+func (d *KVDB) UpdateRow(tableName, id string, version uint64, p map[string][]byte, ttl ...time.Duration) (uint64, error) {
+	if d.DH.ch != nil {
+		defer d.DH.ch.InvalidateRow(tableName, id, fieldNames(p)...)
+	}
+	if d.Type == 0 {
+		return d.DH.bg.UpdateRow(tableName, id, version, p, ttl...)
+	} else if d.Type == 1 {
+		return d.DH.bt.UpdateRow(tableName, id, version, p)
+	} else if d.Type == 2 {
+		return d.DH.mm.UpdateRow(tableName, id, version, p, ttl...)
+	}
+	return 0, errType
+}
@@ -0,0 +1,102 @@
+package kvdb
+
+// multi-get
+//
+// the values are read in one transaction, in sorted order of the keys, and
+// returned in order of the input; missing are the indexes of the keys(ids)
+// that don't exist, their values are nil. The cached values are used first.
+
+// ReadKeys read the values of keys
+func (d *KVDB) ReadKeys(keys ...string) (values [][]byte, missing []int) {
+	values = make([][]byte, len(keys))
+	var idx []int // 未缓存的
+	var rest []string
+	for i, k := range keys {
+		if d.DH.ch != nil {
+			if v, ok := d.DH.ch.GetKey(k); ok {
+				values[i] = v
+				continue
+			}
+		}
+		idx, rest = append(idx, i), append(rest, k)
+	}
+	if len(rest) == 0 {
+		return values, nil
+	}
+
+	var vs [][]byte
+	var found []bool
+	var expiresAt []uint64
+	var epoch uint64
+	if d.DH.ch != nil {
+		epoch = d.DH.ch.Epoch()
+	}
+	if d.Type == 0 {
+		vs, expiresAt, found = d.DH.bg.ReadKeys(rest)
+	} else if d.Type == 1 {
+		vs, found = d.DH.bt.ReadKeys(rest)
+		expiresAt = make([]uint64, len(rest))
+	} else if d.Type == 2 {
+		vs, expiresAt, found = d.DH.mm.ReadKeys(rest)
+	} else {
+		return values, idx
+	}
+	for j, i := range idx {
+		if !found[j] {
+			missing = append(missing, i)
+			continue
+		}
+		values[i] = vs[j]
+		if d.DH.ch != nil && vs[j] != nil {
+			d.DH.ch.PutKey(epoch, keys[i], vs[j], expiresAt[j])
+		}
+	}
+	return values, missing
+}
+
+// ReadTableRows read the records of ids in a table
+func (d *KVDB) ReadTableRows(tableName string, ids ...string) (rows []Row, missing []int) {
+	rows = make([]Row, len(ids))
+	var idx []int // 未缓存的
+	var rest []string
+	for i, id := range ids {
+		if d.DH.ch != nil {
+			if r, ok := d.DH.ch.GetRow(tableName, id); ok {
+				rows[i] = r
+				continue
+			}
+		}
+		idx, rest = append(idx, i), append(rest, id)
+	}
+	if len(rest) == 0 {
+		return rows, nil
+	}
+
+	var rs []map[string][]byte
+	var expiresAt []uint64
+	var epoch uint64
+	if d.DH.ch != nil {
+		epoch = d.DH.ch.Epoch()
+	}
+	if d.Type == 0 {
+		rs, expiresAt = d.DH.bg.ReadTableRows(tableName, rest)
+	} else if d.Type == 1 {
+		rs = d.DH.bt.ReadTableRows(tableName, rest)
+		expiresAt = make([]uint64, len(rest))
+	} else if d.Type == 2 {
+		rs, expiresAt = d.DH.mm.ReadTableRows(tableName, rest)
+	} else {
+		return rows, idx
+	}
+	for j, i := range idx {
+		if len(rs[j]) == 0 {
+			missing = append(missing, i)
+			continue
+		}
+		rows[i] = rs[j]
+		if d.DH.ch != nil {
+			d.DH.ch.PutRow(epoch, tableName, ids[i], rs[j], expiresAt[j])
+		}
+	}
+	return rows, missing
+}
@@ -0,0 +1,151 @@
+package kvdb
+
+import (
+	"encoding/base64"
+	"errors"
+	"hash/crc32"
+)
+
+// pagination
+//
+// a page token is the last id(key) of the previous page, so it keeps valid
+// whatever is written between two pages: the next page start right after
+// it, a record added before it is skipped and a deleted one is never
+// returned. The token is opaque, and bound to the table and the direction.
+
+// ErrPageToken the page token is malformed, or for another table or direction
+var ErrPageToken error = errors.New("kvdb: invalid page token")
+
+// errStop stop an iteration early
+var errStop error = errors.New("kvdb: stop")
+
+// PageOptions options of reading a page
+type PageOptions struct {
+	After   string // Next of the previous page, "" is the first page
+	Limit   int    // records per page, default 100
+	Reverse bool   // from the last to the first
+}
+
+// PageRow a record with its id, of a page or a scan batch
+type PageRow struct {
+	ID  string
+	Row Row
+}
+
+// Page a page of records
+type Page struct {
+	Rows []PageRow
+	Next string // token of the next page, "" is the last page
+}
+
+// KeyValue a key/value of a page
+type KeyValue struct {
+	Key   string
+	Value []byte
+}
+
+// KeyPage a page of key/values
+type KeyPage struct {
+	Keys []KeyValue
+	Next string // token of the next page, "" is the last page
+}
+
+const (
+	pageTokenVersion byte = 1
+	pageKindRow      byte = 'r'
+	pageKindKey      byte = 'k'
+)
+
+// pageToken version | kind | direction | crc32(table) | last id
+func pageToken(kind byte, tableName string, reverse bool, last string) string {
+	var b = make([]byte, 0, 7+len(last))
+	b = append(b, pageTokenVersion, kind, 0)
+	if reverse {
+		b[2] = 1
+	}
+	c := crc32.ChecksumIEEE([]byte(tableName))
+	b = append(b, byte(c>>24), byte(c>>16), byte(c>>8), byte(c))
+	b = append(b, last...)
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+func parsePageToken(kind byte, tableName string, reverse bool, token string) (string, error) {
+	b, err := base64.RawURLEncoding.DecodeString(token)
+	if err != nil || len(b) < 7 {
+		return "", ErrPageToken
+	}
+	if pageToken(kind, tableName, reverse, "") != base64.RawURLEncoding.EncodeToString(b[:7]) {
+		return "", ErrPageToken
+	}
+	return string(b[7:]), nil
+}
+
+// pageBounds the iterate options after the last id
+func pageBounds(last string, opts PageOptions) IterOptions {
+	if opts.After == "" {
+		return IterOptions{Reverse: opts.Reverse}
+	} else if opts.Reverse {
+		return IterOptions{End: last, Reverse: true}
+	}
+	return IterOptions{Start: last + "\x00"} // last 之后最小的id
+}
+
+func pageLimit(opts PageOptions) int {
+	if opts.Limit <= 0 {
+		return 100
+	}
+	return opts.Limit
+}
+
+// ReadTablePage read a page of records in a table in id order
+func (d *KVDB) ReadTablePage(tableName string, opts PageOptions) (Page, error) {
+	var r Page
+	var last string
+	var err error
+	if opts.After != "" {
+		if last, err = parsePageToken(pageKindRow, tableName, opts.Reverse, opts.After); err != nil {
+			return r, err
+		}
+	}
+
+	it, err := d.NewRowIterator(tableName, pageBounds(last, opts))
+	if err != nil {
+		return r, err
+	}
+	defer it.Close()
+	limit := pageLimit(opts)
+	for it.Next() {
+		if len(r.Rows) == limit {
+			r.Next = pageToken(pageKindRow, tableName, opts.Reverse, r.Rows[limit-1].ID)
+			break
+		}
+		r.Rows = append(r.Rows, PageRow{ID: it.ID(), Row: it.Row()})
+	}
+	return r, it.Err()
+}
+
+// ReadKeysPage read a page of key/values in key order
+func (d *KVDB) ReadKeysPage(opts PageOptions) (KeyPage, error) {
+	var r KeyPage
+	var last string
+	var err error
+	if opts.After != "" {
+		if last, err = parsePageToken(pageKindKey, "", opts.Reverse, opts.After); err != nil {
+			return r, err
+		}
+	}
+
+	limit := pageLimit(opts)
+	err = d.IterateKeys(pageBounds(last, opts), func(key string, value []byte) error {
+		if len(r.Keys) == limit {
+			r.Next = pageToken(pageKindKey, "", opts.Reverse, r.Keys[limit-1].Key)
+			return errStop
+		}
+		r.Keys = append(r.Keys, KeyValue{Key: key, Value: value})
+		return nil
+	})
+	if err == errStop {
+		err = nil
+	}
+	return r, err
+}
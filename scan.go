@@ -0,0 +1,27 @@
+package kvdb
+
+// ParallelScan read a whole table with workers goroutines, for analytics
+// jobs. fn is called concurrently by the workers, every call get a batch of
+// rows read by the worker; the rows are in id order within a batch, but the
+// batches aren't in order. Stop and return the error when fn return error.
+//
+// badgerdb split the table by its SSTables with badger's Stream, boltdb
+// split the ids to ranges of about the same count. Every worker read with
+// its own transaction, so it isn't a snapshot of the table while it's written.
+func (d *KVDB) ParallelScan(tableName string, workers int, fn func(worker int, batch []PageRow) error) error {
+	var f = func(worker int, ids []string, rows []map[string][]byte) error {
+		var batch = make([]PageRow, len(ids))
+		for i, id := range ids {
+			batch[i] = PageRow{ID: id, Row: rows[i]}
+		}
+		return fn(worker, batch)
+	}
+	if d.Type == 0 {
+		return d.DH.bg.ParallelScan(tableName, workers, f)
+	} else if d.Type == 1 {
+		return d.DH.bt.ParallelScan(tableName, workers, f)
+	} else if d.Type == 2 {
+		return d.DH.mm.ParallelScan(tableName, workers, f)
+	}
+	return errType
+}
@@ -0,0 +1,451 @@
+package kvdb
+
+import (
+	"errors"
+	"log"
+	"runtime"
+	"runtime/debug"
+	"sync"
+	"time"
+)
+
+// point-in-time snapshot
+//
+// a Snapshot read the db as it was when it's created: badgerdb pin a read
+// timestamp, boltdb keep a read transaction open, memdb copy its ordered
+// maps. It has the read API of KVDB except ParallelScan, the cache isn't
+// used. It holds old versions(badgerdb), pages(boltdb) or a copy(memdb), and a boltdb write that grow the file over
+// KVDB.MmapSize wait for it, so close it soon; the ones forgotten are reported, see
+// KVDB.OnSnapshotLeak.
+
+// ErrSnapshotClosed the snapshot is closed
+var ErrSnapshotClosed error = errors.New("snapshot is closed")
+
+// defaultSnapshotMaxAge default of KVDB.SnapshotMaxAge
+const defaultSnapshotMaxAge = 10 * time.Minute
+
+// SnapshotInfo a snapshot that isn't closed
+type SnapshotInfo struct {
+	Created time.Time
+	Stack   string // where it's created
+	Reason  string // why it's reported
+}
+
+// snapshots the open snapshots of a db
+type snapshots struct {
+	mu   sync.Mutex
+	open map[*snapshot]struct{}
+}
+
+// Snapshot read-only view of the db at one point in time, must be closed;
+// it's safe for concurrent use
+type Snapshot struct {
+	*snapshot
+}
+
+// snapshot the state of a Snapshot, the db keep it rather than the Snapshot,
+// so a forgotten Snapshot can be garbage collected and reported; a
+// RowIterator hold the Snapshot, the state only hold the backend iterators
+type snapshot struct {
+	d      *KVDB // owner
+	db     *KVDB // the snapshot backends, no cache
+	info   SnapshotInfo
+	timer  *time.Timer
+	mu     sync.RWMutex // reads hold R, Close hold W
+	closed bool
+
+	itersMu sync.Mutex
+	iters   map[rowIterator]struct{}
+}
+
+// Snapshot create a snapshot of the db at now
+func (d *KVDB) Snapshot() (*Snapshot, error) {
+	var db = &KVDB{Type: d.Type}
+	if d.Type == 0 {
+		db.DH.bg = d.DH.bg.Snapshot()
+	} else if d.Type == 1 {
+		b, err := d.DH.bt.Snapshot()
+		if err != nil {
+			return nil, err
+		}
+		db.DH.bt = b
+	} else if d.Type == 2 {
+		db.DH.mm = d.DH.mm.Snapshot()
+	} else {
+		return nil, errType
+	}
+	return d.newSnapshot(db), nil
+}
+
+// newSnapshot track the snapshot backends db
+func (d *KVDB) newSnapshot(db *KVDB) *Snapshot {
+	s := &snapshot{
+		d:     d,
+		db:    db,
+		info:  SnapshotInfo{Created: time.Now(), Stack: string(debug.Stack())},
+		iters: make(map[rowIterator]struct{}),
+	}
+
+	maxAge := d.SnapshotMaxAge
+	if maxAge <= 0 {
+		maxAge = defaultSnapshotMaxAge
+	}
+	s.timer = time.AfterFunc(maxAge, func() {
+		d.reportSnapshot(s.info, "open for more than "+maxAge.String())
+	})
+	d.DH.sn.mu.Lock()
+	d.DH.sn.open[s] = struct{}{}
+	d.DH.sn.mu.Unlock()
+
+	r := &Snapshot{s}
+	runtime.SetFinalizer(r, func(r *Snapshot) {
+		if r.release() {
+			d.reportSnapshot(r.info, "garbage collected without Close")
+		}
+	})
+	return r
+}
+
+// Ts the badgerdb read timestamp of the snapshot, see ReadAt; 0 on boltdb and memdb
+func (s *Snapshot) Ts() uint64 {
+	if s.db.Type == 0 {
+		return s.db.DH.bg.ReadTs()
+	}
+	return 0
+}
+
+// reportSnapshot report a snapshot isn't closed
+func (d *KVDB) reportSnapshot(info SnapshotInfo, reason string) {
+	info.Reason = reason
+	if d.OnSnapshotLeak != nil {
+		d.OnSnapshotLeak(info)
+		return
+	}
+	log.Printf("kvdb: snapshot created at %s isn't closed, %s:\n%s", info.Created.Format(time.RFC3339), reason, info.Stack)
+}
+
+// closeSnapshots release the open snapshots when the db is closing, a boltdb
+// can't be closed with a read transaction
+func (d *KVDB) closeSnapshots() {
+	if d.DH.sn == nil {
+		return
+	}
+	d.DH.sn.mu.Lock()
+	var open = make([]*snapshot, 0, len(d.DH.sn.open))
+	for s := range d.DH.sn.open {
+		open = append(open, s)
+	}
+	d.DH.sn.mu.Unlock()
+
+	for _, s := range open {
+		if s.release() {
+			d.reportSnapshot(s.info, "open when the db is closed")
+		}
+	}
+}
+
+// Close release the snapshot and close its iterators, the reads after it
+// return nothing or ErrSnapshotClosed
+func (s *Snapshot) Close() error {
+	s.release()
+	runtime.SetFinalizer(s, nil)
+	return nil
+}
+
+// release return false if it's already released
+func (s *snapshot) release() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		return false
+	}
+	s.closed = true
+	s.timer.Stop()
+
+	s.itersMu.Lock()
+	var iters = make([]rowIterator, 0, len(s.iters))
+	for it := range s.iters {
+		iters = append(iters, it)
+	}
+	s.itersMu.Unlock()
+	for _, it := range iters {
+		it.Close() // badgerdb can't discard a transaction with open iterators
+	}
+	if s.db.Type == 0 {
+		s.db.DH.bg.Release()
+	} else if s.db.Type == 1 {
+		s.db.DH.bt.Release()
+	} else if s.db.Type == 2 {
+		s.db.DH.mm.Release()
+	}
+
+	s.d.DH.sn.mu.Lock()
+	delete(s.d.DH.sn.open, s)
+	s.d.DH.sn.mu.Unlock()
+	return true
+}
+
+// begin start a read, return nil if the snapshot is closed; call s.end
+// after the read if it isn't nil
+func (s *snapshot) begin() *KVDB {
+	s.mu.RLock()
+	if s.closed {
+		s.mu.RUnlock()
+		return nil
+	}
+	return s.db
+}
+
+func (s *snapshot) end() {
+	s.mu.RUnlock()
+}
+
+// forget remove a closed iterator
+func (s *snapshot) forget(it rowIterator) {
+	s.itersMu.Lock()
+	delete(s.iters, it)
+	s.itersMu.Unlock()
+}
+
+// key/value
+
+// ReadKey
+func (s *Snapshot) ReadKey(key string) []byte {
+	db := s.begin()
+	if db == nil {
+		return nil
+	}
+	defer s.end()
+	return db.ReadKey(key)
+}
+
+// ReadKeys see KVDB.ReadKeys
+func (s *Snapshot) ReadKeys(keys ...string) (values [][]byte, missing []int) {
+	db := s.begin()
+	if db == nil {
+		return make([][]byte, len(keys)), allIndexes(len(keys))
+	}
+	defer s.end()
+	return db.ReadKeys(keys...)
+}
+
+// ReadKeysPage see KVDB.ReadKeysPage
+func (s *Snapshot) ReadKeysPage(opts PageOptions) (KeyPage, error) {
+	db := s.begin()
+	if db == nil {
+		return KeyPage{}, ErrSnapshotClosed
+	}
+	defer s.end()
+	return db.ReadKeysPage(opts)
+}
+
+// IterateKeys see KVDB.IterateKeys
+func (s *Snapshot) IterateKeys(opts IterOptions, fn func(key string, value []byte) error) error {
+	db := s.begin()
+	if db == nil {
+		return ErrSnapshotClosed
+	}
+	defer s.end()
+	return db.IterateKeys(opts, fn)
+}
+
+// table
+
+// ReadTable
+func (s *Snapshot) ReadTable(tableName string) map[string]map[string][]byte {
+	db := s.begin()
+	if db == nil {
+		return nil
+	}
+	defer s.end()
+	return db.ReadTable(tableName)
+}
+
+// ReadTableExist
+func (s *Snapshot) ReadTableExist(tableName string) bool {
+	db := s.begin()
+	if db == nil {
+		return false
+	}
+	defer s.end()
+	return db.ReadTableExist(tableName)
+}
+
+// ReadTableRow
+func (s *Snapshot) ReadTableRow(tableName, id string) map[string][]byte {
+	db := s.begin()
+	if db == nil {
+		return nil
+	}
+	defer s.end()
+	return db.ReadTableRow(tableName, id)
+}
+
+// ReadTableRowExist
+func (s *Snapshot) ReadTableRowExist(tableName, id string) bool {
+	db := s.begin()
+	if db == nil {
+		return false
+	}
+	defer s.end()
+	return db.ReadTableRowExist(tableName, id)
+}
+
+// ReadTableValue
+func (s *Snapshot) ReadTableValue(tableName, id, field string) []byte {
+	db := s.begin()
+	if db == nil {
+		return nil
+	}
+	defer s.end()
+	return db.ReadTableValue(tableName, id, field)
+}
+
+// ReadTableRowMeta see KVDB.ReadTableRowMeta
+func (s *Snapshot) ReadTableRowMeta(tableName, id string) (Row, RowMeta) {
+	db := s.begin()
+	if db == nil {
+		return nil, RowMeta{}
+	}
+	defer s.end()
+	return db.ReadTableRowMeta(tableName, id)
+}
+
+// ReadTableRows see KVDB.ReadTableRows
+func (s *Snapshot) ReadTableRows(tableName string, ids ...string) (rows []Row, missing []int) {
+	db := s.begin()
+	if db == nil {
+		return make([]Row, len(ids)), allIndexes(len(ids))
+	}
+	defer s.end()
+	return db.ReadTableRows(tableName, ids...)
+}
+
+// ReadTableLimits see KVDB.ReadTableLimits
+func (s *Snapshot) ReadTableLimits(tableName, field, exp string, value int) []string {
+	db := s.begin()
+	if db == nil {
+		return nil
+	}
+	defer s.end()
+	return db.ReadTableLimits(tableName, field, exp, value)
+}
+
+// ReadTablePage see KVDB.ReadTablePage
+func (s *Snapshot) ReadTablePage(tableName string, opts PageOptions) (Page, error) {
+	db := s.begin()
+	if db == nil {
+		return Page{}, ErrSnapshotClosed
+	}
+	defer s.end()
+	return db.ReadTablePage(tableName, opts)
+}
+
+// IterateTable see KVDB.IterateTable
+func (s *Snapshot) IterateTable(tableName string, fn func(id string, row Row) error) error {
+	db := s.begin()
+	if db == nil {
+		return ErrSnapshotClosed
+	}
+	defer s.end()
+	return db.IterateTable(tableName, fn)
+}
+
+// NewRowIterator see KVDB.NewRowIterator, it's closed with the snapshot
+func (s *Snapshot) NewRowIterator(tableName string, opts IterOptions) (*RowIterator, error) {
+	db := s.begin()
+	if db == nil {
+		return nil, ErrSnapshotClosed
+	}
+	defer s.end()
+	it, err := db.NewRowIterator(tableName, opts)
+	if err != nil {
+		return nil, err
+	}
+	it.snap = s
+	s.itersMu.Lock()
+	s.iters[it.it] = struct{}{}
+	s.itersMu.Unlock()
+	return it, nil
+}
+
+// ListTables see KVDB.ListTables
+func (s *Snapshot) ListTables() []string {
+	db := s.begin()
+	if db == nil {
+		return nil
+	}
+	defer s.end()
+	return db.ListTables()
+}
+
+// ListRowIDs see KVDB.ListRowIDs
+func (s *Snapshot) ListRowIDs(tableName string, opts ListOptions) []string {
+	db := s.begin()
+	if db == nil {
+		return nil
+	}
+	defer s.end()
+	return db.ListRowIDs(tableName, opts)
+}
+
+// ListFields see KVDB.ListFields
+func (s *Snapshot) ListFields(tableName string) map[string]int {
+	db := s.begin()
+	if db == nil {
+		return nil
+	}
+	defer s.end()
+	return db.ListFields(tableName)
+}
+
+// CountRows see KVDB.CountRows
+func (s *Snapshot) CountRows(tableName string) int {
+	db := s.begin()
+	if db == nil {
+		return 0
+	}
+	defer s.end()
+	return db.CountRows(tableName)
+}
+
+// binary names, see binary.go
+
+// ReadKeyBytes read a value
+func (s *Snapshot) ReadKeyBytes(key []byte) []byte {
+	return s.ReadKey(string(key))
+}
+
+// ReadTableBytes read all date in a table, the map keys hold the raw id and field bytes
+func (s *Snapshot) ReadTableBytes(tableName []byte) map[string]map[string][]byte {
+	return s.ReadTable(string(tableName))
+}
+
+// ReadTableExistBytes judge the table is exist
+func (s *Snapshot) ReadTableExistBytes(tableName []byte) bool {
+	return s.ReadTableExist(string(tableName))
+}
+
+// ReadTableRowBytes read a record in a table
+func (s *Snapshot) ReadTableRowBytes(tableName, id []byte) map[string][]byte {
+	return s.ReadTableRow(string(tableName), string(id))
+}
+
+// ReadTableRowExistBytes judge a record is exist in a table
+func (s *Snapshot) ReadTableRowExistBytes(tableName, id []byte) bool {
+	return s.ReadTableRowExist(string(tableName), string(id))
+}
+
+// ReadTableValueBytes read a field's value of some one record in a table
+func (s *Snapshot) ReadTableValueBytes(tableName, id, field []byte) []byte {
+	return s.ReadTableValue(string(tableName), string(id), string(field))
+}
+
+// allIndexes 0, 1, ..., n-1
+func allIndexes(n int) []int {
+	var r = make([]int, n)
+	for i := range r {
+		r[i] = i
+	}
+	return r
+}
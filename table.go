@@ -0,0 +1,128 @@
+package kvdb
+
+import (
+	"github.com/lysShub/kvdb/com"
+)
+
+// table management
+
+// Progress report of a long running table operation, see KVDB.Progress
+type Progress = com.Progress
+
+var (
+	// ErrTableExist the target table is already exist
+	ErrTableExist = com.ErrTableExist
+	// ErrTableNotExist the table is not exist
+	ErrTableNotExist = com.ErrTableNotExist
+)
+
+// copyChunkRows rows written to another database per chunk
+const copyChunkRows = 1000
+
+// RenameTable rename a table, newName must not exist
+// atomic on boltdb and memdb, and on badgerdb if the table fit in one transaction
+func (d *KVDB) RenameTable(oldName, newName string) error {
+	if d.DH.ch != nil {
+		defer d.DH.ch.InvalidateTable(newName)
+		defer d.DH.ch.InvalidateTable(oldName)
+	}
+	if d.Type == 0 {
+		return d.DH.bg.RenameTable(oldName, newName)
+	} else if d.Type == 1 {
+		return d.DH.bt.RenameTable(oldName, newName)
+	} else if d.Type == 2 {
+		return d.DH.mm.RenameTable(oldName, newName)
+	}
+	return errType
+}
+
+// CopyTable copy the table src to dst, dst must not exist
+// target is the destination database, default is d; it can use another
+// backend, the rows are copied in chunks, and the TTL of values is lost
+func (d *KVDB) CopyTable(src, dst string, target ...*KVDB) error {
+	if len(target) != 0 && target[0] != d {
+		return d.copyTableTo(src, dst, target[0])
+	}
+
+	if d.DH.ch != nil {
+		defer d.DH.ch.InvalidateTable(dst)
+	}
+	if d.Type == 0 {
+		return d.DH.bg.CopyTable(src, dst)
+	} else if d.Type == 1 {
+		return d.DH.bt.CopyTable(src, dst)
+	} else if d.Type == 2 {
+		return d.DH.mm.CopyTable(src, dst)
+	}
+	return errType
+}
+
+func (d *KVDB) copyTableTo(src, dst string, t *KVDB) error {
+	if !d.ReadTableExist(src) {
+		return ErrTableNotExist
+	} else if t.ReadTableExist(dst) {
+		return ErrTableExist
+	} else if err := t.createTable(dst); err != nil { // 空表也复制
+		return err
+	}
+
+	var done int
+	var chunk map[string]map[string][]byte = make(map[string]map[string][]byte)
+	flush := func() error {
+		if err := t.SetTable(dst, chunk); err != nil {
+			return err
+		}
+		for _, row := range chunk {
+			done = done + len(row)
+		}
+		chunk = make(map[string]map[string][]byte)
+		if d.Progress != nil {
+			d.Progress("copy", src, done)
+		}
+		return nil
+	}
+
+	err := d.IterateTable(src, func(id string, row map[string][]byte) error {
+		chunk[id] = row
+		if len(chunk) >= copyChunkRows {
+			return flush()
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	} else if len(chunk) != 0 {
+		return flush()
+	}
+	return nil
+}
+
+// createTable create a empty table if it isn't exist
+func (d *KVDB) createTable(tableName string) error {
+	if d.DH.ch != nil {
+		defer d.DH.ch.InvalidateTable(tableName)
+	}
+	if d.Type == 0 {
+		return d.DH.bg.CreateTable(tableName)
+	} else if d.Type == 1 {
+		return d.DH.bt.CreateTable(tableName)
+	} else if d.Type == 2 {
+		return d.DH.mm.CreateTable(tableName)
+	}
+	return errType
+}
+
+// TruncateTable delete all records in a table, the empty table is kept
+func (d *KVDB) TruncateTable(tableName string) error {
+	if d.DH.ch != nil {
+		defer d.DH.ch.InvalidateTable(tableName)
+	}
+	if d.Type == 0 {
+		return d.DH.bg.TruncateTable(tableName)
+	} else if d.Type == 1 {
+		return d.DH.bt.TruncateTable(tableName)
+	} else if d.Type == 2 {
+		return d.DH.mm.TruncateTable(tableName)
+	}
+	return errType
+}
@@ -0,0 +1,142 @@
+package kvdb
+
+import (
+	"fmt"
+	"sync/atomic"
+	"time"
+
+	"github.com/lysShub/kvdb/com"
+)
+
+// soft delete
+//
+// with KVDB.SoftDelete, DeleteKey, DeleteTableRow and DeleteTable move the
+// data into a trash namespace rather than delete it(boltdb in one
+// transaction, badgerdb as RenameTable, memdb under its lock); it can be listed, restored, and
+// is purged after KVDB.TrashRetention. The other deletes(TruncateTable,
+// DeleteTableValue, batches and TTL) aren't soft.
+
+// trash item kinds
+const (
+	TrashKey   = com.TrashKey
+	TrashRow   = com.TrashRow
+	TrashTable = com.TrashTable
+)
+
+// TrashItem a soft deleted key/value, record or table
+type TrashItem = com.TrashItem
+
+var (
+	// ErrTrashNotExist the trash item is not exist, it's restored or purged
+	ErrTrashNotExist = com.ErrTrashNotExist
+	// ErrRestoreExist the deleted data is written again, Restore doesn't overwrite it
+	ErrRestoreExist = com.ErrRestoreExist
+)
+
+// trashPurgeInterval max interval of the purge job
+const trashPurgeInterval = time.Hour
+
+var trashSeq uint32
+
+// newTrashID ids sort in order of deletion
+func newTrashID(t time.Time) string {
+	return fmt.Sprintf("%016x%08x", t.UnixNano(), atomic.AddUint32(&trashSeq, 1))
+}
+
+// trash move the data of item to the trash
+func (d *KVDB) trash(item TrashItem) error {
+	item.DeletedAt = time.Now()
+	item.ID = newTrashID(item.DeletedAt)
+	if d.Type == 0 {
+		return d.DH.bg.Trash(item)
+	} else if d.Type == 1 {
+		return d.DH.bt.Trash(item)
+	} else if d.Type == 2 {
+		return d.DH.mm.Trash(item)
+	}
+	return errType
+}
+
+// ListTrash the trash items in order of deletion
+func (d *KVDB) ListTrash() ([]TrashItem, error) {
+	if d.Type == 0 {
+		return d.DH.bg.ListTrash()
+	} else if d.Type == 1 {
+		return d.DH.bt.ListTrash()
+	} else if d.Type == 2 {
+		return d.DH.mm.ListTrash()
+	}
+	return nil, errType
+}
+
+// Restore move a trash item back to where it's deleted, it's ErrRestoreExist
+// if the key, record or table is written again
+func (d *KVDB) Restore(trashID string) error {
+	var item TrashItem
+	var err error
+	if d.Type == 0 {
+		item, err = d.DH.bg.Restore(trashID)
+	} else if d.Type == 1 {
+		item, err = d.DH.bt.Restore(trashID)
+	} else if d.Type == 2 {
+		item, err = d.DH.mm.Restore(trashID)
+	} else {
+		return errType
+	}
+	if err == nil && d.DH.ch != nil {
+		if item.Kind == TrashKey {
+			d.DH.ch.InvalidateKey(item.Key)
+		} else {
+			d.DH.ch.InvalidateTable(item.Table)
+		}
+	}
+	return err
+}
+
+// PurgeTrash delete the trash items deleted before t for ever, return the count
+func (d *KVDB) PurgeTrash(t time.Time) (int, error) {
+	if d.Type == 0 {
+		return d.DH.bg.PurgeTrash(t)
+	} else if d.Type == 1 {
+		return d.DH.bt.PurgeTrash(t)
+	} else if d.Type == 2 {
+		return d.DH.mm.PurgeTrash(t)
+	}
+	return 0, errType
+}
+
+// purger the job purging the trash items older than TrashRetention
+type purger struct {
+	stop chan struct{}
+	done chan struct{}
+}
+
+func (d *KVDB) startPurge() {
+	interval := trashPurgeInterval
+	if d.TrashRetention < interval {
+		interval = d.TrashRetention
+	}
+	p := &purger{stop: make(chan struct{}), done: make(chan struct{})}
+	d.DH.pg = p
+	go func() {
+		defer close(p.done)
+		t := time.NewTicker(interval)
+		defer t.Stop()
+		for {
+			select {
+			case <-p.stop:
+				return
+			case <-t.C:
+				d.PurgeTrash(time.Now().Add(-d.TrashRetention))
+			}
+		}
+	}()
+}
+
+func (d *KVDB) stopPurge() {
+	if d.DH.pg != nil {
+		close(d.DH.pg.stop)
+		<-d.DH.pg.done
+		d.DH.pg = nil
+	}
+}